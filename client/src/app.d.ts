@@ -10,7 +10,7 @@ declare global {
     }
 }
 
-export { SignUpForm, ShortURLRespone, Link };
+export { SignUpForm, ShortURLRespone, Link, LinkPage };
 
 
 interface SignUpForm {
@@ -27,9 +27,18 @@ interface ShortURLRespone {
 
 interface Link {
     id: int
+    short_code: string
     original_url: string
     short_url: string
+    title?: string
     created_at: string
     clicks: int
     is_enabled: bool
+    flagged_at?: string
+    flag_reason?: string
+}
+
+interface LinkPage {
+    links: Link[]
+    next_cursor?: string
 }
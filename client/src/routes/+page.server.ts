@@ -25,7 +25,7 @@ export const actions = {
         const longURL = formData.get('longurl');
         const cookie = event.cookies.get("sessionId");
 
-        const response = await fetch(`${PRIVATE_BASE_URL}/links`, {
+        const response = await fetch(`${PRIVATE_BASE_URL}/api/v1/links`, {
             method: 'POST',
             headers: {
                 Accept: 'application/json',
@@ -42,7 +42,7 @@ export const actions = {
 
         if (response.ok) {
             return {
-                longURL: data.long_url,
+                longURL: data.original_url,
                 shortURL: data.short_url
             }
         }
@@ -1,14 +1,19 @@
 import { fail, redirect, type Actions } from "@sveltejs/kit";
 import type { PageServerLoad, RequestEvent } from "../$types";
 import { PRIVATE_BASE_URL } from "$env/static/private";
-import type { Link } from "../../app";
+import type { LinkPage } from "../../app";
 
 export const load: PageServerLoad = async (event: RequestEvent) => {
     const cookie = event.cookies.get("sessionId");
     if (!cookie) {
         throw redirect(302, "/login");
     }
-    const response = await fetch(`${PRIVATE_BASE_URL}/links`, {
+    const params = new URLSearchParams();
+    const cursor = event.url.searchParams.get("cursor");
+    if (cursor) {
+        params.set("cursor", cursor);
+    }
+    const response = await fetch(`${PRIVATE_BASE_URL}/api/v1/links?${params}`, {
         method: 'GET',
         headers: {
             Accept: 'application/json',
@@ -25,10 +30,10 @@ export const load: PageServerLoad = async (event: RequestEvent) => {
         throw redirect(302, '/login');
     }
     if (response.ok) {
-        const res: Link[] = await response.json();
-        res.reverse();
+        const res: LinkPage = await response.json();
         return {
-            links: res,
+            links: res.links,
+            nextCursor: res.next_cursor,
             cookie: cookie
         };
     }
@@ -47,13 +52,13 @@ export const actions: Actions = {
 
         try {
             const splits = shortUrl.split('/');
-            const response = await fetch(`${PRIVATE_BASE_URL}/${splits[splits.length - 1]}`, {
-                method: 'POST',
+            const response = await fetch(`${PRIVATE_BASE_URL}/api/v1/links/${splits[splits.length - 1]}`, {
+                method: 'PATCH',
                 headers: {
                     'Content-Type': 'application/json',
                     Authorization: `Bearer ${event.cookies.get("sessionId")}`, // Adjust according to your auth logic
                 },
-                body: JSON.stringify({ long_url: originalUrl }),
+                body: JSON.stringify({ original_url: originalUrl }),
             });
 
             if (response.ok) {
@@ -80,12 +85,13 @@ export const actions: Actions = {
             const splits = shortUrl.split('/');
             console.log(val)
             console.log(splits[splits.length - 1])
-            const response = await fetch(`${PRIVATE_BASE_URL}/${splits[splits.length - 1]}/${val}`, {
-                method: 'POST',
+            const response = await fetch(`${PRIVATE_BASE_URL}/api/v1/links/${splits[splits.length - 1]}/status`, {
+                method: 'PUT',
                 headers: {
                     'Content-Type': 'application/json',
                     Authorization: `Bearer ${event.cookies.get("sessionId")}`, // Adjust according to your auth logic
                 },
+                body: JSON.stringify({ enabled: val === 'true' }),
             });
 
             if (response.ok) {
@@ -100,5 +106,31 @@ export const actions: Actions = {
             return fail(500, { error: 'Error updating link: ' + error.message });
         }
     },
-};
+    deleteLink: async (event) => {
+        const formData = await event.request.formData();
+        const shortCode = formData.get('short_code') as string;
+        if (!shortCode) {
+            return fail(400, { error: 'Missing required fields' });
+        }
+
+        try {
+            const response = await fetch(`${PRIVATE_BASE_URL}/api/v1/links/${encodeURIComponent(shortCode)}`, {
+                method: 'DELETE',
+                headers: {
+                    Authorization: `Bearer ${event.cookies.get("sessionId")}`,
+                },
+            });
 
+            if (response.ok) {
+                return {
+                    success: true,
+                    message: 'Link deleted',
+                };
+            } else {
+                return fail(response.status, { error: 'Failed to delete link' });
+            }
+        } catch (error: any) {
+            return fail(500, { error: 'Error deleting link: ' + error.message });
+        }
+    },
+};
@@ -1,29 +1,81 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"log"
+	"log/slog"
 	"os"
-	"strconv"
+	"os/signal"
+	"syscall"
 
 	"github.com/gofiber/fiber/v2/middleware/cors"
-	"github.com/gofiber/fiber/v2/middleware/logger"
 	_ "github.com/joho/godotenv/autoload"
+	"github.com/koderkt/teenyurl/internal/config"
+	"github.com/koderkt/teenyurl/internal/logging"
 	"github.com/koderkt/teenyurl/internal/server"
+	"github.com/koderkt/teenyurl/internal/tracing"
 )
 
 func main() {
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	flag.Parse()
 
-	server := server.New()
-	server.Use(logger.New())
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	logger := logging.New(cfg.Log, os.Stdout)
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing, os.Stdout)
+	if err != nil {
+		logger.Error("setting up tracing", "error", err)
+		os.Exit(1)
+	}
+
+	server := server.New(cfg, logger)
 	server.Use(cors.New(cors.Config{
-		AllowOrigins: "*", // Allow all origins, or specify your domain
-		AllowMethods: "GET,POST,HEAD,PUT,DELETE,PATCH,OPTIONS",
-		AllowHeaders: "Content-Type,Authorization,Accept",
+		AllowOrigins:  cfg.HTTP.AllowOrigins,
+		AllowMethods:  "GET,POST,HEAD,PUT,DELETE,PATCH,OPTIONS",
+		AllowHeaders:  "Content-Type,Authorization,Accept,X-Request-ID,X-API-Key",
+		ExposeHeaders: "Authorization,X-Request-ID,X-Trace-ID",
 	}))
 	server.RegisterFiberRoutes()
-	port, _ := strconv.Atoi(os.Getenv("PORT"))
-	err := server.Listen(fmt.Sprintf(":%d", port))
-	if err != nil {
-		panic(fmt.Sprintf("cannot start server: %s", err))
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	logger.Info("listening", "port", cfg.HTTP.Port)
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- server.Listen(fmt.Sprintf(":%d", cfg.HTTP.Port))
+	}()
+
+	select {
+	case err := <-listenErr:
+		if err != nil {
+			logger.Error("cannot start server", "error", err)
+			os.Exit(1)
+		}
+		return
+	case <-ctx.Done():
+	}
+	stop()
+
+	logger.Info("shutting down", "timeout", cfg.HTTP.ShutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	defer cancel()
+
+	shutdownErr := server.GracefulShutdown(shutdownCtx)
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("flushing traces", "error", err)
+	}
+	if shutdownErr != nil {
+		logger.Error("shutdown", "error", shutdownErr)
+		os.Exit(1)
 	}
+	logger.Info("shutdown complete")
 }
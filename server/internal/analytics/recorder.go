@@ -0,0 +1,97 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/koderkt/teenyurl/internal/database"
+	"github.com/koderkt/teenyurl/internal/logging"
+	"github.com/koderkt/teenyurl/internal/types"
+)
+
+// ErrRecorderClosed is returned by Record once Close has been called.
+var ErrRecorderClosed = errors.New("analytics recorder is closed")
+
+// ErrQueueFull is returned by Record when the buffer is full and the click
+// had to be dropped.
+var ErrQueueFull = errors.New("analytics queue is full")
+
+// Recorder writes clicks to the database in the background so redirects do
+// not wait on Postgres. Pending clicks are flushed by Close.
+type Recorder struct {
+	db     database.Service
+	queue  chan click
+	logger *slog.Logger
+
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+// click is a queued click with the logger of the request that made it.
+type click struct {
+	types.Clicks
+	logger *slog.Logger
+}
+
+func NewRecorder(db database.Service, bufferSize int, logger *slog.Logger) *Recorder {
+	r := &Recorder{
+		db:     db,
+		queue:  make(chan click, bufferSize),
+		logger: logger,
+		done:   make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// Record queues a click without blocking. Failures to store it are logged
+// with the logger of ctx.
+func (r *Recorder) Record(ctx context.Context, clicks types.Clicks) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.closed {
+		return ErrRecorderClosed
+	}
+	if clicks.Timestamp.IsZero() {
+		clicks.Timestamp = time.Now()
+	}
+	select {
+	case r.queue <- click{Clicks: clicks, logger: logging.FromContextOr(ctx, r.logger)}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (r *Recorder) run() {
+	defer close(r.done)
+	for click := range r.queue {
+		ctx := logging.WithContext(context.Background(), click.logger)
+		if err := r.db.InsertAnalytics(ctx, &click.Clicks); err != nil {
+			click.logger.Error("recording click", "short_code", click.ShortCode, "error", err)
+		}
+	}
+}
+
+// Close stops accepting clicks and waits until the queue has been written
+// out or ctx is done, whichever comes first.
+func (r *Recorder) Close(ctx context.Context) error {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.queue)
+	}
+	r.mu.Unlock()
+
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/koderkt/teenyurl/internal/config"
+)
+
+// Purposes an account token can be issued for. A token only verifies for
+// the purpose it was signed with.
+const (
+	PurposeVerifyEmail   = "verify_email"
+	PurposeResetPassword = "reset_password"
+)
+
+var ErrInvalidAccountToken = errors.New("invalid or expired token")
+
+// AccountTokens signs the single-purpose links emailed to users. A token
+// carries the user ID, its expiry and a stamp derived from the state it
+// acts on, so it stops working once that state changes: a reset token dies
+// with the password it replaces, a verification token with the email it
+// was sent to.
+type AccountTokens struct {
+	secret []byte
+	ttl    map[string]time.Duration
+	now    func() time.Time
+}
+
+// NewAccountTokens returns the signer for cfg. ok is false when no secret
+// is configured and a random one was generated.
+func NewAccountTokens(cfg config.Account) (tokens *AccountTokens, ok bool, err error) {
+	secret := []byte(cfg.TokenSecret)
+	ok = len(secret) > 0
+	if !ok {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, false, err
+		}
+	}
+	return &AccountTokens{
+		secret: secret,
+		ttl: map[string]time.Duration{
+			PurposeVerifyEmail:   cfg.VerificationTTL,
+			PurposeResetPassword: cfg.PasswordResetTTL,
+		},
+		now: time.Now,
+	}, ok, nil
+}
+
+// TTL is how long tokens for purpose stay valid.
+func (a *AccountTokens) TTL(purpose string) time.Duration {
+	return a.ttl[purpose]
+}
+
+// Sign issues a token for purpose.
+func (a *AccountTokens) Sign(purpose string, userId int, stamp string) string {
+	expires := a.now().Add(a.ttl[purpose]).Unix()
+	payload := strconv.Itoa(userId) + "." + strconv.FormatInt(expires, 10)
+	return payload + "." + a.mac(purpose, payload, stamp)
+}
+
+// Verify checks that raw was signed for purpose and stamp and has not
+// expired. The caller derives stamp from the current state of the user
+// named by UserId.
+func (a *AccountTokens) Verify(purpose, raw string, stamp string) error {
+	payload, sig, ok := cutLast(raw)
+	if !ok {
+		return ErrInvalidAccountToken
+	}
+	if !hmac.Equal([]byte(sig), []byte(a.mac(purpose, payload, stamp))) {
+		return ErrInvalidAccountToken
+	}
+	_, expires, _ := strings.Cut(payload, ".")
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || a.now().Unix() > exp {
+		return ErrInvalidAccountToken
+	}
+	return nil
+}
+
+// UserId reads the user a token claims to be for, without checking it.
+// Callers must Verify the token with that user's stamp before trusting it.
+func UserId(raw string) (int, error) {
+	id, _, ok := strings.Cut(raw, ".")
+	if !ok {
+		return 0, ErrInvalidAccountToken
+	}
+	userId, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, ErrInvalidAccountToken
+	}
+	return userId, nil
+}
+
+// PasswordStamp is the stamp for password reset tokens.
+func PasswordStamp(encryptedPassword string) string {
+	sum := sha256.Sum256([]byte(encryptedPassword))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (a *AccountTokens) mac(purpose, payload, stamp string) string {
+	h := hmac.New(sha256.New, a.secret)
+	h.Write([]byte(purpose + "\x00" + payload + "\x00" + stamp))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+func cutLast(raw string) (before, after string, ok bool) {
+	i := strings.LastIndexByte(raw, '.')
+	if i < 0 {
+		return "", "", false
+	}
+	return raw[:i], raw[i+1:], true
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Scopes an API key can be granted. Signed-in users implicitly hold all of
+// them.
+const (
+	ScopeRead          = "read"
+	ScopeLinksWrite    = "links:write"
+	ScopeAnalyticsRead = "analytics:read"
+)
+
+// APIKeyPrefix marks a credential as an API key rather than a session ID
+// or access token.
+const APIKeyPrefix = "tu_"
+
+// Scopes lists every scope in the order it is documented.
+var Scopes = []string{ScopeRead, ScopeLinksWrite, ScopeAnalyticsRead}
+
+// GenerateAPIKey returns a new key, the short prefix shown when listing
+// keys, and the hash to store. The key itself is never stored.
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	key = APIKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, key[:len(APIKeyPrefix)+6], HashAPIKey(key), nil
+}
+
+// HashAPIKey is the lookup hash for a key. Keys carry 256 bits of entropy,
+// so a fast unsalted hash is enough.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsAPIKey reports whether a credential looks like an API key.
+func IsAPIKey(credential string) bool {
+	return strings.HasPrefix(credential, APIKeyPrefix)
+}
+
+// ValidateScopes rejects unknown scopes.
+func ValidateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// HasScope reports whether granted includes any of want.
+func HasScope(granted []string, want ...string) bool {
+	for _, scope := range want {
+		if slices.Contains(granted, scope) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/koderkt/teenyurl/internal/config"
+	"github.com/koderkt/teenyurl/internal/types"
+)
+
+// ErrInvalidToken is returned for any access token that cannot be trusted.
+var ErrInvalidToken = errors.New("invalid token")
+
+// AccessClaims are the claims carried by an access token.
+type AccessClaims struct {
+	UserName string `json:"user_name"`
+	Email    string `json:"email"`
+	// Family is the refresh token family the token was issued with.
+	Family string `json:"sid,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Tokens signs and verifies HS256 access tokens. Each token names its
+// signing key in the kid header so keys can be rotated without logging
+// everybody out.
+type Tokens struct {
+	issuer    string
+	ttl       time.Duration
+	keys      map[string][]byte
+	activeKey string
+	now       func() time.Time
+}
+
+func NewTokens(cfg config.JWT) (*Tokens, error) {
+	keys, err := cfg.Keys()
+	if err != nil {
+		return nil, err
+	}
+	if keys[cfg.ActiveKey] == nil {
+		return nil, fmt.Errorf("active key %q is not one of the signing keys", cfg.ActiveKey)
+	}
+	return &Tokens{
+		issuer:    cfg.Issuer,
+		ttl:       cfg.AccessTokenTTL,
+		keys:      keys,
+		activeKey: cfg.ActiveKey,
+		now:       time.Now,
+	}, nil
+}
+
+// TTL is how long issued access tokens stay valid.
+func (t *Tokens) TTL() time.Duration {
+	return t.ttl
+}
+
+// Issue returns a signed access token for user, issued along with the
+// refresh token family.
+func (t *Tokens) Issue(user types.UserSession, family string) (string, error) {
+	now := t.now()
+	claims := AccessClaims{
+		UserName: user.UserName,
+		Email:    user.Email,
+		Family:   family,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Issuer:    t.issuer,
+			Subject:   strconv.Itoa(user.Id),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(t.ttl)),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = t.activeKey
+	return token.SignedString(t.keys[t.activeKey])
+}
+
+// Verify checks the signature, issuer and expiry of raw and returns the
+// user it was issued to and its refresh token family.
+func (t *Tokens) Verify(raw string) (*types.UserSession, string, error) {
+	claims := &AccessClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := t.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+		return key, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(t.issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(t.now),
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	id, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: bad subject", ErrInvalidToken)
+	}
+	return &types.UserSession{
+		Id:       id,
+		UserName: claims.UserName,
+		Email:    claims.Email,
+	}, claims.Family, nil
+}
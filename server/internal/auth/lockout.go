@@ -0,0 +1,104 @@
+// Package auth holds the building blocks of sign-in that do not depend on
+// HTTP: lockout bookkeeping and credential helpers.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/koderkt/teenyurl/internal/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// Lockout tracks failed sign-ins per email in Redis. Emails are hashed
+// before use as keys, and unknown emails are tracked exactly like known ones
+// so the lockout never reveals whether an account exists.
+type Lockout struct {
+	client *redis.Client
+	cfg    config.Lockout
+}
+
+func NewLockout(client *redis.Client, cfg config.Lockout) *Lockout {
+	return &Lockout{client: client, cfg: cfg}
+}
+
+// Locked returns how much longer email is locked out, or zero.
+func (l *Lockout) Locked(ctx context.Context, email string) (time.Duration, error) {
+	ttl, err := l.client.PTTL(ctx, l.lockKey(email)).Result()
+	if err != nil {
+		return 0, err
+	}
+	// -2 means no key, -1 a key without expiry, which we never set
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// Fail records a failed attempt and returns the lock now in force, if any.
+func (l *Lockout) Fail(ctx context.Context, email string) (time.Duration, error) {
+	failuresKey := l.failuresKey(email)
+
+	pipe := l.client.TxPipeline()
+	incr := pipe.Incr(ctx, failuresKey)
+	pipe.PExpire(ctx, failuresKey, l.cfg.Window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	delay := l.Delay(int(incr.Val()))
+	if delay == 0 {
+		return 0, nil
+	}
+	if err := l.client.Set(ctx, l.lockKey(email), 1, delay).Err(); err != nil {
+		return 0, err
+	}
+	return delay, nil
+}
+
+// Reset clears the failures after a successful sign-in.
+func (l *Lockout) Reset(ctx context.Context, email string) error {
+	err := l.client.Del(ctx, l.failuresKey(email), l.lockKey(email)).Err()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	return err
+}
+
+// Delay is the lock applied after the given number of consecutive failures.
+func (l *Lockout) Delay(failures int) time.Duration {
+	if failures < l.cfg.Threshold {
+		return 0
+	}
+	delay := l.cfg.BaseDelay
+	for i := l.cfg.Threshold; i < failures; i++ {
+		delay *= 2
+		if delay >= l.cfg.MaxDelay {
+			return l.cfg.MaxDelay
+		}
+	}
+	return min(delay, l.cfg.MaxDelay)
+}
+
+func (l *Lockout) failuresKey(email string) string {
+	return "login:failures:" + hashEmail(email)
+}
+
+func (l *Lockout) lockKey(email string) string {
+	return "login:lock:" + hashEmail(email)
+}
+
+// NormalizeEmail lower-cases and trims email so "Bob@x.io " and "bob@x.io"
+// share one lockout.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func hashEmail(email string) string {
+	sum := sha256.Sum256([]byte(NormalizeEmail(email)))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// CompareDummyPassword spends the same time as checking a real bcrypt hash.
+// Call it when the account does not exist so response times do not reveal
+// which emails are registered.
+func CompareDummyPassword(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("teenyurl-dummy-password"), bcrypt.DefaultCost)
+	})
+	_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
@@ -0,0 +1,281 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/koderkt/teenyurl/internal/types"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// ErrInvalidRefreshToken is returned for unknown, expired or revoked
+	// refresh tokens.
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned when a refresh token that was already
+	// rotated is presented again. The whole family has been revoked by then.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// markUsed bumps the use counter of a token that still exists and returns
+// it, or 0 if the token has been revoked in the meantime.
+var markUsed = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+return redis.call('HINCRBY', KEYS[1], 'used', 1)
+`)
+
+// RefreshTokens stores opaque refresh tokens in Redis, hashed. Every sign-in
+// starts a family; each refresh retires the presented token and issues the
+// next one in the same family. Retired tokens are kept until they expire so
+// that presenting one again, which means it was stolen, revokes the family.
+//
+// Each family also keeps the session it stands for under
+// "refresh_session:<family>", and a per-user sorted set of family IDs
+// scored by creation time lists them, the same way Sessions does for
+// opaque sessions.
+type RefreshTokens struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func NewRefreshTokens(client *redis.Client, ttl time.Duration) *RefreshTokens {
+	return &RefreshTokens{client: client, ttl: ttl}
+}
+
+// TTL is how long an unused refresh token stays valid.
+func (r *RefreshTokens) TTL() time.Duration {
+	return r.ttl
+}
+
+// Issue starts a new family for session and returns its ID and first
+// token.
+func (r *RefreshTokens) Issue(ctx context.Context, session *types.Session) (string, string, error) {
+	family := uuid.NewString()
+	raw, err := r.issue(ctx, session, family)
+	if err != nil {
+		return "", "", err
+	}
+	return family, raw, nil
+}
+
+// Rotate exchanges raw for the next token in its family and records the
+// family as last used from ip. The ID of the returned session is the
+// family.
+func (r *RefreshTokens) Rotate(ctx context.Context, raw, ip string) (*StoredSession, string, error) {
+	key := tokenKey(raw)
+	record, err := r.client.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, "", err
+	}
+	if len(record) == 0 {
+		return nil, "", ErrInvalidRefreshToken
+	}
+	family := record["family"]
+
+	// of two concurrent refreshes only one sees 1
+	used, err := markUsed.Run(ctx, r.client, []string{key}).Int64()
+	if err != nil {
+		return nil, "", err
+	}
+	if used == 0 {
+		return nil, "", ErrInvalidRefreshToken
+	}
+	if used > 1 {
+		if err := r.RevokeFamily(ctx, family); err != nil {
+			return nil, "", err
+		}
+		return nil, "", ErrRefreshTokenReused
+	}
+
+	id, err := strconv.Atoi(record["user_id"])
+	if err != nil {
+		return nil, "", ErrInvalidRefreshToken
+	}
+	user := types.UserSession{
+		Id:       id,
+		UserName: record["user_name"],
+		Email:    record["email"],
+	}
+	now := time.Now()
+	session, err := r.session(ctx, family)
+	if err != nil {
+		return nil, "", err
+	}
+	if session == nil {
+		// families started before sessions were kept with them
+		session = &types.Session{CreatedAt: now}
+	}
+	session.UserSession = user
+	session.LastSeenAt = now
+	session.IP = ip
+	next, err := r.issue(ctx, session, family)
+	if err != nil {
+		return nil, "", err
+	}
+	return &StoredSession{ID: family, Session: session}, next, nil
+}
+
+// Revoke revokes the family raw belongs to. Unknown tokens are ignored.
+func (r *RefreshTokens) Revoke(ctx context.Context, raw string) error {
+	family, err := r.client.HGet(ctx, tokenKey(raw), "family").Result()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return r.RevokeFamily(ctx, family)
+}
+
+// RevokeFamily deletes every token issued in family.
+func (r *RefreshTokens) RevokeFamily(ctx context.Context, family string) error {
+	session, err := r.session(ctx, family)
+	if err != nil {
+		return err
+	}
+	key := familyKey(family)
+	members, err := r.client.SMembers(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	pipe := r.client.TxPipeline()
+	pipe.Del(ctx, append(members, key, refreshSessionKey(family))...)
+	if session != nil {
+		pipe.ZRem(ctx, familyIndexKey(session.Id), family)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// List returns the sessions of the user's live families, newest first.
+func (r *RefreshTokens) List(ctx context.Context, userId int) ([]StoredSession, error) {
+	index := familyIndexKey(userId)
+	families, err := r.client.ZRevRange(ctx, index, 0, -1).Result()
+	if err != nil || len(families) == 0 {
+		return nil, err
+	}
+	keys := make([]string, len(families))
+	for i, family := range families {
+		keys[i] = refreshSessionKey(family)
+	}
+	values, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []StoredSession
+	var expired []any
+	for i, v := range values {
+		data, ok := v.(string)
+		if !ok {
+			expired = append(expired, families[i])
+			continue
+		}
+		session := new(types.Session)
+		if err := json.Unmarshal([]byte(data), session); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, StoredSession{ID: families[i], Session: session})
+	}
+	if len(expired) > 0 {
+		r.client.ZRem(ctx, index, expired...)
+	}
+	return sessions, nil
+}
+
+// RevokeAll revokes all of the user's families except keep, which may be
+// empty, and returns how many were revoked.
+func (r *RefreshTokens) RevokeAll(ctx context.Context, userId int, keep string) (int, error) {
+	sessions, err := r.List(ctx, userId)
+	if err != nil {
+		return 0, err
+	}
+	revoked := 0
+	for _, session := range sessions {
+		if session.ID == keep {
+			continue
+		}
+		if err := r.RevokeFamily(ctx, session.ID); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
+}
+
+// session returns the session kept with family, or nil if there is none.
+func (r *RefreshTokens) session(ctx context.Context, family string) (*types.Session, error) {
+	data, err := r.client.Get(ctx, refreshSessionKey(family)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	session := new(types.Session)
+	if err := json.Unmarshal(data, session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (r *RefreshTokens) issue(ctx context.Context, session *types.Session, family string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	raw := base64.RawURLEncoding.EncodeToString(buf)
+	key := tokenKey(raw)
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	index := familyIndexKey(session.Id)
+	pipe := r.client.TxPipeline()
+	pipe.HSet(ctx, key, map[string]interface{}{
+		"user_id":   session.Id,
+		"user_name": session.UserName,
+		"email":     session.Email,
+		"family":    family,
+		"used":      0,
+	})
+	pipe.PExpire(ctx, key, r.ttl)
+	pipe.SAdd(ctx, familyKey(family), key)
+	pipe.PExpire(ctx, familyKey(family), r.ttl)
+	pipe.Set(ctx, refreshSessionKey(family), data, r.ttl)
+	pipe.ZAdd(ctx, index, redis.Z{Score: float64(session.CreatedAt.Unix()), Member: family})
+	// the family refreshed last is the last to expire
+	pipe.PExpire(ctx, index, r.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+func tokenKey(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return "refresh:" + hex.EncodeToString(sum[:])
+}
+
+func familyKey(family string) string {
+	return "refresh_family:" + family
+}
+
+func refreshSessionKey(family string) string {
+	return "refresh_session:" + family
+}
+
+func familyIndexKey(userId int) string {
+	return "user_refresh_families:" + strconv.Itoa(userId)
+}
@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/koderkt/teenyurl/internal/types"
+	"github.com/redis/go-redis/v9"
+)
+
+// SessionTouchInterval limits how often last seen is written back, so an
+// active session doesn't cost a Redis write on every request.
+const SessionTouchInterval = time.Minute
+
+// Sessions stores opaque sessions in Redis under "session:<id>", with a
+// per-user sorted set of session IDs scored by creation time. Index entries
+// can outlive their session, which expires on its own; they are dropped
+// whenever the index is read.
+type Sessions struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// StoredSession is a session together with its ID.
+type StoredSession struct {
+	ID string
+	*types.Session
+}
+
+func NewSessions(client *redis.Client, ttl time.Duration) *Sessions {
+	return &Sessions{client: client, ttl: ttl}
+}
+
+func sessionKey(id string) string {
+	return "session:" + id
+}
+
+func sessionIndexKey(userId int) string {
+	return "user_sessions:" + strconv.Itoa(userId)
+}
+
+// SessionHandle is the ID a session is shown and revoked by. The session
+// ID itself is a credential.
+func SessionHandle(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Create stores session and returns its new ID.
+func (s *Sessions) Create(ctx context.Context, session *types.Session) (string, error) {
+	id := uuid.NewString()
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+	index := sessionIndexKey(session.Id)
+	pipe := s.client.TxPipeline()
+	pipe.Set(ctx, sessionKey(id), data, s.ttl)
+	pipe.ZAdd(ctx, index, redis.Z{Score: float64(session.CreatedAt.Unix()), Member: id})
+	// the newest session is the last to expire
+	pipe.Expire(ctx, index, s.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Get returns the session, or redis.Nil if it doesn't exist.
+func (s *Sessions) Get(ctx context.Context, id string) (*types.Session, error) {
+	data, err := s.client.Get(ctx, sessionKey(id)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	session := new(types.Session)
+	if err := json.Unmarshal(data, session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Touch records that the session was used at now from ip. It is a no-op
+// if that was already recorded within SessionTouchInterval.
+func (s *Sessions) Touch(ctx context.Context, id string, session *types.Session, ip string, now time.Time) error {
+	if now.Sub(session.LastSeenAt) < SessionTouchInterval {
+		return nil
+	}
+	session.LastSeenAt = now
+	session.IP = ip
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	// XX so a session revoked meanwhile isn't brought back
+	err = s.client.SetArgs(ctx, sessionKey(id), data, redis.SetArgs{KeepTTL: true, Mode: "XX"}).Err()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	return err
+}
+
+// Delete ends one session.
+func (s *Sessions) Delete(ctx context.Context, userId int, id string) error {
+	pipe := s.client.TxPipeline()
+	pipe.Del(ctx, sessionKey(id))
+	pipe.ZRem(ctx, sessionIndexKey(userId), id)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// List returns the user's live sessions, newest first.
+func (s *Sessions) List(ctx context.Context, userId int) ([]StoredSession, error) {
+	index := sessionIndexKey(userId)
+	ids, err := s.client.ZRevRange(ctx, index, 0, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = sessionKey(id)
+	}
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []StoredSession
+	var expired []any
+	for i, v := range values {
+		data, ok := v.(string)
+		if !ok {
+			expired = append(expired, ids[i])
+			continue
+		}
+		session := new(types.Session)
+		if err := json.Unmarshal([]byte(data), session); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, StoredSession{ID: ids[i], Session: session})
+	}
+	if len(expired) > 0 {
+		s.client.ZRem(ctx, index, expired...)
+	}
+	return sessions, nil
+}
+
+// RevokeAll ends all of the user's sessions except keep, which may be
+// empty, and returns how many were ended.
+func (s *Sessions) RevokeAll(ctx context.Context, userId int, keep string) (int, error) {
+	sessions, err := s.List(ctx, userId)
+	if err != nil {
+		return 0, err
+	}
+	revoked := 0
+	for _, session := range sessions {
+		if session.ID == keep {
+			continue
+		}
+		if err := s.Delete(ctx, userId, session.ID); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
+}
@@ -0,0 +1,588 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/go-playground/validator/v10"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the full application configuration. It is built from defaults,
+// an optional YAML or TOML file and finally environment variables, in that
+// order of precedence.
+type Config struct {
+	HTTP      HTTP      `yaml:"http" toml:"http"`
+	Postgres  Postgres  `yaml:"postgres" toml:"postgres"`
+	Redis     Redis     `yaml:"redis" toml:"redis"`
+	Session   Session   `yaml:"session" toml:"session"`
+	ShortCode ShortCode `yaml:"short_code" toml:"short_code"`
+	Analytics Analytics `yaml:"analytics" toml:"analytics"`
+	Health    Health    `yaml:"health" toml:"health"`
+	Metrics   Metrics   `yaml:"metrics" toml:"metrics"`
+	Log       Log       `yaml:"log" toml:"log"`
+	Tracing   Tracing   `yaml:"tracing" toml:"tracing"`
+	RateLimit RateLimit `yaml:"rate_limit" toml:"rate_limit"`
+	Lockout   Lockout   `yaml:"lockout" toml:"lockout"`
+	Account   Account   `yaml:"account" toml:"account"`
+	Mail      Mail      `yaml:"mail" toml:"mail"`
+	OIDC      OIDC      `yaml:"oidc" toml:"oidc"`
+	TwoFactor TwoFactor `yaml:"two_factor" toml:"two_factor"`
+	QR        QR        `yaml:"qr" toml:"qr"`
+	Links     Links     `yaml:"links" toml:"links"`
+	Metadata  Metadata  `yaml:"metadata" toml:"metadata"`
+	URLPolicy URLPolicy `yaml:"url_policy" toml:"url_policy"`
+	Scanner   Scanner   `yaml:"scanner" toml:"scanner"`
+}
+
+type HTTP struct {
+	Port         int           `yaml:"port" toml:"port" env:"PORT" validate:"required,min=1,max=65535"`
+	AllowOrigins string        `yaml:"allow_origins" toml:"allow_origins" env:"CORS_ALLOW_ORIGINS" validate:"required"`
+	ReadTimeout  time.Duration `yaml:"read_timeout" toml:"read_timeout" env:"HTTP_READ_TIMEOUT" validate:"min=0"`
+	WriteTimeout time.Duration `yaml:"write_timeout" toml:"write_timeout" env:"HTTP_WRITE_TIMEOUT" validate:"min=0"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout" toml:"idle_timeout" env:"HTTP_IDLE_TIMEOUT" validate:"min=0"`
+	// ShutdownTimeout bounds how long in-flight requests and background
+	// work are given to finish after SIGTERM.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"HTTP_SHUTDOWN_TIMEOUT" validate:"min=0"`
+	// DrainDelay is how long /readyz reports draining before the listener
+	// closes, so load balancers stop sending traffic first. It counts
+	// towards ShutdownTimeout.
+	DrainDelay time.Duration `yaml:"drain_delay" toml:"drain_delay" env:"HTTP_DRAIN_DELAY" validate:"min=0"`
+	// ProxyHeader names the header holding the client IP when running
+	// behind a load balancer, e.g. X-Forwarded-For. It is only honoured for
+	// requests coming from TrustedProxies, which must be set along with it.
+	ProxyHeader    string   `yaml:"proxy_header" toml:"proxy_header" env:"HTTP_PROXY_HEADER"`
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies" env:"HTTP_TRUSTED_PROXIES"`
+}
+
+// Postgres holds the settings used to open the connection pool.
+// When URL is set it is used verbatim and the individual fields are ignored.
+type Postgres struct {
+	URL      string `yaml:"url" toml:"url" env:"DATABASE_URL"`
+	Host     string `yaml:"host" toml:"host" env:"DB_HOST" validate:"required_without=URL"`
+	Port     string `yaml:"port" toml:"port" env:"DB_PORT" validate:"required_without=URL"`
+	Database string `yaml:"database" toml:"database" env:"DB_DATABASE" validate:"required_without=URL"`
+	Username string `yaml:"username" toml:"username" env:"DB_USERNAME" validate:"required_without=URL"`
+	Password string `yaml:"password" toml:"password" env:"DB_PASSWORD"`
+	Schema   string `yaml:"schema" toml:"schema" env:"DB_SCHEMA"`
+
+	SSLMode     string `yaml:"ssl_mode" toml:"ssl_mode" env:"DB_SSLMODE" validate:"oneof=disable allow prefer require verify-ca verify-full"`
+	SSLRootCert string `yaml:"ssl_root_cert" toml:"ssl_root_cert" env:"DB_SSLROOTCERT"`
+	SSLCert     string `yaml:"ssl_cert" toml:"ssl_cert" env:"DB_SSLCERT"`
+	SSLKey      string `yaml:"ssl_key" toml:"ssl_key" env:"DB_SSLKEY"`
+
+	MaxOpenConns    int           `yaml:"max_open_conns" toml:"max_open_conns" env:"DB_MAX_OPEN_CONNS" validate:"min=0"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" toml:"max_idle_conns" env:"DB_MAX_IDLE_CONNS" validate:"min=0"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME" validate:"min=0"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" toml:"conn_max_idle_time" env:"DB_CONN_MAX_IDLE_TIME" validate:"min=0"`
+}
+
+type Redis struct {
+	Addr     string `yaml:"addr" toml:"addr" env:"REDIS_ADDR,ADDR" validate:"required,hostname_port"`
+	Password string `yaml:"password" toml:"password" env:"REDIS_PASSWORD"`
+	DB       int    `yaml:"db" toml:"db" env:"REDIS_DB" validate:"min=0"`
+	PoolSize int    `yaml:"pool_size" toml:"pool_size" env:"REDIS_POOL_SIZE" validate:"min=0"`
+}
+
+type Session struct {
+	// Mode is "session" for opaque session IDs kept in Redis, or "jwt" for
+	// short-lived signed access tokens plus rotating refresh tokens.
+	Mode string        `yaml:"mode" toml:"mode" env:"AUTH_MODE" validate:"oneof=session jwt"`
+	TTL  time.Duration `yaml:"ttl" toml:"ttl" env:"SESSION_TTL" validate:"required,min=1m"`
+	JWT  JWT           `yaml:"jwt" toml:"jwt"`
+}
+
+type JWT struct {
+	Issuer          string        `yaml:"issuer" toml:"issuer" env:"JWT_ISSUER" validate:"required"`
+	AccessTokenTTL  time.Duration `yaml:"access_token_ttl" toml:"access_token_ttl" env:"JWT_ACCESS_TOKEN_TTL" validate:"required,min=1m"`
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl" toml:"refresh_token_ttl" env:"JWT_REFRESH_TOKEN_TTL" validate:"required,gtfield=AccessTokenTTL"`
+	// SigningKeys are "kid:secret" pairs. Tokens are signed with ActiveKey
+	// and verified with whichever key their kid names, so a new key can be
+	// added, made active, and the old one removed once its tokens expire.
+	SigningKeys []string `yaml:"signing_keys" toml:"signing_keys" env:"JWT_SIGNING_KEYS"`
+	ActiveKey   string   `yaml:"active_key" toml:"active_key" env:"JWT_ACTIVE_KEY"`
+}
+
+// Keys parses SigningKeys into a kid to secret map.
+func (j JWT) Keys() (map[string][]byte, error) {
+	keys := make(map[string][]byte, len(j.SigningKeys))
+	for _, pair := range j.SigningKeys {
+		kid, secret, ok := strings.Cut(pair, ":")
+		if !ok || kid == "" {
+			return nil, fmt.Errorf("signing key must look like kid:secret")
+		}
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("signing key %q must be at least 32 bytes", kid)
+		}
+		if _, dup := keys[kid]; dup {
+			return nil, fmt.Errorf("signing key %q is listed twice", kid)
+		}
+		keys[kid] = []byte(secret)
+	}
+	return keys, nil
+}
+
+type ShortCode struct {
+	Length int `yaml:"length" toml:"length" env:"SHORT_CODE_LENGTH" validate:"min=4,max=32"`
+	// BaseURL is the public origin short links are served from, e.g.
+	// https://teeny.example. When empty the origin of the request is used.
+	BaseURL string `yaml:"base_url" toml:"base_url" env:"SHORT_URL_BASE" validate:"omitempty,url"`
+}
+
+type Analytics struct {
+	Enabled    bool `yaml:"enabled" toml:"enabled" env:"ANALYTICS_ENABLED"`
+	BufferSize int  `yaml:"buffer_size" toml:"buffer_size" env:"ANALYTICS_BUFFER_SIZE" validate:"min=1"`
+}
+
+// Links controls how links redirect and what happens to deleted ones.
+type Links struct {
+	// RedirectStatus is used by links that don't choose their own. 302 and
+	// 307 keep every visit coming back, so edits apply and clicks are
+	// counted. Browsers keep 301 and 308 for PermanentMaxAge.
+	RedirectStatus  int           `yaml:"redirect_status" toml:"redirect_status" env:"LINKS_REDIRECT_STATUS" validate:"oneof=301 302 307 308"`
+	PermanentMaxAge time.Duration `yaml:"permanent_max_age" toml:"permanent_max_age" env:"LINKS_PERMANENT_MAX_AGE" validate:"min=0"`
+	// RestoreWindow is how long after deletion a link can be restored.
+	RestoreWindow time.Duration `yaml:"restore_window" toml:"restore_window" env:"LINKS_RESTORE_WINDOW" validate:"min=0"`
+	// Quarantine is how long the code of a deleted link is kept out of
+	// use. The link and its clicks are purged once it has passed.
+	Quarantine time.Duration `yaml:"quarantine" toml:"quarantine" env:"LINKS_QUARANTINE" validate:"min=0"`
+	// PurgeInterval is how often the purge job runs. Zero turns it off.
+	PurgeInterval time.Duration `yaml:"purge_interval" toml:"purge_interval" env:"LINKS_PURGE_INTERVAL" validate:"min=0"`
+}
+
+// Metadata configures fetching titles, descriptions and icons from link
+// destinations.
+type Metadata struct {
+	Enabled bool `yaml:"enabled" toml:"enabled" env:"METADATA_ENABLED"`
+	// Timeout bounds each fetch, redirects included.
+	Timeout time.Duration `yaml:"timeout" toml:"timeout" env:"METADATA_TIMEOUT" validate:"required"`
+	// MaxBytes is how much of a page is read looking for metadata.
+	MaxBytes  int64  `yaml:"max_bytes" toml:"max_bytes" env:"METADATA_MAX_BYTES" validate:"min=1024"`
+	Workers   int    `yaml:"workers" toml:"workers" env:"METADATA_WORKERS" validate:"min=1"`
+	QueueSize int    `yaml:"queue_size" toml:"queue_size" env:"METADATA_QUEUE_SIZE" validate:"min=1"`
+	UserAgent string `yaml:"user_agent" toml:"user_agent" env:"METADATA_USER_AGENT"`
+	// AllowPrivateNetworks lets the fetcher reach loopback and private
+	// addresses. Only turn it on for local development and tests.
+	AllowPrivateNetworks bool `yaml:"allow_private_networks" toml:"allow_private_networks" env:"METADATA_ALLOW_PRIVATE_NETWORKS"`
+}
+
+// URLPolicy decides which destinations links may point to.
+type URLPolicy struct {
+	Schemes []string `yaml:"schemes" toml:"schemes" env:"URL_SCHEMES" validate:"required,dive,required"`
+	// BlockPrivateNetworks rejects destinations that are, or resolve to,
+	// loopback, private and other non public addresses.
+	BlockPrivateNetworks bool `yaml:"block_private_networks" toml:"block_private_networks" env:"URL_BLOCK_PRIVATE_NETWORKS"`
+	// AllowDomains, when set, is the only domains links may point to. A
+	// domain also covers its subdomains. DenyDomains wins over it.
+	AllowDomains []string `yaml:"allow_domains" toml:"allow_domains" env:"URL_ALLOW_DOMAINS"`
+	DenyDomains  []string `yaml:"deny_domains" toml:"deny_domains" env:"URL_DENY_DOMAINS"`
+	// AllowMode is "enforce" to refuse destinations outside AllowDomains,
+	// or "warn" to accept them and show visitors a preview page first.
+	AllowMode string `yaml:"allow_mode" toml:"allow_mode" env:"URL_ALLOW_MODE" validate:"oneof=enforce warn"`
+	// ShortDomains lists other hostnames serving our short links, such as
+	// old domains. The host of ShortCode.BaseURL is always included.
+	ShortDomains []string `yaml:"short_domains" toml:"short_domains" env:"URL_SHORT_DOMAINS"`
+	// FollowRedirects requests each destination and follows up to
+	// MaxRedirects redirects, checking every hop, so chains of short links
+	// that lead back to us are caught.
+	FollowRedirects bool          `yaml:"follow_redirects" toml:"follow_redirects" env:"URL_FOLLOW_REDIRECTS"`
+	MaxRedirects    int           `yaml:"max_redirects" toml:"max_redirects" env:"URL_MAX_REDIRECTS" validate:"min=0,max=20"`
+	Timeout         time.Duration `yaml:"timeout" toml:"timeout" env:"URL_CHECK_TIMEOUT" validate:"required"`
+}
+
+// Scanner configures checking destinations against lists of malicious
+// URLs. Scanning is off until a blocklist file is set.
+type Scanner struct {
+	// BlocklistFile holds hash prefixes of blocked URL expressions, in the
+	// format described in the scanner package.
+	BlocklistFile string `yaml:"blocklist_file" toml:"blocklist_file" env:"SCANNER_BLOCKLIST_FILE"`
+	// RecheckInterval is how often existing links are scanned again, so
+	// destinations listed after they were shortened get caught. Zero turns
+	// rechecks off.
+	RecheckInterval time.Duration `yaml:"recheck_interval" toml:"recheck_interval" env:"SCANNER_RECHECK_INTERVAL" validate:"min=0"`
+	BatchSize       int           `yaml:"batch_size" toml:"batch_size" env:"SCANNER_BATCH_SIZE" validate:"min=1"`
+}
+
+type Health struct {
+	// Timeout applies to each dependency check made by /readyz.
+	Timeout       time.Duration `yaml:"timeout" toml:"timeout" env:"HEALTH_TIMEOUT" validate:"required"`
+	ExposeVersion bool          `yaml:"expose_version" toml:"expose_version" env:"HEALTH_EXPOSE_VERSION"`
+}
+
+type Metrics struct {
+	Enabled bool   `yaml:"enabled" toml:"enabled" env:"METRICS_ENABLED"`
+	Path    string `yaml:"path" toml:"path" env:"METRICS_PATH" validate:"required,startswith=/"`
+}
+
+type Log struct {
+	Level  string `yaml:"level" toml:"level" env:"LOG_LEVEL" validate:"oneof=debug info warn error"`
+	Format string `yaml:"format" toml:"format" env:"LOG_FORMAT" validate:"oneof=json text"`
+}
+
+type Tracing struct {
+	// Exporter is none, stdout for local debugging, or otlp.
+	Exporter    string  `yaml:"exporter" toml:"exporter" env:"TRACING_EXPORTER" validate:"oneof=none stdout otlp"`
+	ServiceName string  `yaml:"service_name" toml:"service_name" env:"OTEL_SERVICE_NAME" validate:"required"`
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio" env:"TRACING_SAMPLE_RATIO" validate:"min=0,max=1"`
+	// OTLPEndpoint is a host:port for the OTLP/HTTP exporter. When empty the
+	// exporter falls back to the standard OTEL_EXPORTER_OTLP_* variables.
+	OTLPEndpoint string `yaml:"otlp_endpoint" toml:"otlp_endpoint" env:"TRACING_OTLP_ENDPOINT"`
+	OTLPInsecure bool   `yaml:"otlp_insecure" toml:"otlp_insecure" env:"TRACING_OTLP_INSECURE"`
+}
+
+// RateLimit configures the request limits for each route group. Anonymous
+// requests are keyed by client IP, authenticated ones by user ID.
+type RateLimit struct {
+	Enabled bool `yaml:"enabled" toml:"enabled" env:"RATE_LIMIT_ENABLED"`
+	// Auth covers sign up and sign in.
+	Auth Limit `yaml:"auth" toml:"auth" env:"RATE_LIMIT_AUTH_"`
+	// Write covers creating and changing links.
+	Write Limit `yaml:"write" toml:"write" env:"RATE_LIMIT_WRITE_"`
+	// Read covers listing links and analytics.
+	Read Limit `yaml:"read" toml:"read" env:"RATE_LIMIT_READ_"`
+	// Redirect covers public short link redirects.
+	Redirect Limit `yaml:"redirect" toml:"redirect" env:"RATE_LIMIT_REDIRECT_"`
+}
+
+// Limit allows Requests per sliding Window. A zero Requests disables it.
+type Limit struct {
+	Requests int           `yaml:"requests" toml:"requests" env:"REQUESTS" validate:"min=0"`
+	Window   time.Duration `yaml:"window" toml:"window" env:"WINDOW" validate:"required_with=Requests"`
+}
+
+// Lockout controls how failed sign-ins for an email are throttled. Once
+// Threshold failures happen within Window the email is locked for BaseDelay,
+// doubling with every further failure up to MaxDelay.
+type Lockout struct {
+	Threshold int           `yaml:"threshold" toml:"threshold" env:"LOCKOUT_THRESHOLD" validate:"min=1"`
+	Window    time.Duration `yaml:"window" toml:"window" env:"LOCKOUT_WINDOW" validate:"required"`
+	BaseDelay time.Duration `yaml:"base_delay" toml:"base_delay" env:"LOCKOUT_BASE_DELAY" validate:"required"`
+	MaxDelay  time.Duration `yaml:"max_delay" toml:"max_delay" env:"LOCKOUT_MAX_DELAY" validate:"required,gtefield=BaseDelay"`
+}
+
+// Account configures the signed links sent for email verification and
+// password reset. When TokenSecret is empty a random one is generated at
+// startup, so links stop working on restart and across instances.
+type Account struct {
+	TokenSecret      string        `yaml:"token_secret" toml:"token_secret" env:"ACCOUNT_TOKEN_SECRET" validate:"omitempty,min=32"`
+	VerificationTTL  time.Duration `yaml:"verification_ttl" toml:"verification_ttl" env:"ACCOUNT_VERIFICATION_TTL" validate:"required,min=1m"`
+	PasswordResetTTL time.Duration `yaml:"password_reset_ttl" toml:"password_reset_ttl" env:"ACCOUNT_PASSWORD_RESET_TTL" validate:"required,min=1m"`
+}
+
+// Mail selects how outgoing email is delivered. "log" writes messages to the
+// log, "outbox" writes them as .eml files to OutboxDir and "smtp" sends them.
+type Mail struct {
+	Driver string `yaml:"driver" toml:"driver" env:"MAIL_DRIVER" validate:"oneof=log outbox smtp"`
+	From   string `yaml:"from" toml:"from" env:"MAIL_FROM" validate:"required"`
+	// AppURL is where links in emails point, normally the web client.
+	AppURL    string `yaml:"app_url" toml:"app_url" env:"APP_URL" validate:"required,url"`
+	OutboxDir string `yaml:"outbox_dir" toml:"outbox_dir" env:"MAIL_OUTBOX_DIR" validate:"required_if=Driver outbox"`
+	SMTP      SMTP   `yaml:"smtp" toml:"smtp" env:"SMTP_"`
+}
+
+type SMTP struct {
+	Host     string `yaml:"host" toml:"host" env:"HOST"`
+	Port     int    `yaml:"port" toml:"port" env:"PORT" validate:"min=0,max=65535"`
+	Username string `yaml:"username" toml:"username" env:"USERNAME"`
+	Password string `yaml:"password" toml:"password" env:"PASSWORD"`
+}
+
+// OIDC lists the identity providers users can sign in with. Providers are
+// only read from the config file.
+type OIDC struct {
+	Providers []OIDCProvider `yaml:"providers" toml:"providers" validate:"dive"`
+}
+
+type OIDCProvider struct {
+	// Name identifies the provider in URLs, e.g. /auth/oidc/<name>/login.
+	Name         string `yaml:"name" toml:"name" validate:"required,alphanum"`
+	DisplayName  string `yaml:"display_name" toml:"display_name"`
+	Issuer       string `yaml:"issuer" toml:"issuer" validate:"required,url"`
+	ClientID     string `yaml:"client_id" toml:"client_id" validate:"required"`
+	ClientSecret string `yaml:"client_secret" toml:"client_secret"`
+	// RedirectURL is this server's /auth/oidc/<name>/callback as the
+	// provider will see it.
+	RedirectURL string   `yaml:"redirect_url" toml:"redirect_url" validate:"required,url"`
+	Scopes      []string `yaml:"scopes" toml:"scopes"`
+}
+
+// TwoFactor configures TOTP two-factor authentication. When Required is
+// set, users who haven't enrolled must do so before password sign-in
+// completes, and nobody can turn it off.
+type TwoFactor struct {
+	Required      bool   `yaml:"required" toml:"required" env:"TWO_FACTOR_REQUIRED"`
+	Issuer        string `yaml:"issuer" toml:"issuer" env:"TWO_FACTOR_ISSUER" validate:"required"`
+	RecoveryCodes int    `yaml:"recovery_codes" toml:"recovery_codes" env:"TWO_FACTOR_RECOVERY_CODES" validate:"min=1,max=50"`
+	// ChallengeTTL is how long the second sign-in step stays open after
+	// the password was accepted.
+	ChallengeTTL time.Duration `yaml:"challenge_ttl" toml:"challenge_ttl" env:"TWO_FACTOR_CHALLENGE_TTL" validate:"required,min=30s"`
+}
+
+// QR configures the QR code endpoint. Rendered images are cached in Redis
+// for CacheTTL. LogoFile is the PNG or JPEG drawn when a logo is asked for.
+type QR struct {
+	CacheTTL time.Duration `yaml:"cache_ttl" toml:"cache_ttl" env:"QR_CACHE_TTL" validate:"min=0"`
+	MaxSize  int           `yaml:"max_size" toml:"max_size" env:"QR_MAX_SIZE" validate:"min=64,max=8192"`
+	LogoFile string        `yaml:"logo_file" toml:"logo_file" env:"QR_LOGO_FILE"`
+}
+
+// Default returns the configuration used when nothing else is provided.
+func Default() *Config {
+	return &Config{
+		HTTP: HTTP{
+			Port:            8080,
+			AllowOrigins:    "*",
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    10 * time.Second,
+			IdleTimeout:     60 * time.Second,
+			ShutdownTimeout: 20 * time.Second,
+			DrainDelay:      5 * time.Second,
+		},
+		Postgres: Postgres{
+			Host:            "localhost",
+			Port:            "5432",
+			SSLMode:         "disable",
+			MaxOpenConns:    25,
+			MaxIdleConns:    25,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+		},
+		Redis: Redis{
+			Addr: "localhost:6379",
+		},
+		Session: Session{
+			Mode: "session",
+			TTL:  2 * time.Hour,
+			JWT: JWT{
+				Issuer:          "teenyurl",
+				AccessTokenTTL:  15 * time.Minute,
+				RefreshTokenTTL: 30 * 24 * time.Hour,
+			},
+		},
+		ShortCode: ShortCode{
+			Length: 6,
+		},
+		Analytics: Analytics{
+			Enabled:    true,
+			BufferSize: 1024,
+		},
+		Health: Health{
+			Timeout:       time.Second,
+			ExposeVersion: true,
+		},
+		Metrics: Metrics{
+			Enabled: true,
+			Path:    "/metrics",
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "json",
+		},
+		Tracing: Tracing{
+			Exporter:    "none",
+			ServiceName: "teenyurl",
+			SampleRatio: 1,
+		},
+		RateLimit: RateLimit{
+			Enabled:  true,
+			Auth:     Limit{Requests: 10, Window: time.Minute},
+			Write:    Limit{Requests: 60, Window: time.Minute},
+			Read:     Limit{Requests: 300, Window: time.Minute},
+			Redirect: Limit{Requests: 600, Window: time.Minute},
+		},
+		Lockout: Lockout{
+			Threshold: 5,
+			Window:    time.Hour,
+			BaseDelay: 30 * time.Second,
+			MaxDelay:  time.Hour,
+		},
+		Account: Account{
+			VerificationTTL:  48 * time.Hour,
+			PasswordResetTTL: time.Hour,
+		},
+		QR: QR{
+			CacheTTL: 24 * time.Hour,
+			MaxSize:  2048,
+		},
+		Metadata: Metadata{
+			Enabled:   true,
+			Timeout:   5 * time.Second,
+			MaxBytes:  512 << 10,
+			Workers:   4,
+			QueueSize: 256,
+			UserAgent: "teenyurl-metadata/1.0",
+		},
+		URLPolicy: URLPolicy{
+			Schemes:              []string{"http", "https"},
+			BlockPrivateNetworks: true,
+			AllowMode:            "enforce",
+			MaxRedirects:         5,
+			Timeout:              3 * time.Second,
+		},
+		Scanner: Scanner{
+			RecheckInterval: 24 * time.Hour,
+			BatchSize:       500,
+		},
+		Links: Links{
+			RedirectStatus:  302,
+			PermanentMaxAge: 24 * time.Hour,
+			RestoreWindow:   30 * 24 * time.Hour,
+			Quarantine:      90 * 24 * time.Hour,
+			PurgeInterval:   time.Hour,
+		},
+		TwoFactor: TwoFactor{
+			Issuer:        "teenyurl",
+			RecoveryCodes: 10,
+			ChallengeTTL:  5 * time.Minute,
+		},
+		Mail: Mail{
+			Driver:    "log",
+			From:      "teenyurl <no-reply@localhost>",
+			AppURL:    "http://localhost:5173",
+			OutboxDir: "outbox",
+			SMTP: SMTP{
+				Port: 587,
+			},
+		},
+	}
+}
+
+// Load builds the configuration. If path is not empty the file is decoded on
+// top of the defaults, as YAML or TOML depending on its extension. The
+// environment is applied last and the result is validated.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyEnv(cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: reading %s: %w", path, err)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, c)
+	case ".toml":
+		err = toml.Unmarshal(data, c)
+	default:
+		return fmt.Errorf("config: unsupported file type %q", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("config: parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks the configuration and reports every invalid field.
+func (c *Config) Validate() error {
+	err := validator.New().Struct(c)
+
+	var validationErrors validator.ValidationErrors
+	if err != nil && !errors.As(err, &validationErrors) {
+		return err
+	}
+	messages := c.crossFieldErrors()
+	for _, fe := range validationErrors {
+		field := strings.TrimPrefix(fe.Namespace(), "Config.")
+		if fe.Param() != "" {
+			messages = append(messages, fmt.Sprintf("%s: failed %q (%s), got %v", field, fe.Tag(), fe.Param(), fe.Value()))
+		} else {
+			messages = append(messages, fmt.Sprintf("%s: failed %q, got %v", field, fe.Tag(), fe.Value()))
+		}
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	return fmt.Errorf("config: invalid configuration:\n  %s", strings.Join(messages, "\n  "))
+}
+
+// crossFieldErrors covers rules that depend on more than one field.
+func (c *Config) crossFieldErrors() []string {
+	var messages []string
+	if c.HTTP.ProxyHeader != "" && len(c.HTTP.TrustedProxies) == 0 {
+		// otherwise any client could pick its own IP
+		messages = append(messages, "HTTP.TrustedProxies: required when HTTP.ProxyHeader is set")
+	}
+	if c.HTTP.DrainDelay > 0 && c.HTTP.DrainDelay >= c.HTTP.ShutdownTimeout {
+		messages = append(messages, "HTTP.DrainDelay: must be shorter than HTTP.ShutdownTimeout")
+	}
+	if c.Session.Mode == "jwt" {
+		jwt := c.Session.JWT
+		keys, err := jwt.Keys()
+		switch {
+		case err != nil:
+			messages = append(messages, "Session.JWT.SigningKeys: "+err.Error())
+		case len(keys) == 0:
+			messages = append(messages, "Session.JWT.SigningKeys: required when Session.Mode is jwt")
+		case keys[jwt.ActiveKey] == nil:
+			messages = append(messages, fmt.Sprintf("Session.JWT.ActiveKey: %q is not one of the signing keys", jwt.ActiveKey))
+		}
+	}
+	names := map[string]bool{}
+	for _, p := range c.OIDC.Providers {
+		if names[p.Name] {
+			messages = append(messages, fmt.Sprintf("OIDC.Providers: %q is listed twice", p.Name))
+		}
+		names[p.Name] = true
+	}
+	if c.Mail.Driver == "smtp" && c.Mail.SMTP.Host == "" {
+		messages = append(messages, "Mail.SMTP.Host: required when Mail.Driver is smtp")
+	}
+	if c.Links.Quarantine < c.Links.RestoreWindow {
+		messages = append(messages, "Links.Quarantine: must not be shorter than Links.RestoreWindow")
+	}
+	return messages
+}
+
+// DSN returns the connection string for lib/pq.
+func (p Postgres) DSN() string {
+	if p.URL != "" {
+		return p.URL
+	}
+
+	query := url.Values{}
+	query.Set("sslmode", p.SSLMode)
+	if p.SSLRootCert != "" {
+		query.Set("sslrootcert", p.SSLRootCert)
+	}
+	if p.SSLCert != "" {
+		query.Set("sslcert", p.SSLCert)
+	}
+	if p.SSLKey != "" {
+		query.Set("sslkey", p.SSLKey)
+	}
+	// lib/pq forwards unknown parameters as run-time settings
+	if p.Schema != "" {
+		query.Set("search_path", p.Schema)
+	}
+
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(p.Username, p.Password),
+		Host:     net.JoinHostPort(p.Host, p.Port),
+		Path:     "/" + p.Database,
+		RawQuery: query.Encode(),
+	}
+	return dsn.String()
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// applyEnv walks the struct and overrides every field that has an `env` tag
+// with the matching environment variable. A tag may list several names
+// separated by commas; the first one that is set wins. An `env` tag on a
+// nested struct is used as a prefix for the tags of its fields, so the same
+// struct type can be reused under different names.
+func applyEnv(cfg *Config) error {
+	return applyEnvStruct(reflect.ValueOf(cfg).Elem(), "")
+}
+
+func applyEnvStruct(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		value := v.Field(i)
+
+		if field.Type.Kind() == reflect.Struct && field.Type != durationType {
+			if err := applyEnvStruct(value, prefix+field.Tag.Get("env")); err != nil {
+				return err
+			}
+			continue
+		}
+
+		tag := field.Tag.Get("env")
+		if tag == "" {
+			continue
+		}
+		for _, key := range strings.Split(tag, ",") {
+			key = prefix + key
+			raw, ok := os.LookupEnv(key)
+			if !ok || raw == "" {
+				continue
+			}
+			if err := setField(value, raw); err != nil {
+				return fmt.Errorf("config: %s=%q: %w", key, raw, err)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+func setField(v reflect.Value, raw string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported slice type %s", v.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+	return nil
+}
@@ -5,64 +5,137 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/joho/godotenv/autoload"
+	"github.com/koderkt/teenyurl/internal/config"
+	"github.com/koderkt/teenyurl/internal/logging"
 	"github.com/koderkt/teenyurl/internal/types"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 // Service represents a service that interacts with a database.
 type Service interface {
 	Health() map[string]string
+	Ping(context.Context) error
+	Stats() sql.DBStats
 	Close() error
-	CreateUser(*types.User) error
+	CreateUser(context.Context, *types.User) error
 	Init() error
-	GetUserByEmail(string) (*types.User, error)
-	CreateShortURL(*types.Link) error
-	GetLink(string) (*types.Link, error)
-	GetLinks(int) (*[]types.Link, error)
-	InsertAnalytics(*types.Clicks) error
-	GetAnalystics(string) (*[]types.Clicks, error)
-	GetNumberOfClicks(string) (int, error)
-	EditLink(*types.Link) (error)
-	EnableDisableLink(*types.Link)error
+	GetUserByEmail(context.Context, string) (*types.User, error)
+	CreateShortURL(context.Context, *types.Link) error
+	GetLink(context.Context, string) (*types.Link, error)
+	GetLinks(context.Context, int) (*[]types.Link, error)
+	InsertAnalytics(context.Context, *types.Clicks) error
+	GetAnalystics(context.Context, string) (*[]types.Clicks, error)
+	GetNumberOfClicks(context.Context, string) (int, error)
+	EditLink(context.Context, *types.Link) error
+	EnableDisableLink(context.Context, *types.Link) error
+	DeleteLink(context.Context, *types.Link) error
+	ListLinks(context.Context, *types.LinkQuery) ([]types.LinkStats, error)
+	GetLinkIds(context.Context, int, []string) (map[string]int, error)
+	GetTags(context.Context, int) ([]types.Tag, error)
+	CreateTag(context.Context, *types.Tag) error
+	RenameTag(context.Context, int, int, string) error
+	DeleteTag(context.Context, int, int) error
+	GetLinkTags(context.Context, int) ([]string, error)
+	SetLinkTags(context.Context, int, int, []string) error
+	TagLinks(context.Context, int, []int, []string, []string) error
+	GetTagClicks(context.Context, int, *time.Time, *time.Time) ([]types.TagClicks, error)
+	GetFolders(context.Context, int) ([]types.Folder, error)
+	GetFolder(context.Context, int, int) (*types.Folder, error)
+	CreateFolder(context.Context, *types.Folder) error
+	RenameFolder(context.Context, int, int, string) error
+	DeleteFolder(context.Context, int, int) error
+	SetLinksFolder(context.Context, []int, *int) error
+	UpdateLinkDetails(context.Context, *types.Link) error
+	SaveLinkMetadata(context.Context, int, string, *types.LinkMetadata) error
+	RestoreLink(context.Context, *types.Link, time.Time) error
+	PurgeDeletedLinks(context.Context, time.Time) (int64, error)
+	GetLinksToScan(context.Context, time.Time, int, int) ([]types.Link, error)
+	MarkLinksScanned(context.Context, []int) error
+	FlagLink(context.Context, *types.Link, string) error
+	RecordLoginAttempt(context.Context, *types.LoginAttempt) error
+	GetLoginAttempts(context.Context, int, int) ([]types.LoginAttempt, error)
+	CreateAPIKey(context.Context, *types.APIKey) error
+	GetAPIKeys(context.Context, int) ([]types.APIKey, error)
+	GetAPIKeyByHash(context.Context, string) (*types.APIKeyOwner, error)
+	TouchAPIKey(context.Context, int) error
+	DeleteAPIKey(context.Context, int, int) error
+	GetUserById(context.Context, int) (*types.User, error)
+	MarkEmailVerified(context.Context, int, string) error
+	UpdatePassword(context.Context, int, string) error
+	GetUserByIdentity(context.Context, string, string) (*types.User, error)
+	LinkIdentity(context.Context, *types.UserIdentity) error
+	GetTOTP(context.Context, int) (*types.TOTP, error)
+	SaveTOTPSecret(context.Context, int, string) error
+	EnableTOTP(context.Context, int, int64, []string) error
+	UseTOTPStep(context.Context, int, int64) error
+	UseRecoveryCode(context.Context, int, string) error
+	ReplaceRecoveryCodes(context.Context, int, []string) error
+	DeleteTOTP(context.Context, int) error
+	// WithTx runs fn with a Service whose calls share one transaction. It
+	// is committed if fn returns nil and rolled back otherwise.
+	WithTx(context.Context, func(Service) error) error
+}
+
+// dbtx runs queries, on the pool or in a transaction.
+type dbtx interface {
+	sqlx.ExtContext
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+}
+
+// txn is a transaction begun for a single call.
+type txn interface {
+	dbtx
+	Commit() error
+	Rollback() error
 }
 
 type service struct {
-	db *sqlx.DB
+	pool *sqlx.DB
+	// db is pool, or tx inside WithTx.
+	db       dbtx
+	tx       *sqlx.Tx
+	database string
+	logger   *slog.Logger
 }
 
-var (
-	database = os.Getenv("DB_DATABASE")
-	password = os.Getenv("DB_PASSWORD")
-	username = os.Getenv("DB_USERNAME")
-	// port       = os.Getenv("DB_PORT")
-	// host       = os.Getenv("DB_HOST")
-	// schema     = os.Getenv("DB_SCHEMA")
-	dbInstance *service
-)
+var dbInstance *service
 
-func New() Service {
+// ErrUserNotFound is returned by GetUserByEmail when no account matches.
+var ErrUserNotFound = errors.New("invalid email")
+
+// ErrNameTaken is returned when a tag or folder name is already used by
+// another of the user's tags or folders.
+var ErrNameTaken = errors.New("name is already in use")
+
+func New(cfg config.Postgres, logger *slog.Logger) Service {
 	// Reuse Connection
 	if dbInstance != nil {
 		return dbInstance
 	}
-	// connStr := fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable&search_path=%s", username, password, host, port, database, schema)
-	// db, err := sql.Open("pgx", connStr)
 
-	// sqlx
-	connStr := fmt.Sprintf("user=%s password=%s dbname=%s sslmode=disable", username, password, database)
-	db, err := sqlx.Connect("postgres", connStr)
+	db, err := sqlx.Connect("postgres", cfg.DSN())
 	if err != nil {
-		log.Fatal(err)
+		logger.Error("connecting to database", "host", cfg.Host, "database", cfg.Database, "error", err)
+		os.Exit(1)
 	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
 	dbInstance = &service{
-		db: db,
+		pool:     db,
+		db:       db,
+		database: cfg.Database,
+		logger:   logger,
 	}
 	return dbInstance
 }
@@ -80,11 +153,11 @@ func (s *service) Health() map[string]string {
 	stats := make(map[string]string)
 
 	// Ping the database
-	err := s.db.PingContext(ctx)
+	err := s.pool.PingContext(ctx)
 	if err != nil {
 		stats["status"] = "down"
 		stats["error"] = fmt.Sprintf("db down: %v", err)
-		log.Fatalf(fmt.Sprintf("db down: %v", err)) // Log the error and terminate the program
+		s.logger.Error("database health check failed", "error", err)
 		return stats
 	}
 
@@ -93,7 +166,7 @@ func (s *service) Health() map[string]string {
 	stats["message"] = "It's healthy"
 
 	// Get database stats (like open connections, in use, idle, etc.)
-	dbStats := s.db.Stats()
+	dbStats := s.pool.Stats()
 	stats["open_connections"] = strconv.Itoa(dbStats.OpenConnections)
 	stats["in_use"] = strconv.Itoa(dbStats.InUse)
 	stats["idle"] = strconv.Itoa(dbStats.Idle)
@@ -122,38 +195,59 @@ func (s *service) Health() map[string]string {
 	return stats
 }
 
+// Ping verifies the database is reachable within the deadline of ctx.
+func (s *service) Ping(ctx context.Context) error {
+	err := s.pool.PingContext(ctx)
+	if err != nil {
+		s.log(ctx).Warn("database ping failed", "error", err)
+	}
+	return err
+}
+
+// log returns the logger of the request behind ctx, if any, or the
+// service's own.
+func (s *service) log(ctx context.Context) *slog.Logger {
+	return logging.FromContextOr(ctx, s.logger)
+}
+
+// Stats returns the connection pool statistics.
+func (s *service) Stats() sql.DBStats {
+	return s.pool.Stats()
+}
+
 func (s *service) Close() error {
-	log.Printf("Disconnected from database: %s", database)
-	return s.db.Close()
+	s.logger.Info("disconnected from database", "database", s.database)
+	return s.pool.Close()
 }
 
-func (s *service) CreateUser(user *types.User) error {
+func (s *service) CreateUser(ctx context.Context, user *types.User) error {
 	createUserQuery := `insert into users
 	(user_name, email, encrypted_password, created_at)
-	values ($1, $2, $3, $4)`
+	values ($1, $2, $3, $4)
+	returning id`
 
 	userFromDb := &types.User{}
 
 	getUserQuery := "select * from users where email = $1"
-	err := s.db.Get(userFromDb, getUserQuery, user.Email)
+	err := s.db.GetContext(ctx, userFromDb, getUserQuery, user.Email)
 
 	if err != sql.ErrNoRows {
 		return errors.New("email/username already exists")
 	}
 
 	getUserQuery = "select * from users where user_name = $1"
-	err = s.db.Get(userFromDb, getUserQuery, user.Email)
+	err = s.db.GetContext(ctx, userFromDb, getUserQuery, user.Email)
 
 	if err != sql.ErrNoRows {
 		return errors.New("email/username already exists")
 	}
-	_, err = s.db.Query(
+	err = s.db.QueryRowxContext(ctx,
 		createUserQuery,
 		user.UserName,
 		user.Email,
 		user.EncryptedPassword,
 		user.CreatedAt,
-	)
+	).Scan(&user.ID)
 
 	if err != nil {
 		return err
@@ -161,18 +255,49 @@ func (s *service) CreateUser(user *types.User) error {
 	return nil
 }
 
-func (s *service) GetUserByEmail(email string) (*types.User, error) {
+func (s *service) GetUserByEmail(ctx context.Context, email string) (*types.User, error) {
 	userFromDb := &types.User{}
 	getUserQuery := "select * from users where email = $1"
-	err := s.db.Get(userFromDb, getUserQuery, email)
+	err := s.db.GetContext(ctx, userFromDb, getUserQuery, email)
 
 	if err == sql.ErrNoRows {
-		return nil, errors.New("invalid email")
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	return userFromDb, nil
 }
 
+func (s *service) GetUserById(ctx context.Context, id int) (*types.User, error) {
+	userFromDb := &types.User{}
+	err := s.db.GetContext(ctx, userFromDb, "select * from users where id = $1", id)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return userFromDb, nil
+}
+
+// MarkEmailVerified verifies the user's email, provided it is still the
+// address the verification was sent to.
+func (s *service) MarkEmailVerified(ctx context.Context, id int, email string) error {
+	query := `UPDATE users SET email_verified_at = COALESCE(email_verified_at, now())
+	WHERE id = $1 AND email = $2`
+	_, err := s.db.ExecContext(ctx, query, id, email)
+	return err
+}
+
+func (s *service) UpdatePassword(ctx context.Context, id int, encryptedPassword string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE users SET encrypted_password = $1 WHERE id = $2`, encryptedPassword, id)
+	return err
+}
+
 func (s *service) createTables() error {
 	userTableQuery := `create table if not exists users (
 		id serial primary key,
@@ -181,11 +306,9 @@ func (s *service) createTables() error {
 		encrypted_password varchar(100),
 		created_at timestamp
 	);`
-	_, err := s.db.Exec(userTableQuery)
-	// return err
+	_, err := s.pool.Exec(userTableQuery)
 	if err != nil {
-		log.Fatalf("error while creating users table: %s", err.Error())
-
+		return fmt.Errorf("creating users table: %w", err)
 	}
 
 	linkTableQuery := `CREATE TABLE if not exists urls (
@@ -196,10 +319,9 @@ func (s *service) createTables() error {
     user_id INT NOT NULL,
     is_enabled BOOLEAN NOT NULL DEFAULT TRUE
 	);`
-	_, err = s.db.Exec(linkTableQuery)
-	// return err
+	_, err = s.pool.Exec(linkTableQuery)
 	if err != nil {
-		log.Fatalf("error while creating link table: %s", err.Error())
+		return fmt.Errorf("creating link table: %w", err)
 	}
 
 	query := `CREATE TABLE IF NOT EXISTS clicks (
@@ -209,25 +331,193 @@ func (s *service) createTables() error {
 		device_type VARCHAR(50),
 		location VARCHAR(100)
 		);`
-	_, err = s.db.Exec(query)
-	// return err
+	_, err = s.pool.Exec(query)
+	if err != nil {
+		return fmt.Errorf("creating clicks table: %w", err)
+	}
+
+	query = `CREATE TABLE IF NOT EXISTS login_attempts (
+		id SERIAL PRIMARY KEY,
+		user_id INT,
+		email VARCHAR(100) NOT NULL,
+		ip VARCHAR(64) NOT NULL,
+		user_agent TEXT NOT NULL DEFAULT '',
+		success BOOLEAN NOT NULL,
+		reason VARCHAR(50) NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS login_attempts_user_id_created_at_idx
+		ON login_attempts (user_id, created_at DESC);`
+	_, err = s.pool.Exec(query)
+	if err != nil {
+		return fmt.Errorf("creating login_attempts table: %w", err)
+	}
+
+	// accounts created before verification existed count as verified: the
+	// default fills existing rows, then new sign ups start unverified
+	_, err = s.pool.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
+		ALTER TABLE users ALTER COLUMN email_verified_at DROP DEFAULT;`)
+	if err != nil {
+		return fmt.Errorf("migrating users table: %w", err)
+	}
+
+	_, err = s.pool.Exec(`ALTER TABLE urls ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP`)
+	if err != nil {
+		return fmt.Errorf("migrating link table: %w", err)
+	}
+
+	// domain is the lowercased host of the destination, for filtering
+	query = `ALTER TABLE urls ADD COLUMN IF NOT EXISTS domain TEXT GENERATED ALWAYS AS
+			(COALESCE(lower(substring(original_url from '^[A-Za-z][A-Za-z0-9+.-]*://(?:[^/?#@]*@)?([^/?#:]+)')), '')) STORED;
+		CREATE INDEX IF NOT EXISTS urls_short_url_idx ON urls (short_url);
+		CREATE INDEX IF NOT EXISTS urls_user_id_created_at_idx ON urls (user_id, created_at, id);
+		CREATE INDEX IF NOT EXISTS urls_user_id_domain_idx ON urls (user_id, domain);
+		CREATE INDEX IF NOT EXISTS clicks_short_code_idx ON clicks (short_code);`
+	_, err = s.pool.Exec(query)
+	if err != nil {
+		return fmt.Errorf("indexing link table: %w", err)
+	}
+
+	// Search works without pg_trgm, only slower, so a database user that
+	// may not create extensions is not fatal.
+	query = `CREATE EXTENSION IF NOT EXISTS pg_trgm;
+		CREATE INDEX IF NOT EXISTS urls_original_url_trgm_idx ON urls USING gin (original_url gin_trgm_ops);
+		CREATE INDEX IF NOT EXISTS urls_short_url_trgm_idx ON urls USING gin (short_url gin_trgm_ops);`
+	_, err = s.pool.Exec(query)
+	if err != nil {
+		s.logger.Warn("creating search indexes, link search will scan", "error", err)
+	}
+
+	query = `CREATE TABLE IF NOT EXISTS api_keys (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		name VARCHAR(100) NOT NULL,
+		prefix VARCHAR(16) NOT NULL,
+		key_hash CHAR(64) NOT NULL UNIQUE,
+		scopes TEXT[] NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		last_used_at TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id);`
+	_, err = s.pool.Exec(query)
+	if err != nil {
+		return fmt.Errorf("creating api_keys table: %w", err)
+	}
+
+	query = `CREATE TABLE IF NOT EXISTS user_identities (
+		provider VARCHAR(50) NOT NULL,
+		subject TEXT NOT NULL,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		email VARCHAR(100) NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (provider, subject)
+		);`
+	_, err = s.pool.Exec(query)
+	if err != nil {
+		return fmt.Errorf("creating user_identities table: %w", err)
+	}
+
+	query = `CREATE TABLE IF NOT EXISTS user_totp (
+		user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		secret TEXT NOT NULL,
+		enabled_at TIMESTAMP,
+		last_used_step BIGINT NOT NULL DEFAULT 0,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS recovery_codes (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		code_hash CHAR(64) NOT NULL,
+		used_at TIMESTAMP
+		);
+		CREATE INDEX IF NOT EXISTS recovery_codes_user_id_idx ON recovery_codes (user_id);`
+	_, err = s.pool.Exec(query)
+	if err != nil {
+		return fmt.Errorf("creating two factor tables: %w", err)
+	}
+
+	// short codes may be longer than the original 6 characters
+	_, err = s.pool.Exec(`ALTER TABLE clicks ALTER COLUMN short_code TYPE TEXT;`)
+	if err != nil {
+		return fmt.Errorf("migrating clicks table: %w", err)
+	}
+
+	// tag and folder names are unique per user, ignoring case
+	query = `CREATE TABLE IF NOT EXISTS tags (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		name VARCHAR(50) NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS tags_user_id_name_idx ON tags (user_id, lower(name));
+		CREATE TABLE IF NOT EXISTS link_tags (
+		link_id INT NOT NULL REFERENCES urls(id) ON DELETE CASCADE,
+		tag_id INT NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+		PRIMARY KEY (link_id, tag_id)
+		);
+		CREATE INDEX IF NOT EXISTS link_tags_tag_id_idx ON link_tags (tag_id);
+		CREATE TABLE IF NOT EXISTS folders (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		name VARCHAR(50) NOT NULL,
+		created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS folders_user_id_name_idx ON folders (user_id, lower(name));
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS folder_id INT REFERENCES folders(id) ON DELETE SET NULL;
+		CREATE INDEX IF NOT EXISTS urls_folder_id_idx ON urls (folder_id);`
+	_, err = s.pool.Exec(query)
+	if err != nil {
+		return fmt.Errorf("creating tag and folder tables: %w", err)
+	}
+
+	_, err = s.pool.Exec(`ALTER TABLE urls
+		ADD COLUMN IF NOT EXISTS title TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS notes TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS meta_title TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS meta_description TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS meta_image TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS meta_favicon TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS metadata_fetched_at TIMESTAMP`)
+	if err != nil {
+		return fmt.Errorf("migrating link table: %w", err)
+	}
+
+	_, err = s.pool.Exec(`ALTER TABLE urls
+		ADD COLUMN IF NOT EXISTS flagged_at TIMESTAMP,
+		ADD COLUMN IF NOT EXISTS flag_reason TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS scanned_at TIMESTAMP;
+		CREATE INDEX IF NOT EXISTS urls_scanned_at_idx ON urls (scanned_at NULLS FIRST)
+			WHERE deleted_at IS NULL AND flagged_at IS NULL;`)
 	if err != nil {
-		log.Fatalf("error while creating clicks table: %s", err.Error())
+		return fmt.Errorf("migrating link table: %w", err)
+	}
+
+	_, err = s.pool.Exec(`ALTER TABLE urls
+		ADD COLUMN IF NOT EXISTS preview BOOLEAN NOT NULL DEFAULT false,
+		ADD COLUMN IF NOT EXISTS redirect_status SMALLINT NOT NULL DEFAULT 0`)
+	if err != nil {
+		return fmt.Errorf("migrating link table: %w", err)
 	}
 
 	return nil
 }
 
-func (s *service) CreateShortURL(link *types.Link) error {
+func (s *service) CreateShortURL(ctx context.Context, link *types.Link) error {
 	createLinkQuery := `insert into urls
-	(original_url, short_url, user_id)
-	values ($1, $2, $3)`
+	(original_url, short_url, user_id, folder_id, title, notes, preview, redirect_status, scanned_at)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
 
-	_, err := s.db.Query(
+	_, err := s.db.ExecContext(ctx,
 		createLinkQuery,
 		link.OriginalURL,
 		link.ShortURL,
 		link.UserId,
+		link.FolderId,
+		link.Title,
+		link.Notes,
+		link.Preview,
+		link.RedirectStatus,
+		link.ScannedAt,
 	)
 	if err != nil {
 		return err
@@ -236,26 +526,25 @@ func (s *service) CreateShortURL(link *types.Link) error {
 	return nil
 }
 
-func (s *service) GetLink(shortURL string) (*types.Link, error) {
+func (s *service) GetLink(ctx context.Context, shortURL string) (*types.Link, error) {
 	record := &types.Link{}
 	getLinkQuery := "select * from urls where short_url = $1"
-	err := s.db.Get(record, getLinkQuery, shortURL)
-
-	if err == sql.ErrNoRows {
+	err := s.db.GetContext(ctx, record, getLinkQuery, shortURL)
+	if err != nil {
 		return nil, err
 	}
 
 	return record, nil
 }
 
-func (s *service) InsertAnalytics(analytics *types.Clicks) error {
-	fmt.Println(*analytics)
-	query := `INSERT INTO clicks (short_code, device_type, location)
-	values ($1, $2, $3)`
+func (s *service) InsertAnalytics(ctx context.Context, analytics *types.Clicks) error {
+	query := `INSERT INTO clicks (short_code, time_stamp, device_type, location)
+	values ($1, $2, $3, $4)`
 
-	_, err := s.db.Query(
+	_, err := s.db.ExecContext(ctx,
 		query,
 		analytics.ShortCode,
+		analytics.Timestamp,
 		analytics.DeviceType,
 		analytics.Location,
 	)
@@ -265,10 +554,10 @@ func (s *service) InsertAnalytics(analytics *types.Clicks) error {
 	return nil
 }
 
-func (s *service) GetAnalystics(shortCode string) (*[]types.Clicks, error) {
+func (s *service) GetAnalystics(ctx context.Context, shortCode string) (*[]types.Clicks, error) {
 	record := &[]types.Clicks{}
 	getClicksQuery := "select * from clicks where short_code = $1"
-	err := s.db.Select(record, getClicksQuery, shortCode)
+	err := s.db.SelectContext(ctx, record, getClicksQuery, shortCode)
 
 	if err != nil {
 		return nil, err
@@ -277,10 +566,10 @@ func (s *service) GetAnalystics(shortCode string) (*[]types.Clicks, error) {
 	return record, nil
 }
 
-func (s *service) GetLinks(userId int) (*[]types.Link, error) {
+func (s *service) GetLinks(ctx context.Context, userId int) (*[]types.Link, error) {
 	var links []types.Link
-	getClicksQuery := "SELECT * FROM urls WHERE user_id = $1"
-	err := s.db.Select(&links, getClicksQuery, userId)
+	getClicksQuery := "SELECT * FROM urls WHERE user_id = $1 AND deleted_at IS NULL"
+	err := s.db.SelectContext(ctx, &links, getClicksQuery, userId)
 
 	if err != nil {
 		return nil, err
@@ -289,7 +578,7 @@ func (s *service) GetLinks(userId int) (*[]types.Link, error) {
 	return &links, nil
 }
 
-func (s *service) GetNumberOfClicks(shortURL string) (int, error) {
+func (s *service) GetNumberOfClicks(ctx context.Context, shortURL string) (int, error) {
 	query := `SELECT 
                 COUNT(c.id) as click_count
               FROM 
@@ -304,7 +593,7 @@ func (s *service) GetNumberOfClicks(shortURL string) (int, error) {
                 u.short_url;`
 
 	var clickCount int
-	err := s.db.Get(&clickCount, query, shortURL)
+	err := s.db.GetContext(ctx, &clickCount, query, shortURL)
 	if err != nil {
 		return 0, err
 	}
@@ -313,38 +602,694 @@ func (s *service) GetNumberOfClicks(shortURL string) (int, error) {
 
 }
 
-func (s *service) EditLink(link *types.Link) error {
+func (s *service) EditLink(ctx context.Context, link *types.Link) error {
+	// metadata and flags describe the old destination, so they go with
+	// it. The new one is scanned when link.ScannedAt says so, and left to
+	// the rechecker otherwise.
 	query := `UPDATE urls
-			SET original_url = $1
+			SET original_url = $1, meta_title = '', meta_description = '',
+				meta_image = '', meta_favicon = '', metadata_fetched_at = NULL,
+				flagged_at = NULL, flag_reason = '', scanned_at = $3
 			WHERE short_url = $2;
 			`
-	result, err := s.db.Exec(query, link.OriginalURL, link.ShortURL)
+	result, err := s.db.ExecContext(ctx, query, link.OriginalURL, link.ShortURL, link.ScannedAt)
 
 	if err != nil {
 		return err
 	}
 
 	_, err = result.RowsAffected()
-	if err != nil {
-		log.Fatalln(err)
-	}
-	return nil
+	return err
 }
 
-func (s *service) EnableDisableLink(link *types.Link) error {
+func (s *service) EnableDisableLink(ctx context.Context, link *types.Link) error {
 	query := `UPDATE urls
 			SET is_enabled = $1
 			WHERE short_url = $2;
 			`
-	result, err := s.db.Exec(query, link.IsEnabled, link.ShortURL)
+	result, err := s.db.ExecContext(ctx, query, link.IsEnabled, link.ShortURL)
 
 	if err != nil {
 		return err
 	}
 
 	_, err = result.RowsAffected()
+	return err
+}
+
+// DeleteLink marks a link as deleted. The row is kept, so the code stays
+// taken, until PurgeDeletedLinks removes it.
+func (s *service) DeleteLink(ctx context.Context, link *types.Link) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE urls SET deleted_at = now() WHERE id = $1 AND deleted_at IS NULL`, link.Id)
+	return expectRow(res, err)
+}
+
+// linkSortColumns maps LinkQuery sorts to columns of the listLinks query.
+var linkSortColumns = map[string]string{
+	types.LinkSortCreated:     "l.created_at",
+	types.LinkSortClicks:      "l.clicks",
+	types.LinkSortDestination: "l.original_url",
+}
+
+// ListLinks returns one page of the user's links with their click counts,
+// ordered by q.Sort and then id.
+func (s *service) ListLinks(ctx context.Context, q *types.LinkQuery) ([]types.LinkStats, error) {
+	column, ok := linkSortColumns[q.Sort]
+	if !ok {
+		return nil, fmt.Errorf("unknown link sort %q", q.Sort)
+	}
+
+	args := []any{q.UserId}
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	var where []string
+	if q.DeletedSince != nil {
+		where = append(where, "l.deleted_at > "+arg(*q.DeletedSince))
+	} else {
+		where = append(where, "l.deleted_at IS NULL")
+	}
+	if q.Enabled != nil {
+		where = append(where, "l.is_enabled = "+arg(*q.Enabled))
+	}
+	if q.CreatedFrom != nil {
+		where = append(where, "l.created_at >= "+arg(*q.CreatedFrom))
+	}
+	if q.CreatedTo != nil {
+		where = append(where, "l.created_at < "+arg(*q.CreatedTo))
+	}
+	if q.Domain != "" {
+		where = append(where, fmt.Sprintf("(l.domain = %s OR l.domain LIKE %s)",
+			arg(q.Domain), arg("%."+escapeLike(q.Domain))))
+	}
+	if q.Tag != "" {
+		where = append(where, `EXISTS (SELECT 1 FROM link_tags lt JOIN tags t ON t.id = lt.tag_id
+			WHERE lt.link_id = l.id AND lower(t.name) = lower(`+arg(q.Tag)+`))`)
+	}
+	if q.FolderId != nil {
+		if *q.FolderId == 0 {
+			where = append(where, "l.folder_id IS NULL")
+		} else {
+			where = append(where, "l.folder_id = "+arg(*q.FolderId))
+		}
+	}
+	if q.Search != "" {
+		pattern := arg("%" + escapeLike(q.Search) + "%")
+		where = append(where, fmt.Sprintf("(l.original_url ILIKE %s OR l.short_url ILIKE %s)", pattern, pattern))
+	}
+
+	order, cmp := "ASC", ">"
+	if q.Desc {
+		order, cmp = "DESC", "<"
+	}
+	if q.After != nil {
+		var value any = q.After.Value
+		switch q.Sort {
+		case types.LinkSortCreated:
+			t, err := time.Parse(time.RFC3339Nano, q.After.Value)
+			if err != nil {
+				return nil, fmt.Errorf("cursor value: %w", err)
+			}
+			value = t
+		case types.LinkSortClicks:
+			n, err := strconv.Atoi(q.After.Value)
+			if err != nil {
+				return nil, fmt.Errorf("cursor value: %w", err)
+			}
+			value = n
+		}
+		where = append(where, fmt.Sprintf("(%s, l.id) %s (%s, %s)", column, cmp, arg(value), arg(q.After.Id)))
+	}
+
+	query := fmt.Sprintf(`SELECT * FROM (
+			SELECT u.*, COALESCE(c.clicks, 0) AS clicks,
+				ARRAY(SELECT t.name FROM link_tags lt JOIN tags t ON t.id = lt.tag_id
+					WHERE lt.link_id = u.id ORDER BY lower(t.name)) AS tags
+			FROM urls u
+			LEFT JOIN LATERAL (SELECT COUNT(*) AS clicks FROM clicks WHERE short_code = u.short_url) c ON true
+			WHERE u.user_id = $1
+		) l
+		WHERE %s
+		ORDER BY %s %s, l.id %s
+		LIMIT %s`, strings.Join(where, " AND "), column, order, order, arg(q.Limit))
+
+	links := []types.LinkStats{}
+	err := s.db.SelectContext(ctx, &links, query, args...)
+	return links, err
+}
+
+// escapeLike escapes the LIKE wildcards in s.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// RestoreLink undeletes a link deleted after since. It returns
+// sql.ErrNoRows when the link is not deleted or was deleted earlier.
+func (s *service) RestoreLink(ctx context.Context, link *types.Link, since time.Time) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE urls SET deleted_at = NULL WHERE id = $1 AND deleted_at > $2`, link.Id, since)
+	return expectRow(res, err)
+}
+
+// PurgeDeletedLinks removes links deleted before the given time together
+// with their clicks, and returns how many links were removed.
+func (s *service) PurgeDeletedLinks(ctx context.Context, before time.Time) (int64, error) {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var codes []string
+	err = tx.SelectContext(ctx, &codes, `DELETE FROM urls WHERE deleted_at < $1 RETURNING short_url`, before)
+	if err != nil {
+		return 0, err
+	}
+	if len(codes) > 0 {
+		_, err = tx.ExecContext(ctx, `DELETE FROM clicks WHERE short_code = ANY($1)`, pq.StringArray(codes))
+		if err != nil {
+			return 0, err
+		}
+	}
+	return int64(len(codes)), tx.Commit()
+}
+
+// GetLinksToScan returns up to limit live, unflagged links last scanned
+// before the given time, in id order starting after afterId.
+func (s *service) GetLinksToScan(ctx context.Context, before time.Time, afterId, limit int) ([]types.Link, error) {
+	links := []types.Link{}
+	query := `SELECT * FROM urls
+		WHERE deleted_at IS NULL AND flagged_at IS NULL
+			AND (scanned_at IS NULL OR scanned_at < $1) AND id > $2
+		ORDER BY id
+		LIMIT $3`
+	err := s.db.SelectContext(ctx, &links, query, before, afterId, limit)
+	return links, err
+}
+
+func (s *service) MarkLinksScanned(ctx context.Context, linkIds []int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE urls SET scanned_at = now() WHERE id = ANY($1)`, pq.Array(linkIds))
+	return err
+}
+
+// FlagLink disables link and records why. Links whose destination changed
+// since they were read are left alone, since it was the old one that was
+// flagged.
+func (s *service) FlagLink(ctx context.Context, link *types.Link, reason string) error {
+	query := `UPDATE urls
+		SET flagged_at = now(), flag_reason = $1, is_enabled = false, scanned_at = now()
+		WHERE id = $2 AND original_url = $3`
+	_, err := s.db.ExecContext(ctx, query, reason, link.Id, link.OriginalURL)
+	return err
+}
+
+func (s *service) RecordLoginAttempt(ctx context.Context, attempt *types.LoginAttempt) error {
+	query := `INSERT INTO login_attempts (user_id, email, ip, user_agent, success, reason)
+	values ($1, $2, $3, $4, $5, $6)`
+
+	_, err := s.db.ExecContext(ctx,
+		query,
+		attempt.UserId,
+		attempt.Email,
+		attempt.IP,
+		attempt.UserAgent,
+		attempt.Success,
+		attempt.Reason,
+	)
+	return err
+}
+
+func (s *service) GetLoginAttempts(ctx context.Context, userId int, limit int) ([]types.LoginAttempt, error) {
+	attempts := []types.LoginAttempt{}
+	query := `SELECT * FROM login_attempts
+	WHERE user_id = $1
+	ORDER BY created_at DESC
+	LIMIT $2`
+	err := s.db.SelectContext(ctx, &attempts, query, userId, limit)
+	if err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
+func (s *service) CreateAPIKey(ctx context.Context, key *types.APIKey) error {
+	query := `INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes)
+	values ($1, $2, $3, $4, $5)
+	RETURNING id, created_at`
+
+	return s.db.QueryRowxContext(ctx,
+		query,
+		key.UserId,
+		key.Name,
+		key.Prefix,
+		key.KeyHash,
+		key.Scopes,
+	).Scan(&key.Id, &key.CreatedAt)
+}
+
+func (s *service) GetAPIKeys(ctx context.Context, userId int) ([]types.APIKey, error) {
+	keys := []types.APIKey{}
+	query := `SELECT * FROM api_keys
+	WHERE user_id = $1
+	ORDER BY created_at DESC`
+	err := s.db.SelectContext(ctx, &keys, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *service) GetAPIKeyByHash(ctx context.Context, hash string) (*types.APIKeyOwner, error) {
+	var key types.APIKeyOwner
+	query := `SELECT k.*, u.user_name, u.email FROM api_keys k
+	JOIN users u ON u.id = k.user_id
+	WHERE k.key_hash = $1`
+	err := s.db.GetContext(ctx, &key, query, hash)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// TouchAPIKey records that a key was used. The timestamp is only written
+// once a minute so busy keys don't turn every request into a write.
+func (s *service) TouchAPIKey(ctx context.Context, id int) error {
+	query := `UPDATE api_keys SET last_used_at = now()
+	WHERE id = $1
+	AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')`
+	_, err := s.db.ExecContext(ctx, query, id)
+	return err
+}
+
+// DeleteAPIKey revokes one of the user's keys. It returns sql.ErrNoRows if
+// the user has no key with that id.
+func (s *service) DeleteAPIKey(ctx context.Context, userId int, id int) error {
+	return expectRow(s.db.ExecContext(ctx, `DELETE FROM api_keys WHERE id = $1 AND user_id = $2`, id, userId))
+}
+
+// GetUserByIdentity returns the user linked to subject at provider, or
+// ErrUserNotFound.
+func (s *service) GetUserByIdentity(ctx context.Context, provider string, subject string) (*types.User, error) {
+	userFromDb := &types.User{}
+	query := `SELECT u.* FROM users u
+	JOIN user_identities i ON i.user_id = u.id
+	WHERE i.provider = $1 AND i.subject = $2`
+	err := s.db.GetContext(ctx, userFromDb, query, provider, subject)
+
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return userFromDb, nil
+}
+
+func (s *service) LinkIdentity(ctx context.Context, identity *types.UserIdentity) error {
+	query := `INSERT INTO user_identities (provider, subject, user_id, email)
+	values ($1, $2, $3, $4)
+	ON CONFLICT (provider, subject) DO NOTHING`
+	_, err := s.db.ExecContext(ctx, query, identity.Provider, identity.Subject, identity.UserId, identity.Email)
+	return err
+}
+
+// GetTOTP returns the user's authenticator secret, enabled or pending, or
+// sql.ErrNoRows.
+func (s *service) GetTOTP(ctx context.Context, userId int) (*types.TOTP, error) {
+	totp := &types.TOTP{}
+	err := s.db.GetContext(ctx, totp, `SELECT * FROM user_totp WHERE user_id = $1`, userId)
+	if err != nil {
+		return nil, err
+	}
+	return totp, nil
+}
+
+// SaveTOTPSecret stores a pending secret, replacing any earlier pending
+// one. An enabled secret is left alone and sql.ErrNoRows is returned.
+func (s *service) SaveTOTPSecret(ctx context.Context, userId int, secret string) error {
+	query := `INSERT INTO user_totp (user_id, secret) values ($1, $2)
+	ON CONFLICT (user_id) DO UPDATE
+	SET secret = EXCLUDED.secret, last_used_step = 0, created_at = now()
+	WHERE user_totp.enabled_at IS NULL`
+	return expectRow(s.db.ExecContext(ctx, query, userId, secret))
+}
+
+// EnableTOTP turns on the pending secret, recording the step of the code
+// that confirmed it, and stores a fresh set of recovery codes.
+func (s *service) EnableTOTP(ctx context.Context, userId int, step int64, recoveryHashes []string) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE user_totp SET enabled_at = now(), last_used_step = $2
+	WHERE user_id = $1 AND enabled_at IS NULL`
+	if err := expectRow(tx.ExecContext(ctx, query, userId, step)); err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userId, recoveryHashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep records that the code for step was used. It returns
+// sql.ErrNoRows if that step or a later one was already used.
+func (s *service) UseTOTPStep(ctx context.Context, userId int, step int64) error {
+	query := `UPDATE user_totp SET last_used_step = $2
+	WHERE user_id = $1 AND enabled_at IS NOT NULL AND last_used_step < $2`
+	return expectRow(s.db.ExecContext(ctx, query, userId, step))
+}
+
+// UseRecoveryCode spends a recovery code. It returns sql.ErrNoRows if the
+// code doesn't exist or was already used.
+func (s *service) UseRecoveryCode(ctx context.Context, userId int, hash string) error {
+	query := `UPDATE recovery_codes SET used_at = now()
+	WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+	return expectRow(s.db.ExecContext(ctx, query, userId, hash))
+}
+
+func (s *service) ReplaceRecoveryCodes(ctx context.Context, userId int, hashes []string) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(ctx, tx, userId, hashes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *service) DeleteTOTP(ctx context.Context, userId int) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userId); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx dbtx, userId int, hashes []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userId); err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		_, err := tx.ExecContext(ctx, `INSERT INTO recovery_codes (user_id, code_hash) values ($1, $2)`, userId, hash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *service) WithTx(ctx context.Context, fn func(Service) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+	tx, err := s.pool.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			s.log(ctx).Warn("rolling back transaction", "error", err)
+		}
+	}()
+
+	inTx := &service{pool: s.pool, db: tx, tx: tx, database: s.database, logger: s.logger}
+	if err := fn(inTx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// begin starts the transaction of a call that needs one. Inside WithTx the
+// call joins that transaction instead, and leaves committing it to WithTx.
+func (s *service) begin(ctx context.Context) (txn, error) {
+	if s.tx != nil {
+		return joinedTx{s.tx}, nil
+	}
+	tx, err := s.pool.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+type joinedTx struct{ *sqlx.Tx }
+
+func (joinedTx) Commit() error   { return nil }
+func (joinedTx) Rollback() error { return nil }
+
+// expectRow turns an update that matched nothing into sql.ErrNoRows.
+func expectRow(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
 	if err != nil {
-		log.Fatalln(err)
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
 	}
 	return nil
 }
+
+// isUniqueViolation reports whether err comes from a unique index.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// GetLinkIds maps the given short codes to the ids of the user's live
+// links. Codes that are missing, deleted or someone else's are left out.
+func (s *service) GetLinkIds(ctx context.Context, userId int, codes []string) (map[string]int, error) {
+	rows := []struct {
+		Id       int    `db:"id"`
+		ShortURL string `db:"short_url"`
+	}{}
+	query := `SELECT id, short_url FROM urls WHERE user_id = $1 AND short_url = ANY($2) AND deleted_at IS NULL`
+	if err := s.db.SelectContext(ctx, &rows, query, userId, pq.StringArray(codes)); err != nil {
+		return nil, err
+	}
+	ids := make(map[string]int, len(rows))
+	for _, row := range rows {
+		ids[row.ShortURL] = row.Id
+	}
+	return ids, nil
+}
+
+// GetTags returns the user's tags by name, each with its number of live
+// links.
+func (s *service) GetTags(ctx context.Context, userId int) ([]types.Tag, error) {
+	tags := []types.Tag{}
+	query := `SELECT t.*, COUNT(u.id) AS links FROM tags t
+		LEFT JOIN link_tags lt ON lt.tag_id = t.id
+		LEFT JOIN urls u ON u.id = lt.link_id AND u.deleted_at IS NULL
+		WHERE t.user_id = $1
+		GROUP BY t.id
+		ORDER BY lower(t.name)`
+	err := s.db.SelectContext(ctx, &tags, query, userId)
+	return tags, err
+}
+
+func (s *service) CreateTag(ctx context.Context, tag *types.Tag) error {
+	query := `INSERT INTO tags (user_id, name) values ($1, $2) RETURNING id, created_at`
+	err := s.db.QueryRowxContext(ctx, query, tag.UserId, tag.Name).Scan(&tag.Id, &tag.CreatedAt)
+	if isUniqueViolation(err) {
+		return ErrNameTaken
+	}
+	return err
+}
+
+func (s *service) RenameTag(ctx context.Context, userId int, id int, name string) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE tags SET name = $1 WHERE id = $2 AND user_id = $3`, name, id, userId)
+	if isUniqueViolation(err) {
+		return ErrNameTaken
+	}
+	return expectRow(res, err)
+}
+
+// DeleteTag deletes a tag and takes it off every link.
+func (s *service) DeleteTag(ctx context.Context, userId int, id int) error {
+	return expectRow(s.db.ExecContext(ctx, `DELETE FROM tags WHERE id = $1 AND user_id = $2`, id, userId))
+}
+
+func (s *service) GetLinkTags(ctx context.Context, linkId int) ([]string, error) {
+	names := []string{}
+	query := `SELECT t.name FROM link_tags lt JOIN tags t ON t.id = lt.tag_id
+		WHERE lt.link_id = $1 ORDER BY lower(t.name)`
+	err := s.db.SelectContext(ctx, &names, query, linkId)
+	return names, err
+}
+
+// SetLinkTags replaces the tags of a link. Tags that don't exist yet are
+// created.
+func (s *service) SetLinkTags(ctx context.Context, userId int, linkId int, names []string) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM link_tags WHERE link_id = $1`, linkId); err != nil {
+		return err
+	}
+	if err := addLinkTags(ctx, tx, userId, []int{linkId}, names); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// TagLinks adds and removes tags on several links at once. Tags to add
+// that don't exist yet are created.
+func (s *service) TagLinks(ctx context.Context, userId int, linkIds []int, add []string, remove []string) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if len(remove) > 0 {
+		query := `DELETE FROM link_tags WHERE link_id = ANY($1) AND tag_id IN
+			(SELECT id FROM tags WHERE user_id = $2 AND lower(name) = ANY($3))`
+		_, err := tx.ExecContext(ctx, query, pq.Array(linkIds), userId, pq.StringArray(lowerAll(remove)))
+		if err != nil {
+			return err
+		}
+	}
+	if err := addLinkTags(ctx, tx, userId, linkIds, add); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// addLinkTags creates any missing tags and puts them all on the links.
+func addLinkTags(ctx context.Context, tx dbtx, userId int, linkIds []int, names []string) error {
+	if len(names) == 0 || len(linkIds) == 0 {
+		return nil
+	}
+	query := `INSERT INTO tags (user_id, name) SELECT $1, unnest($2::text[])
+		ON CONFLICT (user_id, lower(name)) DO NOTHING`
+	if _, err := tx.ExecContext(ctx, query, userId, pq.StringArray(names)); err != nil {
+		return err
+	}
+	query = `INSERT INTO link_tags (link_id, tag_id)
+		SELECT l.id, t.id FROM unnest($1::int[]) AS l(id)
+		CROSS JOIN tags t WHERE t.user_id = $2 AND lower(t.name) = ANY($3)
+		ON CONFLICT DO NOTHING`
+	_, err := tx.ExecContext(ctx, query, pq.Array(linkIds), userId, pq.StringArray(lowerAll(names)))
+	return err
+}
+
+func lowerAll(names []string) []string {
+	lower := make([]string, len(names))
+	for i, name := range names {
+		lower[i] = strings.ToLower(name)
+	}
+	return lower
+}
+
+// GetTagClicks counts the links and clicks under each of the user's tags.
+// Clicks are limited to [from, to) when those are set.
+func (s *service) GetTagClicks(ctx context.Context, userId int, from *time.Time, to *time.Time) ([]types.TagClicks, error) {
+	stats := []types.TagClicks{}
+	query := `SELECT t.name AS tag, COUNT(DISTINCT u.id) AS links, COUNT(c.id) AS clicks FROM tags t
+		LEFT JOIN link_tags lt ON lt.tag_id = t.id
+		LEFT JOIN urls u ON u.id = lt.link_id AND u.deleted_at IS NULL
+		LEFT JOIN clicks c ON c.short_code = u.short_url
+			AND ($2::timestamp IS NULL OR c.time_stamp >= $2)
+			AND ($3::timestamp IS NULL OR c.time_stamp < $3)
+		WHERE t.user_id = $1
+		GROUP BY t.id
+		ORDER BY clicks DESC, lower(t.name)`
+	err := s.db.SelectContext(ctx, &stats, query, userId, from, to)
+	return stats, err
+}
+
+// GetFolders returns the user's folders by name, each with its number of
+// live links.
+func (s *service) GetFolders(ctx context.Context, userId int) ([]types.Folder, error) {
+	folders := []types.Folder{}
+	query := `SELECT f.*, COUNT(u.id) AS links FROM folders f
+		LEFT JOIN urls u ON u.folder_id = f.id AND u.deleted_at IS NULL
+		WHERE f.user_id = $1
+		GROUP BY f.id
+		ORDER BY lower(f.name)`
+	err := s.db.SelectContext(ctx, &folders, query, userId)
+	return folders, err
+}
+
+func (s *service) GetFolder(ctx context.Context, userId int, id int) (*types.Folder, error) {
+	folder := &types.Folder{}
+	err := s.db.GetContext(ctx, folder, `SELECT * FROM folders WHERE id = $1 AND user_id = $2`, id, userId)
+	if err != nil {
+		return nil, err
+	}
+	return folder, nil
+}
+
+func (s *service) CreateFolder(ctx context.Context, folder *types.Folder) error {
+	query := `INSERT INTO folders (user_id, name) values ($1, $2) RETURNING id, created_at`
+	err := s.db.QueryRowxContext(ctx, query, folder.UserId, folder.Name).Scan(&folder.Id, &folder.CreatedAt)
+	if isUniqueViolation(err) {
+		return ErrNameTaken
+	}
+	return err
+}
+
+func (s *service) RenameFolder(ctx context.Context, userId int, id int, name string) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE folders SET name = $1 WHERE id = $2 AND user_id = $3`, name, id, userId)
+	if isUniqueViolation(err) {
+		return ErrNameTaken
+	}
+	return expectRow(res, err)
+}
+
+// DeleteFolder deletes a folder. Its links are kept, outside any folder.
+func (s *service) DeleteFolder(ctx context.Context, userId int, id int) error {
+	return expectRow(s.db.ExecContext(ctx, `DELETE FROM folders WHERE id = $1 AND user_id = $2`, id, userId))
+}
+
+// SetLinksFolder moves links into a folder, or out of any folder when
+// folderId is nil.
+func (s *service) SetLinksFolder(ctx context.Context, linkIds []int, folderId *int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE urls SET folder_id = $1 WHERE id = ANY($2)`, folderId, pq.Array(linkIds))
+	return err
+}
+
+// UpdateLinkDetails stores the user's title, notes, preview setting and
+// redirect status for a link.
+func (s *service) UpdateLinkDetails(ctx context.Context, link *types.Link) error {
+	query := `UPDATE urls SET title = $1, notes = $2, preview = $3, redirect_status = $4 WHERE id = $5`
+	return expectRow(s.db.ExecContext(ctx, query, link.Title, link.Notes, link.Preview, link.RedirectStatus, link.Id))
+}
+
+// SaveLinkMetadata stores metadata fetched from originalURL, unless the
+// link has been pointed elsewhere in the meantime.
+func (s *service) SaveLinkMetadata(ctx context.Context, linkId int, originalURL string, meta *types.LinkMetadata) error {
+	query := `UPDATE urls SET meta_title = $1, meta_description = $2, meta_image = $3,
+			meta_favicon = $4, metadata_fetched_at = now()
+		WHERE id = $5 AND original_url = $6`
+	_, err := s.db.ExecContext(ctx, query,
+		meta.Title,
+		meta.Description,
+		meta.Image,
+		meta.Favicon,
+		linkId,
+		originalURL,
+	)
+	return err
+}
@@ -1,26 +1,22 @@
 package database
 
 import (
-	"os"
-
+	"github.com/koderkt/teenyurl/internal/config"
+	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 )
 
-var (
-	// redis_database = os.Getenv("REDIS_DATABASE")
-	redis_password = os.Getenv("REDIS_PASSWORD")
-	addr           = os.Getenv("ADDR")
-	// port       = os.Getenv("DB_PORT")
-	// host       = os.Getenv("DB_HOST")
-	// schema     = os.Getenv("DB_SCHEMA")
-)
-
-func CreateRedisConnection() *redis.Client {
+func CreateRedisConnection(cfg config.Redis) (*redis.Client, error) {
 	rdb := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: redis_password,
-		DB:       0,
+		Addr:     cfg.Addr,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+		PoolSize: cfg.PoolSize,
 	})
 
-	return rdb
+	// one span per command, under the caller's trace
+	if err := redisotel.InstrumentTracing(rdb); err != nil {
+		return nil, err
+	}
+	return rdb, nil
 }
@@ -0,0 +1,441 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/koderkt/teenyurl/internal/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/koderkt/teenyurl/internal/database"
+
+// tracedService wraps a Service and records a client span for every call
+// that takes a context.
+type tracedService struct {
+	Service
+	tracer trace.Tracer
+}
+
+// WithTracing decorates s so each query shows up as a span under the
+// caller's trace. It uses the global tracer provider, which is a no-op
+// unless tracing has been set up.
+func WithTracing(s Service) Service {
+	return &tracedService{
+		Service: s,
+		tracer:  otel.Tracer(tracerName),
+	}
+}
+
+func (t *tracedService) start(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return t.tracer.Start(ctx, "database."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBOperationName(operation),
+		),
+	)
+}
+
+// end records err on the span. sql.ErrNoRows is an expected outcome for
+// lookups and is not treated as a failure.
+func end(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// WithTx traces the transaction as a whole, and the calls in it under it.
+func (t *tracedService) WithTx(ctx context.Context, fn func(Service) error) error {
+	ctx, span := t.start(ctx, "WithTx")
+	err := t.Service.WithTx(ctx, func(tx Service) error {
+		return fn(&tracedService{Service: tx, tracer: t.tracer})
+	})
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) Ping(ctx context.Context) error {
+	ctx, span := t.start(ctx, "Ping")
+	err := t.Service.Ping(ctx)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) CreateUser(ctx context.Context, user *types.User) error {
+	ctx, span := t.start(ctx, "CreateUser")
+	err := t.Service.CreateUser(ctx, user)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) GetUserByEmail(ctx context.Context, email string) (*types.User, error) {
+	ctx, span := t.start(ctx, "GetUserByEmail")
+	user, err := t.Service.GetUserByEmail(ctx, email)
+	end(span, err)
+	return user, err
+}
+
+func (t *tracedService) CreateShortURL(ctx context.Context, link *types.Link) error {
+	ctx, span := t.start(ctx, "CreateShortURL")
+	err := t.Service.CreateShortURL(ctx, link)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) GetLink(ctx context.Context, shortURL string) (*types.Link, error) {
+	ctx, span := t.start(ctx, "GetLink")
+	link, err := t.Service.GetLink(ctx, shortURL)
+	end(span, err)
+	return link, err
+}
+
+func (t *tracedService) GetLinks(ctx context.Context, userId int) (*[]types.Link, error) {
+	ctx, span := t.start(ctx, "GetLinks")
+	links, err := t.Service.GetLinks(ctx, userId)
+	end(span, err)
+	return links, err
+}
+
+func (t *tracedService) InsertAnalytics(ctx context.Context, click *types.Clicks) error {
+	ctx, span := t.start(ctx, "InsertAnalytics")
+	err := t.Service.InsertAnalytics(ctx, click)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) GetAnalystics(ctx context.Context, shortCode string) (*[]types.Clicks, error) {
+	ctx, span := t.start(ctx, "GetAnalystics")
+	clicks, err := t.Service.GetAnalystics(ctx, shortCode)
+	end(span, err)
+	return clicks, err
+}
+
+func (t *tracedService) GetNumberOfClicks(ctx context.Context, shortURL string) (int, error) {
+	ctx, span := t.start(ctx, "GetNumberOfClicks")
+	count, err := t.Service.GetNumberOfClicks(ctx, shortURL)
+	end(span, err)
+	return count, err
+}
+
+func (t *tracedService) EditLink(ctx context.Context, link *types.Link) error {
+	ctx, span := t.start(ctx, "EditLink")
+	err := t.Service.EditLink(ctx, link)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) EnableDisableLink(ctx context.Context, link *types.Link) error {
+	ctx, span := t.start(ctx, "EnableDisableLink")
+	err := t.Service.EnableDisableLink(ctx, link)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) DeleteLink(ctx context.Context, link *types.Link) error {
+	ctx, span := t.start(ctx, "DeleteLink")
+	err := t.Service.DeleteLink(ctx, link)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) ListLinks(ctx context.Context, q *types.LinkQuery) ([]types.LinkStats, error) {
+	ctx, span := t.start(ctx, "ListLinks")
+	links, err := t.Service.ListLinks(ctx, q)
+	end(span, err)
+	return links, err
+}
+
+func (t *tracedService) GetLinkIds(ctx context.Context, userId int, codes []string) (map[string]int, error) {
+	ctx, span := t.start(ctx, "GetLinkIds")
+	res, err := t.Service.GetLinkIds(ctx, userId, codes)
+	end(span, err)
+	return res, err
+}
+
+func (t *tracedService) GetTags(ctx context.Context, userId int) ([]types.Tag, error) {
+	ctx, span := t.start(ctx, "GetTags")
+	res, err := t.Service.GetTags(ctx, userId)
+	end(span, err)
+	return res, err
+}
+
+func (t *tracedService) CreateTag(ctx context.Context, tag *types.Tag) error {
+	ctx, span := t.start(ctx, "CreateTag")
+	err := t.Service.CreateTag(ctx, tag)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) RenameTag(ctx context.Context, userId int, id int, name string) error {
+	ctx, span := t.start(ctx, "RenameTag")
+	err := t.Service.RenameTag(ctx, userId, id, name)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) DeleteTag(ctx context.Context, userId int, id int) error {
+	ctx, span := t.start(ctx, "DeleteTag")
+	err := t.Service.DeleteTag(ctx, userId, id)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) GetLinkTags(ctx context.Context, linkId int) ([]string, error) {
+	ctx, span := t.start(ctx, "GetLinkTags")
+	res, err := t.Service.GetLinkTags(ctx, linkId)
+	end(span, err)
+	return res, err
+}
+
+func (t *tracedService) SetLinkTags(ctx context.Context, userId int, linkId int, names []string) error {
+	ctx, span := t.start(ctx, "SetLinkTags")
+	err := t.Service.SetLinkTags(ctx, userId, linkId, names)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) TagLinks(ctx context.Context, userId int, linkIds []int, add []string, remove []string) error {
+	ctx, span := t.start(ctx, "TagLinks")
+	err := t.Service.TagLinks(ctx, userId, linkIds, add, remove)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) GetTagClicks(ctx context.Context, userId int, from *time.Time, to *time.Time) ([]types.TagClicks, error) {
+	ctx, span := t.start(ctx, "GetTagClicks")
+	res, err := t.Service.GetTagClicks(ctx, userId, from, to)
+	end(span, err)
+	return res, err
+}
+
+func (t *tracedService) GetFolders(ctx context.Context, userId int) ([]types.Folder, error) {
+	ctx, span := t.start(ctx, "GetFolders")
+	res, err := t.Service.GetFolders(ctx, userId)
+	end(span, err)
+	return res, err
+}
+
+func (t *tracedService) GetFolder(ctx context.Context, userId int, id int) (*types.Folder, error) {
+	ctx, span := t.start(ctx, "GetFolder")
+	res, err := t.Service.GetFolder(ctx, userId, id)
+	end(span, err)
+	return res, err
+}
+
+func (t *tracedService) CreateFolder(ctx context.Context, folder *types.Folder) error {
+	ctx, span := t.start(ctx, "CreateFolder")
+	err := t.Service.CreateFolder(ctx, folder)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) RenameFolder(ctx context.Context, userId int, id int, name string) error {
+	ctx, span := t.start(ctx, "RenameFolder")
+	err := t.Service.RenameFolder(ctx, userId, id, name)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) DeleteFolder(ctx context.Context, userId int, id int) error {
+	ctx, span := t.start(ctx, "DeleteFolder")
+	err := t.Service.DeleteFolder(ctx, userId, id)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) SetLinksFolder(ctx context.Context, linkIds []int, folderId *int) error {
+	ctx, span := t.start(ctx, "SetLinksFolder")
+	err := t.Service.SetLinksFolder(ctx, linkIds, folderId)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) UpdateLinkDetails(ctx context.Context, link *types.Link) error {
+	ctx, span := t.start(ctx, "UpdateLinkDetails")
+	err := t.Service.UpdateLinkDetails(ctx, link)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) SaveLinkMetadata(ctx context.Context, linkId int, originalURL string, meta *types.LinkMetadata) error {
+	ctx, span := t.start(ctx, "SaveLinkMetadata")
+	err := t.Service.SaveLinkMetadata(ctx, linkId, originalURL, meta)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) RestoreLink(ctx context.Context, link *types.Link, since time.Time) error {
+	ctx, span := t.start(ctx, "RestoreLink")
+	err := t.Service.RestoreLink(ctx, link, since)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) PurgeDeletedLinks(ctx context.Context, before time.Time) (int64, error) {
+	ctx, span := t.start(ctx, "PurgeDeletedLinks")
+	n, err := t.Service.PurgeDeletedLinks(ctx, before)
+	end(span, err)
+	return n, err
+}
+
+func (t *tracedService) GetLinksToScan(ctx context.Context, before time.Time, afterId, limit int) ([]types.Link, error) {
+	ctx, span := t.start(ctx, "GetLinksToScan")
+	links, err := t.Service.GetLinksToScan(ctx, before, afterId, limit)
+	end(span, err)
+	return links, err
+}
+
+func (t *tracedService) MarkLinksScanned(ctx context.Context, linkIds []int) error {
+	ctx, span := t.start(ctx, "MarkLinksScanned")
+	err := t.Service.MarkLinksScanned(ctx, linkIds)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) FlagLink(ctx context.Context, link *types.Link, reason string) error {
+	ctx, span := t.start(ctx, "FlagLink")
+	err := t.Service.FlagLink(ctx, link, reason)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) RecordLoginAttempt(ctx context.Context, attempt *types.LoginAttempt) error {
+	ctx, span := t.start(ctx, "RecordLoginAttempt")
+	err := t.Service.RecordLoginAttempt(ctx, attempt)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) GetLoginAttempts(ctx context.Context, userId int, limit int) ([]types.LoginAttempt, error) {
+	ctx, span := t.start(ctx, "GetLoginAttempts")
+	attempts, err := t.Service.GetLoginAttempts(ctx, userId, limit)
+	end(span, err)
+	return attempts, err
+}
+
+func (t *tracedService) CreateAPIKey(ctx context.Context, key *types.APIKey) error {
+	ctx, span := t.start(ctx, "CreateAPIKey")
+	err := t.Service.CreateAPIKey(ctx, key)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) GetAPIKeys(ctx context.Context, userId int) ([]types.APIKey, error) {
+	ctx, span := t.start(ctx, "GetAPIKeys")
+	keys, err := t.Service.GetAPIKeys(ctx, userId)
+	end(span, err)
+	return keys, err
+}
+
+func (t *tracedService) GetAPIKeyByHash(ctx context.Context, hash string) (*types.APIKeyOwner, error) {
+	ctx, span := t.start(ctx, "GetAPIKeyByHash")
+	key, err := t.Service.GetAPIKeyByHash(ctx, hash)
+	end(span, err)
+	return key, err
+}
+
+func (t *tracedService) TouchAPIKey(ctx context.Context, id int) error {
+	ctx, span := t.start(ctx, "TouchAPIKey")
+	err := t.Service.TouchAPIKey(ctx, id)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) DeleteAPIKey(ctx context.Context, userId int, id int) error {
+	ctx, span := t.start(ctx, "DeleteAPIKey")
+	err := t.Service.DeleteAPIKey(ctx, userId, id)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) GetUserById(ctx context.Context, id int) (*types.User, error) {
+	ctx, span := t.start(ctx, "GetUserById")
+	user, err := t.Service.GetUserById(ctx, id)
+	end(span, err)
+	return user, err
+}
+
+func (t *tracedService) MarkEmailVerified(ctx context.Context, id int, email string) error {
+	ctx, span := t.start(ctx, "MarkEmailVerified")
+	err := t.Service.MarkEmailVerified(ctx, id, email)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) UpdatePassword(ctx context.Context, id int, encryptedPassword string) error {
+	ctx, span := t.start(ctx, "UpdatePassword")
+	err := t.Service.UpdatePassword(ctx, id, encryptedPassword)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) GetUserByIdentity(ctx context.Context, provider string, subject string) (*types.User, error) {
+	ctx, span := t.start(ctx, "GetUserByIdentity")
+	user, err := t.Service.GetUserByIdentity(ctx, provider, subject)
+	end(span, err)
+	return user, err
+}
+
+func (t *tracedService) LinkIdentity(ctx context.Context, identity *types.UserIdentity) error {
+	ctx, span := t.start(ctx, "LinkIdentity")
+	err := t.Service.LinkIdentity(ctx, identity)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) GetTOTP(ctx context.Context, userId int) (*types.TOTP, error) {
+	ctx, span := t.start(ctx, "GetTOTP")
+	totp, err := t.Service.GetTOTP(ctx, userId)
+	end(span, err)
+	return totp, err
+}
+
+func (t *tracedService) SaveTOTPSecret(ctx context.Context, userId int, secret string) error {
+	ctx, span := t.start(ctx, "SaveTOTPSecret")
+	err := t.Service.SaveTOTPSecret(ctx, userId, secret)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) EnableTOTP(ctx context.Context, userId int, step int64, recoveryHashes []string) error {
+	ctx, span := t.start(ctx, "EnableTOTP")
+	err := t.Service.EnableTOTP(ctx, userId, step, recoveryHashes)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) UseTOTPStep(ctx context.Context, userId int, step int64) error {
+	ctx, span := t.start(ctx, "UseTOTPStep")
+	err := t.Service.UseTOTPStep(ctx, userId, step)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) UseRecoveryCode(ctx context.Context, userId int, hash string) error {
+	ctx, span := t.start(ctx, "UseRecoveryCode")
+	err := t.Service.UseRecoveryCode(ctx, userId, hash)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) ReplaceRecoveryCodes(ctx context.Context, userId int, hashes []string) error {
+	ctx, span := t.start(ctx, "ReplaceRecoveryCodes")
+	err := t.Service.ReplaceRecoveryCodes(ctx, userId, hashes)
+	end(span, err)
+	return err
+}
+
+func (t *tracedService) DeleteTOTP(ctx context.Context, userId int) error {
+	ctx, span := t.start(ctx, "DeleteTOTP")
+	err := t.Service.DeleteTOTP(ctx, userId)
+	end(span, err)
+	return err
+}
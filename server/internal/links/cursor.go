@@ -0,0 +1,59 @@
+package links
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/koderkt/teenyurl/internal/types"
+)
+
+// ErrInvalidCursor is returned by DecodeCursor for cursors that were not
+// made by EncodeCursor.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// CursorAfter is the cursor that continues a listing after link.
+func CursorAfter(q *types.LinkQuery, link *types.LinkStats) types.LinkCursor {
+	cursor := types.LinkCursor{Sort: q.Sort, Desc: q.Desc, Id: link.Id}
+	switch q.Sort {
+	case types.LinkSortCreated:
+		cursor.Value = link.CreatedAt.Format(time.RFC3339Nano)
+	case types.LinkSortClicks:
+		cursor.Value = strconv.Itoa(link.Clicks)
+	case types.LinkSortDestination:
+		cursor.Value = link.OriginalURL
+	}
+	return cursor
+}
+
+// EncodeCursor turns a cursor into an opaque, URL safe string.
+func EncodeCursor(cursor types.LinkCursor) string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeCursor(s string) (*types.LinkCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	cursor := &types.LinkCursor{}
+	if err := json.Unmarshal(b, cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	switch cursor.Sort {
+	case types.LinkSortCreated:
+		_, err = time.Parse(time.RFC3339Nano, cursor.Value)
+	case types.LinkSortClicks:
+		_, err = strconv.Atoi(cursor.Value)
+	case types.LinkSortDestination:
+	default:
+		err = ErrInvalidCursor
+	}
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return cursor, nil
+}
@@ -0,0 +1,77 @@
+package links
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/koderkt/teenyurl/internal/config"
+	"github.com/koderkt/teenyurl/internal/database"
+)
+
+// Purger removes deleted links, and their clicks, once their code has been
+// quarantined for long enough. It runs once at start and then every
+// PurgeInterval until Close.
+type Purger struct {
+	db         database.Service
+	quarantine time.Duration
+	interval   time.Duration
+	logger     *slog.Logger
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewPurger(db database.Service, cfg config.Links, logger *slog.Logger) *Purger {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Purger{
+		db:         db,
+		quarantine: cfg.Quarantine,
+		interval:   cfg.PurgeInterval,
+		logger:     logger,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+	go p.run(ctx)
+	return p
+}
+
+// Purge removes every link deleted more than the quarantine ago and
+// returns how many there were.
+func (p *Purger) Purge(ctx context.Context) (int64, error) {
+	return p.db.PurgeDeletedLinks(ctx, time.Now().Add(-p.quarantine))
+}
+
+func (p *Purger) run(ctx context.Context) {
+	defer close(p.done)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := p.Purge(ctx)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			p.logger.Error("purging deleted links", "error", err)
+		case n > 0:
+			p.logger.Info("purged deleted links", "count", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close stops the purge job, interrupting a purge in progress, and waits
+// for it to exit or for ctx to be done.
+func (p *Purger) Close(ctx context.Context) error {
+	p.cancel()
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,62 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/koderkt/teenyurl/internal/config"
+)
+
+// New builds the application logger. Format is "json" for production log
+// pipelines or "text" for local development.
+func New(cfg config.Log, w io.Writer) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: ParseLevel(cfg.Level)}
+
+	var handler slog.Handler
+	if cfg.Format == "text" {
+		handler = slog.NewTextHandler(w, opts)
+	} else {
+		handler = slog.NewJSONHandler(w, opts)
+	}
+	return slog.New(handler)
+}
+
+// ParseLevel maps debug, info, warn and error to their slog level,
+// defaulting to info.
+func ParseLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+type contextKey struct{}
+
+// WithContext returns a copy of ctx carrying logger.
+func WithContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger stored by WithContext, or the default
+// logger if there is none.
+func FromContext(ctx context.Context) *slog.Logger {
+	return FromContextOr(ctx, slog.Default())
+}
+
+// FromContextOr returns the logger stored by WithContext, or fallback if
+// there is none. Code outside the HTTP handlers uses it so its logs carry
+// the request ID when a request led to them.
+func FromContextOr(ctx context.Context, fallback *slog.Logger) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return fallback
+}
@@ -0,0 +1,51 @@
+// Package mail delivers the emails the application sends. Every driver
+// implements Mailer so flows can be exercised locally without an SMTP
+// server.
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/koderkt/teenyurl/internal/config"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New returns the Mailer selected by cfg.Driver.
+func New(cfg config.Mail, logger *slog.Logger) (Mailer, error) {
+	switch cfg.Driver {
+	case "log":
+		return &LogMailer{Logger: logger}, nil
+	case "outbox":
+		return NewOutbox(cfg.OutboxDir, cfg.From)
+	case "smtp":
+		return NewSMTP(cfg.SMTP, cfg.From), nil
+	default:
+		return nil, fmt.Errorf("mail: unknown driver %q", cfg.Driver)
+	}
+}
+
+// LogMailer writes messages to the log instead of sending them.
+type LogMailer struct {
+	Logger *slog.Logger
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	m.Logger.InfoContext(ctx, "mail",
+		"to", msg.To,
+		"subject", msg.Subject,
+		"body", msg.Body,
+	)
+	return nil
+}
@@ -0,0 +1,66 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Outbox writes every message to its own .eml file in a directory, which
+// makes the links in them easy to follow during development and tests.
+type Outbox struct {
+	dir  string
+	from string
+}
+
+func NewOutbox(dir, from string) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("mail: creating outbox: %w", err)
+	}
+	return &Outbox{dir: dir, from: from}, nil
+}
+
+func (o *Outbox) Send(_ context.Context, msg Message) error {
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405"), uuid.NewString())
+	return os.WriteFile(filepath.Join(o.dir, name), render(o.from, msg), 0o644)
+}
+
+// render formats msg as an RFC 5322 message.
+func render(from string, msg Message) []byte {
+	var b bytes.Buffer
+	headers := [][2]string{
+		{"From", from},
+		{"To", msg.To},
+		{"Subject", msg.Subject},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=UTF-8"},
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&b, "%s: %s\r\n", h[0], sanitize(h[1]))
+	}
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// sanitize keeps user supplied values from injecting extra headers.
+func sanitize(v string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(v)
+}
+
+// address extracts the bare address from a "Name <addr>" value.
+func address(v string) (string, error) {
+	addr, err := mail.ParseAddress(v)
+	if err != nil {
+		return "", err
+	}
+	return addr.Address, nil
+}
@@ -0,0 +1,43 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+
+	"github.com/koderkt/teenyurl/internal/config"
+)
+
+// SMTP sends messages through an SMTP server, using STARTTLS when the
+// server offers it and PLAIN auth when a username is configured.
+type SMTP struct {
+	cfg  config.SMTP
+	from string
+}
+
+func NewSMTP(cfg config.SMTP, from string) *SMTP {
+	return &SMTP{cfg: cfg, from: from}
+}
+
+func (s *SMTP) Send(_ context.Context, msg Message) error {
+	from, err := address(s.from)
+	if err != nil {
+		return fmt.Errorf("mail: from address: %w", err)
+	}
+	to, err := address(msg.To)
+	if err != nil {
+		return fmt.Errorf("mail: to address: %w", err)
+	}
+
+	var auth smtp.Auth
+	if s.cfg.Username != "" {
+		auth = smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)
+	}
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	if err := smtp.SendMail(addr, auth, from, []string{to}, render(s.from, msg)); err != nil {
+		return fmt.Errorf("mail: sending to %s: %w", to, err)
+	}
+	return nil
+}
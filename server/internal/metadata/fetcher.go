@@ -0,0 +1,230 @@
+// Package metadata reads the title, description, preview image and icon
+// of link destinations.
+package metadata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/koderkt/teenyurl/internal/config"
+	"github.com/koderkt/teenyurl/internal/netguard"
+	"github.com/koderkt/teenyurl/internal/types"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	maxRedirects        = 5
+	maxTitleRunes       = 300
+	maxDescriptionRunes = 1000
+	maxURLLength        = 2048
+)
+
+// ErrUnsupportedScheme is returned for destinations, or redirects, that
+// are not http or https.
+var ErrUnsupportedScheme = errors.New("only http and https pages are fetched")
+
+// Fetcher downloads pages and extracts their metadata. Requests are
+// bounded in time and size and, unless configured otherwise, never reach
+// private or loopback addresses, however the name resolves.
+type Fetcher struct {
+	client    *http.Client
+	maxBytes  int64
+	userAgent string
+}
+
+func NewFetcher(cfg config.Metadata) *Fetcher {
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivateNetworks {
+		dialer.Control = netguard.Control
+	}
+	transport := &http.Transport{
+		// an outbound proxy would make the address checks meaningless
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   cfg.Timeout,
+		ResponseHeaderTimeout: cfg.Timeout,
+		MaxIdleConns:          16,
+		IdleConnTimeout:       cfg.Timeout * 6,
+	}
+	return &Fetcher{
+		client: &http.Client{
+			Timeout:       cfg.Timeout,
+			Transport:     transport,
+			CheckRedirect: checkRedirect,
+		},
+		maxBytes:  cfg.MaxBytes,
+		userAgent: cfg.UserAgent,
+	}
+}
+
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return ErrUnsupportedScheme
+	}
+	return nil
+}
+
+// Fetch downloads rawURL and returns what it could find. Pages that are
+// not HTML give empty metadata apart from the favicon.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*types.LinkMetadata, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, ErrUnsupportedScheme
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml;q=0.9,*/*;q=0.1")
+	if f.userAgent != "" {
+		req.Header.Set("User-Agent", f.userAgent)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("fetching %s: status %d", u.Redacted(), resp.StatusCode)
+	}
+
+	// the final URL after redirects is what relative links resolve against
+	base := resp.Request.URL
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return &types.LinkMetadata{Favicon: resolve(base, "/favicon.ico")}, nil
+	}
+	return Parse(io.LimitReader(resp.Body, f.maxBytes), base), nil
+}
+
+// Parse extracts metadata from the head of an HTML document. OpenGraph
+// values win over <title> and the description meta tag. Relative image
+// and icon URLs are resolved against base.
+func Parse(r io.Reader, base *url.URL) *types.LinkMetadata {
+	var (
+		title, ogTitle, description, ogDescription string
+		image, icon, touchIcon                     string
+	)
+	z := html.NewTokenizer(r)
+parse:
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			break parse
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.Body:
+				break parse
+			case atom.Title:
+				if title == "" && z.Next() == html.TextToken {
+					title = string(z.Text())
+				}
+			case atom.Meta:
+				if !hasAttr {
+					continue
+				}
+				attrs := attributes(z)
+				key := strings.ToLower(attrs["property"])
+				if key == "" {
+					key = strings.ToLower(attrs["name"])
+				}
+				content := attrs["content"]
+				switch {
+				case key == "og:title" && ogTitle == "":
+					ogTitle = content
+				case key == "og:description" && ogDescription == "":
+					ogDescription = content
+				case key == "description" && description == "":
+					description = content
+				case (key == "og:image" || key == "og:image:url" || key == "og:image:secure_url") && image == "":
+					image = content
+				}
+			case atom.Link:
+				if !hasAttr {
+					continue
+				}
+				attrs := attributes(z)
+				for _, rel := range strings.Fields(strings.ToLower(attrs["rel"])) {
+					switch {
+					case rel == "icon" && icon == "":
+						icon = attrs["href"]
+					case rel == "apple-touch-icon" && touchIcon == "":
+						touchIcon = attrs["href"]
+					}
+				}
+			}
+		}
+	}
+
+	meta := &types.LinkMetadata{
+		Title:       clip(first(ogTitle, title), maxTitleRunes),
+		Description: clip(first(ogDescription, description), maxDescriptionRunes),
+		Image:       resolve(base, image),
+		Favicon:     resolve(base, first(icon, touchIcon, "/favicon.ico")),
+	}
+	return meta
+}
+
+func attributes(z *html.Tokenizer) map[string]string {
+	attrs := map[string]string{}
+	for {
+		key, val, more := z.TagAttr()
+		attrs[strings.ToLower(string(key))] = string(val)
+		if !more {
+			return attrs
+		}
+	}
+}
+
+func first(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// clip collapses whitespace and cuts s to at most n runes.
+func clip(s string, n int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return strings.TrimSpace(string([]rune(s)[:n-1])) + "…"
+}
+
+// resolve makes ref absolute. Anything that doesn't end up as an http or
+// https URL, such as data: or javascript:, is dropped.
+func resolve(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	s := u.String()
+	if len(s) > maxURLLength {
+		return ""
+	}
+	return s
+}
@@ -0,0 +1,116 @@
+package metadata
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+
+	"github.com/koderkt/teenyurl/internal/config"
+	"github.com/koderkt/teenyurl/internal/database"
+	"github.com/koderkt/teenyurl/internal/logging"
+)
+
+// ErrWorkerClosed is returned by Enqueue once Close has been called.
+var ErrWorkerClosed = errors.New("metadata worker is closed")
+
+// ErrQueueFull is returned by Enqueue when too many fetches are waiting.
+var ErrQueueFull = errors.New("metadata queue is full")
+
+type job struct {
+	linkId int
+	url    string
+	// logger is that of the request that queued the job
+	logger *slog.Logger
+}
+
+// Worker fetches metadata for links in the background and stores it, so
+// creating a link never waits on the destination.
+type Worker struct {
+	fetcher *Fetcher
+	db      database.Service
+	queue   chan job
+	logger  *slog.Logger
+
+	// ctx is cancelled when Close gives up waiting, to abort fetches
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+func NewWorker(db database.Service, fetcher *Fetcher, cfg config.Metadata, logger *slog.Logger) *Worker {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &Worker{
+		fetcher: fetcher,
+		db:      db,
+		queue:   make(chan job, cfg.QueueSize),
+		logger:  logger,
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	for i := 0; i < cfg.Workers; i++ {
+		w.wg.Add(1)
+		go w.run()
+	}
+	return w
+}
+
+// Enqueue schedules a fetch of url for the link without blocking. The
+// fetch logs with the logger of ctx.
+func (w *Worker) Enqueue(ctx context.Context, linkId int, url string) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.closed {
+		return ErrWorkerClosed
+	}
+	select {
+	case w.queue <- job{linkId: linkId, url: url, logger: logging.FromContextOr(ctx, w.logger)}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (w *Worker) run() {
+	defer w.wg.Done()
+	for j := range w.queue {
+		ctx := logging.WithContext(w.ctx, j.logger)
+		meta, err := w.fetcher.Fetch(ctx, j.url)
+		if err != nil {
+			j.logger.Info("fetching link metadata", "link_id", j.linkId, "error", err)
+			continue
+		}
+		if err := w.db.SaveLinkMetadata(ctx, j.linkId, j.url, meta); err != nil {
+			j.logger.Error("saving link metadata", "link_id", j.linkId, "error", err)
+		}
+	}
+}
+
+// Close stops accepting links and waits for queued fetches to finish. If
+// ctx is done first, fetches in progress are aborted and the rest dropped.
+func (w *Worker) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		w.cancel()
+		return nil
+	case <-ctx.Done():
+		w.cancel()
+		return ctx.Err()
+	}
+}
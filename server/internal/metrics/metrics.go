@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+)
+
+const namespace = "teenyurl"
+
+// Result labels for redirect outcomes.
+const (
+	RedirectHit      = "hit"
+	RedirectNotFound = "not_found"
+	RedirectDisabled = "disabled"
+	RedirectFlagged  = "flagged"
+	RedirectPreview  = "preview"
+)
+
+// Result labels for sign-in attempts.
+const (
+	SignInSuccess = "success"
+	SignInFailure = "failure"
+)
+
+// Metrics owns a private registry so tests can create as many as they like.
+// All methods are safe to call on a nil *Metrics, which records nothing.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	redirects       *prometheus.CounterVec
+	signIns         *prometheus.CounterVec
+	rateLimited     *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by method, route and status code.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by method and route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		redirects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "redirects_total",
+			Help:      "Short link lookups by result: hit, not_found or disabled.",
+		}, []string{"result"}),
+		signIns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "signins_total",
+			Help:      "Sign-in attempts by result: success or failure.",
+		}, []string{"result"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rate_limited_total",
+			Help:      "Requests rejected with 429 by route group.",
+		}, []string{"group"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.redirects,
+		m.signIns,
+		m.rateLimited,
+	)
+	return m
+}
+
+// RegisterDBStats exports the connection pool statistics returned by stats.
+func (m *Metrics) RegisterDBStats(dbName string, stats func() sql.DBStats) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(newDBStatsCollector(dbName, stats))
+}
+
+// RegisterRedisStats exports the go-redis connection pool statistics.
+func (m *Metrics) RegisterRedisStats(client *redis.Client) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(newRedisStatsCollector(client))
+}
+
+// Middleware records the count and latency of every request. Routes are
+// labelled by their pattern, not the raw path, to keep cardinality bounded.
+func (m *Metrics) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if m == nil {
+			return c.Next()
+		}
+		start := time.Now()
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		if err != nil {
+			if fe, ok := err.(*fiber.Error); ok {
+				status = fe.Code
+			} else {
+				status = fiber.StatusInternalServerError
+			}
+		}
+		route := c.Route().Path
+		if status == fiber.StatusNotFound && route == "/" && c.Path() != "/" {
+			route = "unmatched"
+		}
+		method := c.Method()
+
+		m.requests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+		m.requestDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// Handler serves the registry in the Prometheus text exposition format.
+func (m *Metrics) Handler() fiber.Handler {
+	return adaptor.HTTPHandler(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+}
+
+func (m *Metrics) Redirect(result string) {
+	if m == nil {
+		return
+	}
+	m.redirects.WithLabelValues(result).Inc()
+}
+
+func (m *Metrics) SignIn(result string) {
+	if m == nil {
+		return
+	}
+	m.signIns.WithLabelValues(result).Inc()
+}
+
+func (m *Metrics) RateLimited(group string) {
+	if m == nil {
+		return
+	}
+	m.rateLimited.WithLabelValues(group).Inc()
+}
@@ -0,0 +1,137 @@
+// Package oidc signs users in with OpenID Connect providers using the
+// authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/koderkt/teenyurl/internal/config"
+	"golang.org/x/oauth2"
+)
+
+var ErrNonceMismatch = errors.New("oidc: nonce does not match")
+
+// Identity is what a provider asserts about the signed-in user.
+type Identity struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+	Nonce             string `json:"nonce"`
+}
+
+// Provider is one configured identity provider. Discovery happens on first
+// use and is retried until it succeeds, so a provider being down doesn't
+// stop the server from starting.
+type Provider struct {
+	cfg config.OIDCProvider
+
+	mu       sync.Mutex
+	oauth    *oauth2.Config
+	verifier *gooidc.IDTokenVerifier
+}
+
+func NewProvider(cfg config.OIDCProvider) *Provider {
+	return &Provider{cfg: cfg}
+}
+
+func (p *Provider) Name() string {
+	return p.cfg.Name
+}
+
+func (p *Provider) DisplayName() string {
+	if p.cfg.DisplayName != "" {
+		return p.cfg.DisplayName
+	}
+	return p.cfg.Name
+}
+
+func (p *Provider) discover(ctx context.Context) (*oauth2.Config, *gooidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.oauth != nil {
+		return p.oauth, p.verifier, nil
+	}
+
+	provider, err := gooidc.NewProvider(ctx, p.cfg.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("oidc: discovering %s: %w", p.cfg.Name, err)
+	}
+	scopes := p.cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"profile", "email"}
+	}
+	p.oauth = &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		RedirectURL:  p.cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       append([]string{gooidc.ScopeOpenID}, scopes...),
+	}
+	p.verifier = provider.Verifier(&gooidc.Config{ClientID: p.cfg.ClientID})
+	return p.oauth, p.verifier, nil
+}
+
+// AuthCodeURL is where to send the browser to sign in. verifier is the
+// PKCE code verifier and must be presented again to Exchange.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	oauth, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return oauth.AuthCodeURL(state,
+		gooidc.Nonce(nonce),
+		oauth2.S256ChallengeOption(verifier),
+	), nil
+}
+
+// Exchange redeems the code from the callback and verifies the ID token
+// that comes back, including that it carries nonce.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Identity, error) {
+	oauth, idVerifier, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	token, err := oauth.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("oidc: exchanging code: %w", err)
+	}
+	raw, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("oidc: token response has no id_token")
+	}
+	idToken, err := idVerifier.Verify(ctx, raw)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: verifying id_token: %w", err)
+	}
+
+	identity := new(Identity)
+	if err := idToken.Claims(identity); err != nil {
+		return nil, fmt.Errorf("oidc: reading claims: %w", err)
+	}
+	if identity.Nonce != nonce {
+		return nil, ErrNonceMismatch
+	}
+	return identity, nil
+}
+
+// NewVerifier returns a PKCE code verifier.
+func NewVerifier() string {
+	return oauth2.GenerateVerifier()
+}
+
+// RandomString returns a URL safe random value for states and nonces.
+func RandomString() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
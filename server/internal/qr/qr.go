@@ -0,0 +1,229 @@
+// Package qr renders QR codes as PNG or SVG with control over size, error
+// correction, quiet zone, colors and a centered logo.
+package qr
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+	"golang.org/x/image/draw"
+
+	// logos may be JPEG as well as PNG
+	_ "image/jpeg"
+)
+
+// logoFraction is the share of the code's width a logo may cover. With
+// error correction H that stays well within what scanners can recover.
+const logoFraction = 0.22
+
+var ErrTooSmall = errors.New("qr: size is too small for the code")
+
+// Options controls how a code is drawn.
+type Options struct {
+	// Size is the width and height of the image in pixels.
+	Size int
+	// Level is the error correction level: L, M, Q or H. It is raised to
+	// H when a logo is drawn.
+	Level string
+	// Margin is the quiet zone around the code, in modules.
+	Margin     int
+	Foreground color.NRGBA
+	Background color.NRGBA
+	Logo       *Logo
+}
+
+// Logo is an image drawn over the center of the code.
+type Logo struct {
+	image image.Image
+	data  []byte
+	mime  string
+}
+
+// LoadLogo reads a PNG or JPEG logo.
+func LoadLogo(path string) (*Logo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("qr: reading logo: %w", err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("qr: decoding logo: %w", err)
+	}
+	return &Logo{image: img, data: data, mime: http.DetectContentType(data)}, nil
+}
+
+// ID identifies the logo's content, for cache keys.
+func (l *Logo) ID() string {
+	sum := sha256.Sum256(l.data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// ParseLevel maps L, M, Q and H to recovery levels.
+func ParseLevel(level string) (qrcode.RecoveryLevel, error) {
+	switch strings.ToUpper(level) {
+	case "L":
+		return qrcode.Low, nil
+	case "M", "":
+		return qrcode.Medium, nil
+	case "Q":
+		return qrcode.High, nil
+	case "H":
+		return qrcode.Highest, nil
+	default:
+		return 0, fmt.Errorf("qr: unknown error correction level %q", level)
+	}
+}
+
+// ParseColor reads a hex color: RGB, RRGGBB or RRGGBBAA, with or without a
+// leading #.
+func ParseColor(s string) (color.NRGBA, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) == 3 {
+		s = string([]byte{s[0], s[0], s[1], s[1], s[2], s[2]})
+	}
+	if len(s) == 6 {
+		s += "ff"
+	}
+	if len(s) != 8 {
+		return color.NRGBA{}, fmt.Errorf("qr: invalid color %q", s)
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("qr: invalid color %q", s)
+	}
+	return color.NRGBA{R: uint8(v >> 24), G: uint8(v >> 16), B: uint8(v >> 8), A: uint8(v)}, nil
+}
+
+// modules encodes content and returns the code's modules without a quiet
+// zone, true for dark.
+func modules(content string, opts Options) ([][]bool, error) {
+	level, err := ParseLevel(opts.Level)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Logo != nil {
+		level = qrcode.Highest
+	}
+	code, err := qrcode.New(content, level)
+	if err != nil {
+		return nil, fmt.Errorf("qr: encoding: %w", err)
+	}
+	code.DisableBorder = true
+	return code.Bitmap(), nil
+}
+
+// PNG draws the code for content as a PNG of opts.Size pixels square.
+func PNG(content string, opts Options) ([]byte, error) {
+	bitmap, err := modules(content, opts)
+	if err != nil {
+		return nil, err
+	}
+	total := len(bitmap) + 2*opts.Margin
+	scale := opts.Size / total
+	if scale < 1 {
+		return nil, ErrTooSmall
+	}
+	// whatever doesn't divide evenly is added to the quiet zone
+	offset := (opts.Size-scale*total)/2 + opts.Margin*scale
+
+	img := image.NewRGBA(image.Rect(0, 0, opts.Size, opts.Size))
+	draw.Draw(img, img.Bounds(), &image.Uniform{opts.Background}, image.Point{}, draw.Src)
+	fg := &image.Uniform{opts.Foreground}
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				r := image.Rect(offset+x*scale, offset+y*scale, offset+(x+1)*scale, offset+(y+1)*scale)
+				draw.Draw(img, r, fg, image.Point{}, draw.Over)
+			}
+		}
+	}
+
+	if opts.Logo != nil {
+		width := len(bitmap) * scale
+		box := logoBox(offset, width, opts.Logo.image.Bounds())
+		draw.Draw(img, box.Inset(-scale), &image.Uniform{opts.Background}, image.Point{}, draw.Src)
+		draw.CatmullRom.Scale(img, box, opts.Logo.image, opts.Logo.image.Bounds(), draw.Over, nil)
+	}
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// SVG draws the code for content as an SVG of opts.Size pixels square.
+// Dark modules are drawn as one path, a run of modules per segment.
+func SVG(content string, opts Options) ([]byte, error) {
+	bitmap, err := modules(content, opts)
+	if err != nil {
+		return nil, err
+	}
+	total := len(bitmap) + 2*opts.Margin
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		opts.Size, opts.Size, total, total)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" %s/>`, total, total, svgFill(opts.Background))
+	fmt.Fprintf(&b, `<path %s d="`, svgFill(opts.Foreground))
+	for y, row := range bitmap {
+		for x := 0; x < len(row); {
+			if !row[x] {
+				x++
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(&b, "M%d %dh%dv1h-%dz", start+opts.Margin, y+opts.Margin, x-start, x-start)
+		}
+	}
+	b.WriteString(`"/>`)
+
+	if opts.Logo != nil {
+		box := logoBox(opts.Margin, len(bitmap), opts.Logo.image.Bounds())
+		pad := box.Inset(-1)
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" %s/>`,
+			pad.Min.X, pad.Min.Y, pad.Dx(), pad.Dy(), svgFill(opts.Background))
+		fmt.Fprintf(&b, `<image x="%d" y="%d" width="%d" height="%d" href="data:%s;base64,%s"/>`,
+			box.Min.X, box.Min.Y, box.Dx(), box.Dy(), opts.Logo.mime, base64.StdEncoding.EncodeToString(opts.Logo.data))
+	}
+	b.WriteString(`</svg>`)
+	return b.Bytes(), nil
+}
+
+// logoBox centers a box with the logo's aspect ratio over a code of the
+// given width that starts at offset.
+func logoBox(offset, width int, logo image.Rectangle) image.Rectangle {
+	w := int(float64(width) * logoFraction)
+	h := w
+	if logo.Dx() > logo.Dy() {
+		h = w * logo.Dy() / logo.Dx()
+	} else if logo.Dy() > logo.Dx() {
+		w = h * logo.Dx() / logo.Dy()
+	}
+	x := offset + (width-w)/2
+	y := offset + (width-h)/2
+	return image.Rect(x, y, x+w, y+h)
+}
+
+func svgFill(c color.NRGBA) string {
+	fill := fmt.Sprintf(`fill="#%02x%02x%02x"`, c.R, c.G, c.B)
+	if c.A != 0xff {
+		fill += fmt.Sprintf(` fill-opacity="%.3f"`, float64(c.A)/0xff)
+	}
+	return fill
+}
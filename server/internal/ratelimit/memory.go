@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/koderkt/teenyurl/internal/config"
+)
+
+// Memory is a per-process sliding window limiter. It is only as good as a
+// single instance can see, which is why it is used as a fallback.
+type Memory struct {
+	mu        sync.Mutex
+	windows   map[string]*window
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type window struct {
+	hits   []time.Time
+	length time.Duration
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		windows: make(map[string]*window),
+		now:     time.Now,
+	}
+}
+
+func (m *Memory) Allow(_ context.Context, key string, limit config.Limit) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	w, ok := m.windows[key]
+	if !ok {
+		w = &window{}
+		m.windows[key] = w
+	}
+	w.length = limit.Window
+	w.prune(now)
+
+	res := Result{Limit: limit.Requests}
+	if len(w.hits) < limit.Requests {
+		w.hits = append(w.hits, now)
+		res.Allowed = true
+	}
+
+	res.Remaining = limit.Requests - len(w.hits)
+	res.Reset = limit.Window
+	if len(w.hits) > 0 {
+		res.Reset = w.hits[0].Add(limit.Window).Sub(now)
+	}
+	return res, nil
+}
+
+// sweep drops keys that have been idle for a whole window so the map does
+// not grow with every IP ever seen.
+func (m *Memory) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Minute {
+		return
+	}
+	m.lastSweep = now
+	for key, w := range m.windows {
+		if w.prune(now); len(w.hits) == 0 {
+			delete(m.windows, key)
+		}
+	}
+}
+
+// prune drops hits that have left the window. hits is sorted oldest first.
+func (w *window) prune(now time.Time) {
+	cutoff := now.Add(-w.length)
+	i := 0
+	for i < len(w.hits) && !w.hits[i].After(cutoff) {
+		i++
+	}
+	w.hits = w.hits[i:]
+}
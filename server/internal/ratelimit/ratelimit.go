@@ -0,0 +1,53 @@
+// Package ratelimit implements sliding window request limits backed by
+// Redis, with an in-memory limiter to fall back on when Redis is down.
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/koderkt/teenyurl/internal/config"
+)
+
+// Result describes the outcome of a single Allow call.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the oldest counted request leaves the window.
+	// When the request was rejected it is also how long to wait.
+	Reset time.Duration
+}
+
+// Limiter counts a request against key and reports whether it fits in limit.
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit config.Limit) (Result, error)
+}
+
+// Fallback uses Primary and switches to Secondary for any call where Primary
+// fails, so an outage of Redis degrades limits to per-instance instead of
+// turning them off or failing requests.
+type Fallback struct {
+	Primary   Limiter
+	Secondary Limiter
+	Logger    *slog.Logger
+
+	degraded atomic.Bool
+}
+
+func (f *Fallback) Allow(ctx context.Context, key string, limit config.Limit) (Result, error) {
+	res, err := f.Primary.Allow(ctx, key, limit)
+	if err == nil {
+		if f.degraded.CompareAndSwap(true, false) {
+			f.Logger.Info("rate limiter recovered, using redis again")
+		}
+		return res, nil
+	}
+
+	if f.degraded.CompareAndSwap(false, true) {
+		f.Logger.Warn("rate limiter falling back to memory", "error", err)
+	}
+	return f.Secondary.Allow(ctx, key, limit)
+}
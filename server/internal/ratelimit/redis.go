@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/koderkt/teenyurl/internal/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// slidingWindow keeps one sorted set member per request, scored by its time
+// in milliseconds. Everything runs in a single script so concurrent
+// instances cannot both take the last slot.
+var slidingWindow = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', key, now, ARGV[4])
+	redis.call('PEXPIRE', key, window)
+	count = count + 1
+	allowed = 1
+end
+
+local reset = window
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+return {allowed, limit - count, reset}
+`)
+
+type Redis struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedis(client *redis.Client) *Redis {
+	return &Redis{client: client, prefix: "ratelimit:"}
+}
+
+func (r *Redis) Allow(ctx context.Context, key string, limit config.Limit) (Result, error) {
+	now := time.Now().UnixMilli()
+	values, err := slidingWindow.Run(ctx, r.client,
+		[]string{r.prefix + key},
+		now,
+		limit.Window.Milliseconds(),
+		limit.Requests,
+		strconv.FormatInt(now, 10)+"-"+uuid.NewString(),
+	).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		Allowed:   values[0] == 1,
+		Limit:     limit.Requests,
+		Remaining: int(values[1]),
+		Reset:     time.Duration(values[2]) * time.Millisecond,
+	}, nil
+}
@@ -0,0 +1,218 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	minPrefixBytes  = 4
+	maxHostSuffixes = 4
+	maxPathPrefixes = 4
+)
+
+// Blocklist flags URLs listed in a local file, in a format modelled on
+// Safe Browsing hash lists. Each line holds a threat type and the hex
+// SHA-256 prefix, 4 to 32 bytes, of a URL expression:
+//
+//	# evil.example/, so all of evil.example
+//	phishing f001957c
+//	# example.com/shared/login.html only
+//	malware  b468849332340fe389aeb7c9760730ab
+//
+// A URL matches when any of its expressions, see Expressions, does. The
+// prefix of an expression is printed by
+//
+//	printf '%s' 'evil.example/' | sha256sum | cut -c1-8
+//
+// Blank lines and lines starting with # are ignored.
+type Blocklist struct {
+	path string
+
+	mu      sync.RWMutex
+	modTime time.Time
+	// prefixes maps each prefix length, in hex digits, to the prefixes of
+	// that length and their threats.
+	prefixes map[int]map[string]string
+}
+
+// NewBlocklist loads the blocklist at path.
+func NewBlocklist(path string) (*Blocklist, error) {
+	b := &Blocklist{path: path}
+	if err := b.Reload(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Reload reads the file again if it changed since it was last loaded. On
+// error the current list is kept.
+func (b *Blocklist) Reload() error {
+	info, err := os.Stat(b.path)
+	if err != nil {
+		return fmt.Errorf("blocklist: %w", err)
+	}
+	b.mu.RLock()
+	unchanged := b.prefixes != nil && info.ModTime().Equal(b.modTime)
+	b.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	prefixes, err := readBlocklist(b.path)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.prefixes, b.modTime = prefixes, info.ModTime()
+	b.mu.Unlock()
+	return nil
+}
+
+func readBlocklist(name string) (map[int]map[string]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("blocklist: %w", err)
+	}
+	defer f.Close()
+
+	prefixes := map[int]map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("blocklist: %s:%d: expected a threat type and a hash prefix", name, line)
+		}
+		threat, prefix := fields[0], strings.ToLower(fields[1])
+		raw, err := hex.DecodeString(prefix)
+		if err != nil || len(raw) < minPrefixBytes || len(raw) > sha256.Size {
+			return nil, fmt.Errorf("blocklist: %s:%d: hash prefix must be %d to %d bytes of hex", name, line, minPrefixBytes, sha256.Size)
+		}
+		if prefixes[len(prefix)] == nil {
+			prefixes[len(prefix)] = map[string]string{}
+		}
+		prefixes[len(prefix)][prefix] = threat
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("blocklist: reading %s: %w", name, err)
+	}
+	return prefixes, nil
+}
+
+// Scan reports whether any expression of rawURL is listed.
+func (b *Blocklist) Scan(ctx context.Context, rawURL string) (Verdict, error) {
+	expressions, err := Expressions(rawURL)
+	if err != nil {
+		return Verdict{}, err
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, expression := range expressions {
+		hash := Hash(expression)
+		for length, listed := range b.prefixes {
+			if threat, ok := listed[hash[:length]]; ok {
+				return Verdict{Threat: threat, Source: "blocklist"}, nil
+			}
+		}
+	}
+	return Verdict{}, nil
+}
+
+// Hash is the hex SHA-256 of a URL expression. Blocklist entries are
+// prefixes of it.
+func Hash(expression string) string {
+	sum := sha256.Sum256([]byte(expression))
+	return hex.EncodeToString(sum[:])
+}
+
+// Expressions returns the host and path combinations a URL is looked up
+// by, most specific first. Like Safe Browsing, hosts are the exact host
+// and up to four of its parent domains, and paths the exact path with and
+// without its query, the root and up to three leading directories.
+// For http://a.b.example.com/1/2.html?x=1 they include
+// "a.b.example.com/1/2.html?x=1", "b.example.com/1/" and "example.com/".
+func Expressions(rawURL string) ([]string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("scanner: can't parse %q", rawURL)
+	}
+
+	var expressions []string
+	paths := pathPrefixes(u)
+	for _, host := range hostSuffixes(canonicalHost(u.Hostname())) {
+		for _, p := range paths {
+			expressions = append(expressions, host+p)
+		}
+	}
+	return expressions, nil
+}
+
+func canonicalHost(host string) string {
+	host = strings.ToLower(strings.Trim(host, "."))
+	for strings.Contains(host, "..") {
+		host = strings.ReplaceAll(host, "..", ".")
+	}
+	return host
+}
+
+func hostSuffixes(host string) []string {
+	hosts := []string{host}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return hosts
+	}
+	parts := strings.Split(host, ".")
+	// the top level domain alone is never looked up
+	for i := max(1, len(parts)-maxHostSuffixes-1); i < len(parts)-1; i++ {
+		hosts = append(hosts, strings.Join(parts[i:], "."))
+	}
+	return hosts
+}
+
+func pathPrefixes(u *url.URL) []string {
+	p := u.Path
+	if p == "" {
+		p = "/"
+	}
+	trailing := strings.HasSuffix(p, "/")
+	p = path.Clean(p)
+	if trailing && p != "/" {
+		p += "/"
+	}
+
+	var paths []string
+	seen := map[string]bool{}
+	add := func(p string) {
+		if !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
+		}
+	}
+	if u.RawQuery != "" {
+		add(p + "?" + u.RawQuery)
+	}
+	add(p)
+	dirs := strings.Split(strings.Trim(p, "/"), "/")
+	prefix := "/"
+	for i := 0; i < maxPathPrefixes; i++ {
+		add(prefix)
+		if i >= len(dirs)-1 {
+			break
+		}
+		prefix += dirs[i] + "/"
+	}
+	return paths
+}
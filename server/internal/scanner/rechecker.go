@@ -0,0 +1,123 @@
+package scanner
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/koderkt/teenyurl/internal/config"
+	"github.com/koderkt/teenyurl/internal/database"
+)
+
+// Rechecker scans existing links again, so destinations listed after they
+// were shortened are caught. Flagged links are disabled. It runs once at
+// start and then every RecheckInterval until Close.
+type Rechecker struct {
+	db        database.Service
+	scanner   URLScanner
+	interval  time.Duration
+	batchSize int
+	logger    *slog.Logger
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewRechecker(db database.Service, scanner URLScanner, cfg config.Scanner, logger *slog.Logger) *Rechecker {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Rechecker{
+		db:        db,
+		scanner:   scanner,
+		interval:  cfg.RecheckInterval,
+		batchSize: cfg.BatchSize,
+		logger:    logger,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	go r.run(ctx)
+	return r
+}
+
+// Recheck scans every link not scanned within the interval and returns
+// how many were flagged.
+func (r *Rechecker) Recheck(ctx context.Context) (int, error) {
+	if reloader, ok := r.scanner.(Reloader); ok {
+		if err := reloader.Reload(); err != nil {
+			r.logger.Error("reloading scanner", "error", err)
+		}
+	}
+
+	before := time.Now().Add(-r.interval)
+	flagged := 0
+	// links are paged by id, so ones that couldn't be scanned are passed
+	// over for the rest of the pass without being marked scanned.
+	afterId := 0
+	for {
+		links, err := r.db.GetLinksToScan(ctx, before, afterId, r.batchSize)
+		if err != nil || len(links) == 0 {
+			return flagged, err
+		}
+		afterId = links[len(links)-1].Id
+		var clean []int
+		for i := range links {
+			link := &links[i]
+			verdict, err := r.scanner.Scan(ctx, link.OriginalURL)
+			if err != nil {
+				// a destination that can't be scanned shouldn't hold up
+				// the others. It is tried again on the next pass.
+				r.logger.Warn("scanning link", "short_code", link.ShortURL, "error", err)
+				continue
+			}
+			if !verdict.Flagged() {
+				clean = append(clean, link.Id)
+				continue
+			}
+			if err := r.db.FlagLink(ctx, link, verdict.Threat); err != nil {
+				return flagged, err
+			}
+			flagged++
+			r.logger.Warn("flagged link", "short_code", link.ShortURL, "user_id", link.UserId,
+				"threat", verdict.Threat, "source", verdict.Source)
+		}
+		if err := r.db.MarkLinksScanned(ctx, clean); err != nil {
+			return flagged, err
+		}
+		if len(links) < r.batchSize {
+			return flagged, nil
+		}
+	}
+}
+
+func (r *Rechecker) run(ctx context.Context) {
+	defer close(r.done)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := r.Recheck(ctx)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			r.logger.Error("rechecking links", "error", err)
+		case n > 0:
+			r.logger.Info("rechecked links", "flagged", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close stops the rechecker, interrupting a pass in progress, and waits
+// for it to exit or for ctx to be done.
+func (r *Rechecker) Close(ctx context.Context) error {
+	r.cancel()
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,30 @@
+// Package scanner checks link destinations against sources of known
+// malicious URLs.
+package scanner
+
+import "context"
+
+// Verdict is what a scanner found out about a URL. The zero value means
+// nothing was found.
+type Verdict struct {
+	// Threat names what the URL is listed for, such as "phishing".
+	Threat string
+	// Source names the scanner that listed it.
+	Source string
+}
+
+// Flagged reports whether the URL was found malicious.
+func (v Verdict) Flagged() bool {
+	return v.Threat != ""
+}
+
+// URLScanner checks URLs against a source of known malicious ones.
+type URLScanner interface {
+	Scan(ctx context.Context, rawURL string) (Verdict, error)
+}
+
+// Reloader is implemented by scanners whose lists can change while the
+// server runs. The rechecker reloads them before each pass.
+type Reloader interface {
+	Reload() error
+}
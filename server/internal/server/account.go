@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/koderkt/teenyurl/internal/auth"
+	"github.com/koderkt/teenyurl/internal/database"
+	"github.com/koderkt/teenyurl/internal/mail"
+	"github.com/koderkt/teenyurl/internal/types"
+	"github.com/koderkt/teenyurl/internal/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const mailTimeout = 30 * time.Second
+
+// sendMail delivers msg in the background so the response doesn't wait on
+// the mail server, and doesn't take longer when the account exists.
+func (s *FiberServer) sendMail(c *fiber.Ctx, msg mail.Message) {
+	ctx := context.WithoutCancel(c.UserContext())
+	logger := s.log(c)
+	s.mails.Add(1)
+	go func() {
+		defer s.mails.Done()
+		ctx, cancel := context.WithTimeout(ctx, mailTimeout)
+		defer cancel()
+		if err := s.mailer.Send(ctx, msg); err != nil {
+			logger.Error("sending mail", "subject", msg.Subject, "error", err)
+		}
+	}()
+}
+
+// waitForMail waits for background sends to finish, or for ctx.
+func (s *FiberServer) waitForMail(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.mails.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// appLink builds a link into the web client carrying token.
+func (s *FiberServer) appLink(path, token string) string {
+	return s.cfg.Mail.AppURL + path + "?" + url.Values{"token": {token}}.Encode()
+}
+
+func (s *FiberServer) sendVerification(c *fiber.Ctx, user *types.User) {
+	token := s.accountTokens.Sign(auth.PurposeVerifyEmail, user.ID, user.Email)
+	s.sendMail(c, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your teenyurl email",
+		Body: "Hi " + user.UserName + ",\n\n" +
+			"Confirm your email address to start creating links:\n\n" +
+			s.appLink("/verify-email", token) + "\n\n" +
+			"The link expires in " + s.accountTokens.TTL(auth.PurposeVerifyEmail).String() + ".\n",
+	})
+}
+
+// requireVerified reports whether the user has verified their email. When
+// they haven't, a 403 has already been written.
+func (s *FiberServer) requireVerified(c *fiber.Ctx, userId int) (bool, error) {
+	user, err := s.db.GetUserById(c.UserContext(), userId)
+	if err != nil {
+		s.log(c).Error("fetching user", "error", err)
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	if user.EmailVerifiedAt == nil {
+		return false, c.Status(fiber.StatusForbidden).JSON(fiber.Map{"message": "verify your email address first"})
+	}
+	return true, nil
+}
+
+// VerifyEmailHandler consumes the token from the verification email.
+func (s *FiberServer) VerifyEmailHandler(c *fiber.Ctx) error {
+	req := new(types.TokenRequest)
+	if err := c.BodyParser(req); err != nil || validator.New().Struct(req) != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "token is required"})
+	}
+
+	user, err := s.tokenUser(c, req.Token)
+	if user == nil {
+		return err
+	}
+	if err := s.accountTokens.Verify(auth.PurposeVerifyEmail, req.Token, user.Email); err != nil {
+		return invalidAccountToken(c)
+	}
+	if err := s.db.MarkEmailVerified(c.UserContext(), user.ID, user.Email); err != nil {
+		s.log(c).Error("verifying email", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	return c.JSON(fiber.Map{"message": "email verified"})
+}
+
+// ResendVerificationHandler sends the current user a new verification email.
+func (s *FiberServer) ResendVerificationHandler(c *fiber.Ctx) error {
+	session, err := s.accountUser(c)
+	if session == nil {
+		return err
+	}
+	user, err := s.db.GetUserById(c.UserContext(), session.Id)
+	if err != nil {
+		s.log(c).Error("fetching user", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	if user.EmailVerifiedAt != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": "email is already verified"})
+	}
+	s.sendVerification(c, user)
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "verification email sent"})
+}
+
+// ForgotPasswordHandler emails a reset link. It answers the same way
+// whether or not the email belongs to an account.
+func (s *FiberServer) ForgotPasswordHandler(c *fiber.Ctx) error {
+	req := new(types.EmailRequest)
+	if err := c.BodyParser(req); err != nil || validator.New().Struct(req) != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "a valid email is required"})
+	}
+
+	user, err := s.db.GetUserByEmail(c.UserContext(), req.Email)
+	switch {
+	case err == nil:
+		token := s.accountTokens.Sign(auth.PurposeResetPassword, user.ID, auth.PasswordStamp(user.EncryptedPassword))
+		s.sendMail(c, mail.Message{
+			To:      user.Email,
+			Subject: "Reset your teenyurl password",
+			Body: "Hi " + user.UserName + ",\n\n" +
+				"Someone asked to reset your password. If it was you, choose a new one here:\n\n" +
+				s.appLink("/reset-password", token) + "\n\n" +
+				"The link expires in " + s.accountTokens.TTL(auth.PurposeResetPassword).String() +
+				". If it wasn't you, you can ignore this email.\n",
+		})
+	case !errors.Is(err, database.ErrUserNotFound):
+		s.log(c).Error("fetching user", "error", err)
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": "if an account exists for that email, a reset link has been sent",
+	})
+}
+
+// ResetPasswordHandler sets a new password from a reset token. Changing
+// the password invalidates every other reset token for the account and
+// ends its sessions.
+func (s *FiberServer) ResetPasswordHandler(c *fiber.Ctx) error {
+	req := new(types.ResetPasswordRequest)
+	if err := c.BodyParser(req); err != nil || validator.New().Struct(req) != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "token and password are required"})
+	}
+	validate := validator.New()
+	validate.RegisterValidation("password", utils.PasswordValidator)
+	if err := validate.Var(req.Password, "required,password"); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid password"})
+	}
+
+	user, err := s.tokenUser(c, req.Token)
+	if user == nil {
+		return err
+	}
+	if err := s.accountTokens.Verify(auth.PurposeResetPassword, req.Token, auth.PasswordStamp(user.EncryptedPassword)); err != nil {
+		return invalidAccountToken(c)
+	}
+
+	encpw, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		s.log(c).Error("hashing password", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	if err := s.db.UpdatePassword(c.UserContext(), user.ID, string(encpw)); err != nil {
+		s.log(c).Error("updating password", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	// whoever knew the old password may still be signed in
+	if _, err := s.revokeSessions(c.UserContext(), user.ID, ""); err != nil {
+		s.log(c).Error("revoking sessions after password reset", "error", err)
+	}
+	// the link came from the user's inbox, so they shouldn't stay locked out
+	if err := s.lockout.Reset(c.UserContext(), auth.NormalizeEmail(user.Email)); err != nil {
+		s.log(c).Warn("resetting sign in lockout", "error", err)
+	}
+	return c.JSON(fiber.Map{"message": "password updated"})
+}
+
+// tokenUser loads the user an account token names. On failure it writes
+// the response and returns a nil user.
+func (s *FiberServer) tokenUser(c *fiber.Ctx, token string) (*types.User, error) {
+	userId, err := auth.UserId(token)
+	if err != nil {
+		return nil, invalidAccountToken(c)
+	}
+	user, err := s.db.GetUserById(c.UserContext(), userId)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return nil, invalidAccountToken(c)
+	}
+	if err != nil {
+		s.log(c).Error("fetching user", "error", err)
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	return user, nil
+}
+
+func invalidAccountToken(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": auth.ErrInvalidAccountToken.Error()})
+}
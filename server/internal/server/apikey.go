@@ -0,0 +1,149 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/koderkt/teenyurl/internal/auth"
+	"github.com/koderkt/teenyurl/internal/types"
+)
+
+const (
+	apiKeyHeader = "X-API-Key"
+	apiKeyLocal  = "api_key"
+)
+
+// apiKeyUser resolves an API key to its owner and records the use.
+func (s *FiberServer) apiKeyUser(c *fiber.Ctx, raw string) (*types.UserSession, bool) {
+	key, err := s.db.GetAPIKeyByHash(c.UserContext(), auth.HashAPIKey(raw))
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			s.log(c).Error("looking up api key", "error", err)
+		}
+		return nil, false
+	}
+	if err := s.db.TouchAPIKey(c.UserContext(), key.Id); err != nil {
+		s.log(c).Warn("recording api key use", "error", err)
+	}
+
+	user := &types.UserSession{Id: key.UserId, UserName: key.UserName, Email: key.Email}
+	c.Locals(sessionUserKey, user)
+	c.Locals(apiKeyLocal, &key.APIKey)
+	return user, true
+}
+
+// requestAPIKey returns the API key the request was authenticated with, if
+// it was authenticated with one.
+func requestAPIKey(c *fiber.Ctx) (*types.APIKey, bool) {
+	key, ok := c.Locals(apiKeyLocal).(*types.APIKey)
+	return key, ok
+}
+
+// requireScope rejects requests made with an API key that holds none of
+// the given scopes. Signed-in users hold every scope, and unauthenticated
+// requests are left for the handler to turn away.
+func (s *FiberServer) requireScope(scopes ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, ok := s.sessionUser(c); !ok {
+			return c.Next()
+		}
+		if key, ok := requestAPIKey(c); ok && !auth.HasScope(key.Scopes, scopes...) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"message": "api key is missing the required scope",
+				"scopes":  scopes,
+			})
+		}
+		return c.Next()
+	}
+}
+
+// accountUser is sessionUser for account management, which API keys are
+// never allowed to do.
+func (s *FiberServer) accountUser(c *fiber.Ctx) (*types.UserSession, error) {
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return nil, notLoggedIn(c)
+	}
+	if _, ok := requestAPIKey(c); ok {
+		return nil, c.Status(fiber.StatusForbidden).JSON(fiber.Map{"message": "api keys cannot manage the account"})
+	}
+	return user, nil
+}
+
+// CreateAPIKeyHandler creates a key for the current user. The key is only
+// ever returned in this response.
+func (s *FiberServer) CreateAPIKeyHandler(c *fiber.Ctx) error {
+	user, err := s.accountUser(c)
+	if user == nil {
+		return err
+	}
+
+	req := new(types.CreateAPIKeyRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	if err := validator.New().Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	if err := auth.ValidateScopes(req.Scopes); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+
+	raw, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		s.log(c).Error("generating api key", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	key := &types.APIKey{
+		UserId:  user.Id,
+		Name:    req.Name,
+		Prefix:  prefix,
+		KeyHash: hash,
+		Scopes:  req.Scopes,
+	}
+	if err := s.db.CreateAPIKey(c.UserContext(), key); err != nil {
+		s.log(c).Error("storing api key", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"api_key": key,
+		"key":     raw,
+	})
+}
+
+func (s *FiberServer) GetAPIKeysHandler(c *fiber.Ctx) error {
+	user, err := s.accountUser(c)
+	if user == nil {
+		return err
+	}
+	keys, err := s.db.GetAPIKeys(c.UserContext(), user.Id)
+	if err != nil {
+		s.log(c).Error("fetching api keys", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	return c.JSON(keys)
+}
+
+func (s *FiberServer) DeleteAPIKeyHandler(c *fiber.Ctx) error {
+	user, err := s.accountUser(c)
+	if user == nil {
+		return err
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid key id"})
+	}
+
+	err = s.db.DeleteAPIKey(c.UserContext(), user.Id, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "api key not found"})
+	}
+	if err != nil {
+		s.log(c).Error("revoking api key", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/koderkt/teenyurl/internal/version"
+)
+
+type dependencyStatus struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type readinessResponse struct {
+	Status       string                      `json:"status"`
+	Dependencies map[string]dependencyStatus `json:"dependencies"`
+	Build        *version.Info               `json:"build,omitempty"`
+}
+
+// livenessHandler reports that the process is up. It deliberately checks no
+// dependencies so an outage of Postgres or Redis never gets the pod killed.
+func (s *FiberServer) livenessHandler(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"status": "up"})
+}
+
+// readinessHandler checks every dependency concurrently and answers 503 if
+// any of them is down or the server is draining.
+func (s *FiberServer) readinessHandler(c *fiber.Ctx) error {
+	checks := s.readinessChecks()
+	resp := readinessResponse{
+		Status:       "up",
+		Dependencies: make(map[string]dependencyStatus, len(checks)),
+	}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) error) {
+			defer wg.Done()
+			status := s.runCheck(c.UserContext(), check)
+			mu.Lock()
+			resp.Dependencies[name] = status
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	for _, status := range resp.Dependencies {
+		if status.Status != "up" {
+			resp.Status = "down"
+		}
+	}
+	if s.draining.Load() {
+		resp.Status = "draining"
+	}
+	if s.cfg != nil && s.cfg.Health.ExposeVersion {
+		info := version.Get()
+		resp.Build = &info
+	}
+
+	if resp.Status != "up" {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(resp)
+	}
+	return c.JSON(resp)
+}
+
+func (s *FiberServer) readinessChecks() map[string]func(context.Context) error {
+	checks := map[string]func(context.Context) error{}
+	if s.db != nil {
+		checks["postgres"] = s.db.Ping
+	}
+	if s.redisClient != nil {
+		checks["redis"] = func(ctx context.Context) error {
+			return s.redisClient.Ping(ctx).Err()
+		}
+	}
+	return checks
+}
+
+// runCheck runs check within the health timeout. ctx carries the request's
+// logger, so a failing dependency logs under the request ID.
+func (s *FiberServer) runCheck(ctx context.Context, check func(context.Context) error) dependencyStatus {
+	timeout := time.Second
+	if s.cfg != nil {
+		timeout = s.cfg.Health.Timeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	status := dependencyStatus{
+		Status:    "up",
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		status.Status = "down"
+		status.Error = err.Error()
+	}
+	return status
+}
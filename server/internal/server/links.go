@@ -0,0 +1,779 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/koderkt/teenyurl/internal/database"
+	"github.com/koderkt/teenyurl/internal/links"
+	"github.com/koderkt/teenyurl/internal/types"
+	"github.com/koderkt/teenyurl/internal/utils"
+)
+
+const (
+	apiPrefix = "/api/v1"
+	// maxShortCodeAttempts bounds how often a new code is drawn when the
+	// generated one is taken.
+	maxShortCodeAttempts = 5
+
+	defaultLinkPageSize = 20
+	maxLinkPageSize     = 100
+	maxBulkLinks        = 500
+
+	maxLinkTitle = 200
+	maxLinkNotes = 2000
+)
+
+// reservedShortCodes are first path segments served by the API itself. A
+// link with one of these codes could never be reached.
+var reservedShortCodes = map[string]bool{
+	"api":          true,
+	"links":        true,
+	"analytics":    true,
+	"account":      true,
+	"auth":         true,
+	"signin":       true,
+	"signup":       true,
+	"signout":      true,
+	"token":        true,
+	"verify-email": true,
+	"password":     true,
+	"health":       true,
+	"livez":        true,
+	"readyz":       true,
+	"metrics":      true,
+}
+
+// shortLinkURL is the full public URL of a short code.
+func (s *FiberServer) shortLinkURL(c *fiber.Ctx, shortCode string) string {
+	base := c.BaseURL()
+	if s.cfg != nil && s.cfg.ShortCode.BaseURL != "" {
+		base = s.cfg.ShortCode.BaseURL
+	}
+	return strings.TrimSuffix(base, "/") + "/" + shortCode
+}
+
+// ownedLink returns the user's link for shortCode. Links that belong to
+// someone else or have been deleted are reported as not found, like
+// missing ones. On failure it writes the response and returns a nil link.
+func (s *FiberServer) ownedLink(c *fiber.Ctx, userId int, shortCode string) (*types.Link, error) {
+	link, err := s.anyOwnedLink(c, userId, shortCode)
+	if link != nil && link.DeletedAt != nil {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "link not found"})
+	}
+	return link, err
+}
+
+// anyOwnedLink is ownedLink including deleted links.
+func (s *FiberServer) anyOwnedLink(c *fiber.Ctx, userId int, shortCode string) (*types.Link, error) {
+	link, err := s.db.GetLink(c.UserContext(), shortCode)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && link.UserId != userId) {
+		return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "link not found"})
+	}
+	if err != nil {
+		s.log(c).Error("fetching link", "short_code", shortCode, "error", err)
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	return link, nil
+}
+
+// checkLongURL applies the URL policy to link's destination, then the
+// scanner when there is one. link.ScannedAt is set only when the scanner
+// gave a verdict. On failure it writes the response and returns false.
+func (s *FiberServer) checkLongURL(c *fiber.Ctx, link *types.Link) (bool, error) {
+	longURL := link.OriginalURL
+	link.ScannedAt = nil
+	if err := s.urlPolicy.Check(c.UserContext(), longURL, c.Hostname()); err != nil {
+		s.log(c).Info("destination refused", "error", err)
+		return false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	if s.urlScanner == nil {
+		return true, nil
+	}
+	verdict, err := s.urlScanner.Scan(c.UserContext(), longURL)
+	if err != nil {
+		// the rechecker gets another go at it later
+		s.log(c).Warn("scanning destination", "error", err)
+		return true, nil
+	}
+	if verdict.Flagged() {
+		s.log(c).Warn("destination flagged", "threat", verdict.Threat, "source", verdict.Source)
+		return false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "destination is listed as " + verdict.Threat})
+	}
+	now := time.Now()
+	link.ScannedAt = &now
+	return true, nil
+}
+
+// checkLinkDetails checks the length of a user's title and notes.
+func checkLinkDetails(title, notes string) error {
+	if utf8.RuneCountInString(title) > maxLinkTitle {
+		return fmt.Errorf("title can be at most %d characters", maxLinkTitle)
+	}
+	if utf8.RuneCountInString(notes) > maxLinkNotes {
+		return fmt.Errorf("notes can be at most %d characters", maxLinkNotes)
+	}
+	return nil
+}
+
+// checkRedirectStatus checks a link's redirect status, where 0 means the
+// server default.
+func checkRedirectStatus(status int) error {
+	switch status {
+	case 0, fiber.StatusMovedPermanently, fiber.StatusFound, fiber.StatusTemporaryRedirect, fiber.StatusPermanentRedirect:
+		return nil
+	}
+	return errors.New("redirect_status must be 301, 302, 307 or 308")
+}
+
+// redirectStatus is the status visitors of link are redirected with.
+func (s *FiberServer) redirectStatus(link *types.Link) int {
+	if link.RedirectStatus != 0 {
+		return link.RedirectStatus
+	}
+	return s.cfg.Links.RedirectStatus
+}
+
+// fetchMetadata queues a fetch of the destination's metadata for links
+// without a title of their own.
+func (s *FiberServer) fetchMetadata(c *fiber.Ctx, link *types.Link) {
+	if s.linkMetadata == nil || link.Title != "" {
+		return
+	}
+	if err := s.linkMetadata.Enqueue(c.UserContext(), link.Id, link.OriginalURL); err != nil {
+		s.log(c).Warn("queueing metadata fetch", "short_code", link.ShortURL, "error", err)
+	}
+}
+
+// createLink stores link under a fresh short code and returns it as
+// saved. then, if not nil, runs in the same transaction with the saved
+// link. On failure it writes the response and returns a nil link.
+func (s *FiberServer) createLink(c *fiber.Ctx, link *types.Link, then func(database.Service, *types.Link) error) (*types.Link, error) {
+	if ok, err := s.checkLongURL(c, link); !ok {
+		return nil, err
+	}
+
+	ctx := c.UserContext()
+	var created *types.Link
+	err := s.db.WithTx(ctx, func(db database.Service) error {
+		var err error
+		if created, err = s.insertLink(ctx, db, link); err != nil {
+			return err
+		}
+		if then != nil {
+			return then(db, created)
+		}
+		return nil
+	})
+	if err != nil {
+		s.log(c).Error("creating short url", "error", err)
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "failed to create short url"})
+	}
+	return created, nil
+}
+
+// insertLink draws short codes until one is free and stores link under it.
+func (s *FiberServer) insertLink(ctx context.Context, db database.Service, link *types.Link) (*types.Link, error) {
+	for attempt := 0; attempt < maxShortCodeAttempts; attempt++ {
+		shortCode := utils.GenerateShortCode(s.cfg.ShortCode.Length)
+		if reservedShortCodes[strings.ToLower(shortCode)] {
+			continue
+		}
+		_, err := db.GetLink(ctx, shortCode)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("checking short code: %w", err)
+		}
+
+		link.ShortURL = shortCode
+		if err := db.CreateShortURL(ctx, link); err != nil {
+			return nil, err
+		}
+		link, err = db.GetLink(ctx, shortCode)
+		if err != nil {
+			return nil, fmt.Errorf("fetching new short url %s: %w", shortCode, err)
+		}
+		return link, nil
+	}
+	return nil, fmt.Errorf("no free short code after %d attempts", maxShortCodeAttempts)
+}
+
+// linkResponse is the API representation of link.
+func (s *FiberServer) linkResponse(c *fiber.Ctx, link *types.Link) (types.LinkResponse, error) {
+	clicks, err := s.db.GetNumberOfClicks(c.UserContext(), link.ShortURL)
+	if err != nil {
+		return types.LinkResponse{}, err
+	}
+	tags, err := s.db.GetLinkTags(c.UserContext(), link.Id)
+	if err != nil {
+		return types.LinkResponse{}, err
+	}
+	return s.newLinkResponse(c, link, clicks, tags), nil
+}
+
+func (s *FiberServer) newLinkResponse(c *fiber.Ctx, link *types.Link, clicks int, tags []string) types.LinkResponse {
+	if tags == nil {
+		tags = []string{}
+	}
+	resp := types.LinkResponse{
+		Id:             link.Id,
+		ShortCode:      link.ShortURL,
+		OriginalURL:    link.OriginalURL,
+		ShortURL:       s.shortLinkURL(c, link.ShortURL),
+		CreatedAt:      link.CreatedAt,
+		IsEnabled:      link.IsEnabled,
+		Clicks:         clicks,
+		Title:          link.Title,
+		Notes:          link.Notes,
+		Tags:           tags,
+		FolderId:       link.FolderId,
+		DeletedAt:      link.DeletedAt,
+		FlaggedAt:      link.FlaggedAt,
+		FlagReason:     link.FlagReason,
+		Preview:        link.Preview,
+		RedirectStatus: s.redirectStatus(link),
+	}
+	if resp.Title == "" {
+		resp.Title = link.LinkMetadata.Title
+	}
+	if link.LinkMetadata.FetchedAt != nil {
+		meta := link.LinkMetadata
+		resp.Metadata = &meta
+	}
+	if link.DeletedAt != nil {
+		until := link.DeletedAt.Add(s.cfg.Links.RestoreWindow)
+		resp.RestorableUntil = &until
+	}
+	return resp
+}
+
+func (s *FiberServer) sendLink(c *fiber.Ctx, status int, link *types.Link) error {
+	resp, err := s.linkResponse(c, link)
+	if err != nil {
+		s.log(c).Error("fetching link details", "short_code", link.ShortURL, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	return c.Status(status).JSON(resp)
+}
+
+// CreateLinkHandler shortens a URL, optionally with tags and a folder, and
+// answers 201 with the new link and its location.
+func (s *FiberServer) CreateLinkHandler(c *fiber.Ctx) error {
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return notLoggedIn(c)
+	}
+	if ok, err := s.requireVerified(c, user.Id); !ok {
+		return err
+	}
+	req := new(types.ShortenRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "bad request"})
+	}
+	tags, err := cleanTagNames(req.Tags)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	req.Title = strings.TrimSpace(req.Title)
+	if err := checkLinkDetails(req.Title, req.Notes); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	if err := checkRedirectStatus(req.RedirectStatus); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	link := &types.Link{
+		OriginalURL:    req.LongUrl,
+		UserId:         user.Id,
+		Title:          req.Title,
+		Notes:          req.Notes,
+		Preview:        req.Preview,
+		RedirectStatus: req.RedirectStatus,
+	}
+	if req.FolderId != nil {
+		if ok, err := s.checkFolder(c, user.Id, *req.FolderId); !ok {
+			return err
+		}
+		link.FolderId = folderColumn(*req.FolderId)
+	}
+
+	link, err = s.createLink(c, link, func(db database.Service, link *types.Link) error {
+		if len(tags) == 0 {
+			return nil
+		}
+		if err := db.SetLinkTags(c.UserContext(), user.Id, link.Id, tags); err != nil {
+			return fmt.Errorf("tagging new link: %w", err)
+		}
+		return nil
+	})
+	if link == nil {
+		return err
+	}
+	s.fetchMetadata(c, link)
+	c.Location(apiPrefix + "/links/" + link.ShortURL)
+	return s.sendLink(c, fiber.StatusCreated, link)
+}
+
+// ListLinksHandler returns one page of the user's links. See
+// parseLinkQuery for the query parameters. The response carries a
+// next_cursor while there are more links to fetch.
+func (s *FiberServer) ListLinksHandler(c *fiber.Ctx) error {
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return notLoggedIn(c)
+	}
+	q, err := s.parseLinkQuery(c, user.Id)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+
+	// one extra link tells whether there is a next page
+	limit := q.Limit
+	q.Limit++
+	found, err := s.db.ListLinks(c.UserContext(), q)
+	if err != nil {
+		s.log(c).Error("listing links", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+
+	page := types.LinkPage{Links: []types.LinkResponse{}}
+	if len(found) > limit {
+		found = found[:limit]
+		page.NextCursor = links.EncodeCursor(links.CursorAfter(q, &found[limit-1]))
+	}
+	for i := range found {
+		page.Links = append(page.Links, s.newLinkResponse(c, &found[i].Link, found[i].Clicks, found[i].Tags))
+	}
+	return c.JSON(page)
+}
+
+// parseLinkQuery reads the listing options from the query string:
+//
+//	sort           created (default), clicks or destination
+//	order          asc or desc; newest, most clicked and A to Z come first
+//	limit          page size, up to maxLinkPageSize
+//	cursor         next_cursor of the previous page
+//	enabled        true or false
+//	created_from   RFC 3339 time or date, inclusive
+//	created_to     RFC 3339 time, exclusive, or date, inclusive
+//	tag            tag name
+//	folder_id      folder id, 0 for links outside any folder
+//	domain         destination host, subdomains included
+//	q              text searched for in the destination and the short code
+//	deleted=true   deleted links that can still be restored
+func (s *FiberServer) parseLinkQuery(c *fiber.Ctx, userId int) (*types.LinkQuery, error) {
+	q := &types.LinkQuery{
+		UserId: userId,
+		Sort:   c.Query("sort", types.LinkSortCreated),
+		Limit:  c.QueryInt("limit", defaultLinkPageSize),
+		Tag:    strings.TrimSpace(c.Query("tag")),
+		Domain: strings.ToLower(strings.TrimSpace(c.Query("domain"))),
+		Search: strings.TrimSpace(c.Query("q")),
+	}
+	switch q.Sort {
+	case types.LinkSortCreated, types.LinkSortClicks:
+		q.Desc = true
+	case types.LinkSortDestination:
+	default:
+		return nil, errors.New("sort must be created, clicks or destination")
+	}
+	switch c.Query("order") {
+	case "":
+	case "asc":
+		q.Desc = false
+	case "desc":
+		q.Desc = true
+	default:
+		return nil, errors.New("order must be asc or desc")
+	}
+	if q.Limit < 1 || q.Limit > maxLinkPageSize {
+		return nil, fmt.Errorf("limit must be between 1 and %d", maxLinkPageSize)
+	}
+
+	if raw := c.Query("cursor"); raw != "" {
+		cursor, err := links.DecodeCursor(raw)
+		if err != nil {
+			return nil, err
+		}
+		if cursor.Sort != q.Sort || cursor.Desc != q.Desc {
+			return nil, errors.New("cursor belongs to a different sort order")
+		}
+		q.After = cursor
+	}
+	if raw := c.Query("enabled"); raw != "" {
+		enabled, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, errors.New("enabled must be true or false")
+		}
+		q.Enabled = &enabled
+	}
+	if raw := c.Query("folder_id"); raw != "" {
+		folderId, err := strconv.Atoi(raw)
+		if err != nil || folderId < 0 {
+			return nil, errors.New("folder_id must be a folder id or 0")
+		}
+		q.FolderId = &folderId
+	}
+	var err error
+	if q.CreatedFrom, err = parseTimeParam(c.Query("created_from"), false); err != nil {
+		return nil, errors.New("created_from must be a date or an RFC 3339 time")
+	}
+	if q.CreatedTo, err = parseTimeParam(c.Query("created_to"), true); err != nil {
+		return nil, errors.New("created_to must be a date or an RFC 3339 time")
+	}
+	if c.QueryBool("deleted") {
+		since := time.Now().Add(-s.cfg.Links.RestoreWindow)
+		q.DeletedSince = &since
+	}
+	return q, nil
+}
+
+// parseTimeParam parses an RFC 3339 time or a date. With endOfDay a date
+// means the start of the following day, so that it is included in a
+// range that ends before it.
+func parseTimeParam(raw string, endOfDay bool) (*time.Time, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse(time.DateOnly, raw)
+	if err != nil {
+		return nil, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+func (s *FiberServer) GetLinkHandler(c *fiber.Ctx) error {
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return notLoggedIn(c)
+	}
+	link, err := s.ownedLink(c, user.Id, c.Params("shortCode"))
+	if link == nil {
+		return err
+	}
+	return s.sendLink(c, fiber.StatusOK, link)
+}
+
+// UpdateLinkHandler changes the fields present in the body and returns the
+// updated link.
+func (s *FiberServer) UpdateLinkHandler(c *fiber.Ctx) error {
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return notLoggedIn(c)
+	}
+	req := new(types.UpdateLinkRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "bad request"})
+	}
+	var tags []string
+	if req.Tags != nil {
+		var err error
+		if tags, err = cleanTagNames(*req.Tags); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+		}
+	}
+	link, err := s.ownedLink(c, user.Id, c.Params("shortCode"))
+	if link == nil {
+		return err
+	}
+	newURL := req.OriginalURL != nil && *req.OriginalURL != link.OriginalURL
+	details := *link
+	if newURL {
+		details.OriginalURL = *req.OriginalURL
+		if ok, err := s.checkLongURL(c, &details); !ok {
+			return err
+		}
+	}
+	if req.Title != nil {
+		details.Title = strings.TrimSpace(*req.Title)
+	}
+	if req.Notes != nil {
+		details.Notes = *req.Notes
+	}
+	if req.Preview != nil {
+		details.Preview = *req.Preview
+	}
+	if req.RedirectStatus != nil {
+		details.RedirectStatus = *req.RedirectStatus
+	}
+	if err := checkLinkDetails(details.Title, details.Notes); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	if err := checkRedirectStatus(details.RedirectStatus); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	if req.FolderId != nil {
+		if ok, err := s.checkFolder(c, user.Id, *req.FolderId); !ok {
+			return err
+		}
+	}
+
+	ctx := c.UserContext()
+	detailsChanged := details.Title != link.Title || details.Notes != link.Notes ||
+		details.Preview != link.Preview || details.RedirectStatus != link.RedirectStatus
+	if req.FolderId != nil {
+		details.FolderId = folderColumn(*req.FolderId)
+	}
+	// every change is made or none is
+	err = s.db.WithTx(ctx, func(db database.Service) error {
+		if detailsChanged {
+			if err := db.UpdateLinkDetails(ctx, &details); err != nil {
+				return fmt.Errorf("updating link details: %w", err)
+			}
+		}
+		if newURL {
+			if err := db.EditLink(ctx, &details); err != nil {
+				return fmt.Errorf("editing link: %w", err)
+			}
+		}
+		if req.Tags != nil {
+			if err := db.SetLinkTags(ctx, user.Id, link.Id, tags); err != nil {
+				return fmt.Errorf("tagging link: %w", err)
+			}
+		}
+		if req.FolderId != nil {
+			if err := db.SetLinksFolder(ctx, []int{link.Id}, details.FolderId); err != nil {
+				return fmt.Errorf("moving link: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.log(c).Error("updating link", "short_code", link.ShortURL, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+
+	// a new destination has its metadata fetched. Links still without
+	// metadata, after a failed fetch or a removed title, get another try.
+	if newURL {
+		details.LinkMetadata = types.LinkMetadata{}
+	}
+	link = &details
+	if link.LinkMetadata.FetchedAt == nil {
+		s.fetchMetadata(c, link)
+	}
+	return s.sendLink(c, fiber.StatusOK, link)
+}
+
+// editLongURL points link at longURL once the URL policy allows it, and
+// fetches the new destination's metadata. On failure it writes the
+// response and returns false.
+func (s *FiberServer) editLongURL(c *fiber.Ctx, link *types.Link, longURL string) (bool, error) {
+	if longURL == link.OriginalURL {
+		return true, nil
+	}
+	link.OriginalURL = longURL
+	if ok, err := s.checkLongURL(c, link); !ok {
+		return false, err
+	}
+	if err := s.db.EditLink(c.UserContext(), link); err != nil {
+		s.log(c).Error("editing link", "short_code", link.ShortURL, "error", err)
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	link.LinkMetadata = types.LinkMetadata{}
+	s.fetchMetadata(c, link)
+	return true, nil
+}
+
+// SetLinkStatusHandler enables or disables a link.
+func (s *FiberServer) SetLinkStatusHandler(c *fiber.Ctx) error {
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return notLoggedIn(c)
+	}
+	req := new(types.LinkStatusRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "bad request"})
+	}
+	if err := validator.New().Struct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "enabled is required"})
+	}
+	link, err := s.ownedLink(c, user.Id, c.Params("shortCode"))
+	if link == nil {
+		return err
+	}
+
+	if ok, err := s.setLinkEnabled(c, link, *req.Enabled); !ok {
+		return err
+	}
+	return s.sendLink(c, fiber.StatusOK, link)
+}
+
+// setLinkEnabled stores the status of link. On failure it writes the
+// response and returns false.
+func (s *FiberServer) setLinkEnabled(c *fiber.Ctx, link *types.Link, enabled bool) (bool, error) {
+	if enabled && link.FlaggedAt != nil {
+		return false, c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": "link is flagged as " + link.FlagReason + ", change its destination first"})
+	}
+	link.IsEnabled = enabled
+	if err := s.db.EnableDisableLink(c.UserContext(), link); err != nil {
+		s.log(c).Error("updating link status", "short_code", link.ShortURL, "error", err)
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	return true, nil
+}
+
+// DeleteLinkHandler deletes a link. It stops redirecting at once and can
+// be restored within the restore window. Its code is not handed out again
+// until the purge job removes it.
+func (s *FiberServer) DeleteLinkHandler(c *fiber.Ctx) error {
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return notLoggedIn(c)
+	}
+	link, err := s.ownedLink(c, user.Id, c.Params("shortCode"))
+	if link == nil {
+		return err
+	}
+
+	err = s.db.DeleteLink(c.UserContext(), link)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		s.log(c).Error("deleting link", "short_code", link.ShortURL, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// BulkUpdateLinksHandler adds and removes tags on, or moves, up to
+// maxBulkLinks links at once. If any code is not one of the user's links
+// nothing is changed and the codes are listed in a 404.
+func (s *FiberServer) BulkUpdateLinksHandler(c *fiber.Ctx) error {
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return notLoggedIn(c)
+	}
+	req := new(types.BulkLinkRequest)
+	if err := c.BodyParser(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "bad request"})
+	}
+	if len(req.Codes) == 0 || len(req.Codes) > maxBulkLinks {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": fmt.Sprintf("codes must list 1 to %d links", maxBulkLinks)})
+	}
+	add, err := cleanTagNames(req.AddTags)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	remove, err := cleanTagNames(req.RemoveTags)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	if req.FolderId != nil {
+		if ok, err := s.checkFolder(c, user.Id, *req.FolderId); !ok {
+			return err
+		}
+	}
+
+	ctx := c.UserContext()
+	ids, err := s.db.GetLinkIds(ctx, user.Id, req.Codes)
+	if err != nil {
+		s.log(c).Error("resolving links", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	linkIds := make([]int, 0, len(ids))
+	missing := []string{}
+	for _, code := range req.Codes {
+		if id, ok := ids[code]; ok {
+			linkIds = append(linkIds, id)
+		} else {
+			missing = append(missing, code)
+		}
+	}
+	if len(missing) > 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "links not found", "codes": missing})
+	}
+
+	// every change is made or none is
+	err = s.db.WithTx(ctx, func(db database.Service) error {
+		if len(add) > 0 || len(remove) > 0 {
+			if err := db.TagLinks(ctx, user.Id, linkIds, add, remove); err != nil {
+				return fmt.Errorf("tagging links: %w", err)
+			}
+		}
+		if req.FolderId != nil {
+			if err := db.SetLinksFolder(ctx, linkIds, folderColumn(*req.FolderId)); err != nil {
+				return fmt.Errorf("moving links: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		s.log(c).Error("updating links", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	return c.JSON(fiber.Map{"updated": len(ids)})
+}
+
+// RestoreLinkHandler undeletes a link deleted within the restore window.
+func (s *FiberServer) RestoreLinkHandler(c *fiber.Ctx) error {
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return notLoggedIn(c)
+	}
+	link, err := s.anyOwnedLink(c, user.Id, c.Params("shortCode"))
+	if link == nil {
+		return err
+	}
+	if link.DeletedAt == nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": "link is not deleted"})
+	}
+
+	since := time.Now().Add(-s.cfg.Links.RestoreWindow)
+	err = s.db.RestoreLink(c.UserContext(), link, since)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.Status(fiber.StatusGone).JSON(fiber.Map{"message": "link can no longer be restored"})
+	}
+	if err != nil {
+		s.log(c).Error("restoring link", "short_code", link.ShortURL, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	link.DeletedAt = nil
+	return s.sendLink(c, fiber.StatusOK, link)
+}
+
+func (s *FiberServer) LinkAnalyticsHandler(c *fiber.Ctx) error {
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return notLoggedIn(c)
+	}
+	link, err := s.ownedLink(c, user.Id, c.Params("shortCode"))
+	if link == nil {
+		return err
+	}
+
+	clicks, err := s.db.GetAnalystics(c.UserContext(), link.ShortURL)
+	if err != nil {
+		s.log(c).Error("fetching analytics", "short_code", link.ShortURL, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	return c.JSON(clicks)
+}
+
+// deprecated marks a route kept only for old clients. Responses carry a
+// Deprecation header and a Link to successor, where ":shortCode" is filled
+// in from the request, and every use is logged so the remaining callers
+// can be found before the route is removed.
+func (s *FiberServer) deprecated(successor string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		next := strings.Replace(successor, ":shortCode", url.PathEscape(c.Params("shortCode")), 1)
+		c.Set("Deprecation", "true")
+		c.Set(fiber.HeaderLink, fmt.Sprintf(`<%s>; rel="successor-version"`, next))
+		s.log(c).Info("deprecated route used", "method", c.Method(), "route", c.Route().Path, "successor", successor)
+		return c.Next()
+	}
+}
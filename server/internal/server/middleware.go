@@ -0,0 +1,97 @@
+package server
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/koderkt/teenyurl/internal/logging"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	requestIDKey    = "request_id"
+)
+
+// requestIDMiddleware reuses the caller's X-Request-ID or generates one and
+// echoes it back in the response. IDs that are too long or contain anything
+// but printable ASCII are replaced so they cannot pollute the logs.
+func requestIDMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		c.Set(requestIDHeader, id)
+		c.Locals(requestIDKey, id)
+		return c.Next()
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the ID assigned by requestIDMiddleware.
+func requestID(c *fiber.Ctx) string {
+	id, _ := c.Locals(requestIDKey).(string)
+	return id
+}
+
+// requestLogger stores a logger carrying the request ID in the user context
+// and writes one access log line per request.
+func (s *FiberServer) requestLogger() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		logger := s.baseLogger().With("request_id", requestID(c))
+		if sc := trace.SpanContextFromContext(c.UserContext()); sc.IsValid() {
+			logger = logger.With("trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String())
+		}
+		c.SetUserContext(logging.WithContext(c.UserContext(), logger))
+
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		if fe, ok := err.(*fiber.Error); ok {
+			status = fe.Code
+		} else if err != nil {
+			status = fiber.StatusInternalServerError
+		}
+		level := slog.LevelInfo
+		if status >= fiber.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logger.LogAttrs(c.UserContext(), level, "request",
+			slog.String("method", c.Method()),
+			slog.String("path", c.Path()),
+			slog.String("route", c.Route().Path),
+			slog.Int("status", status),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("ip", c.IP()),
+			slog.String("user_agent", c.Get(fiber.HeaderUserAgent)),
+		)
+		return err
+	}
+}
+
+// log returns the request scoped logger.
+func (s *FiberServer) log(c *fiber.Ctx) *slog.Logger {
+	return logging.FromContext(c.UserContext())
+}
+
+func (s *FiberServer) baseLogger() *slog.Logger {
+	if s.logger != nil {
+		return s.logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,291 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/koderkt/teenyurl/internal/auth"
+	"github.com/koderkt/teenyurl/internal/database"
+	"github.com/koderkt/teenyurl/internal/metrics"
+	"github.com/koderkt/teenyurl/internal/oidc"
+	"github.com/koderkt/teenyurl/internal/types"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcStateTTL    = 10 * time.Minute
+	// oidcLoginTTL is how long the client has to exchange the one-time
+	// code it was redirected with for a session.
+	oidcLoginTTL = time.Minute
+)
+
+var (
+	errOIDCEmailUnverified = errors.New("the provider has not verified this email address")
+	errOIDCAccountExists   = errors.New("an unverified account already uses this email, sign in with its password and verify it first")
+)
+
+// oidcState is kept in Redis between the redirect to the provider and the
+// callback.
+type oidcState struct {
+	Provider string `json:"provider"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+type oidcExchangeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// OIDCProvidersHandler lists the providers the client can offer.
+func (s *FiberServer) OIDCProvidersHandler(c *fiber.Ctx) error {
+	providers := []fiber.Map{}
+	if s.cfg == nil {
+		return c.JSON(providers)
+	}
+	for _, cfg := range s.cfg.OIDC.Providers {
+		p := s.oidcProviders[cfg.Name]
+		providers = append(providers, fiber.Map{
+			"name":         p.Name(),
+			"display_name": p.DisplayName(),
+			"login_url":    "/auth/oidc/" + p.Name() + "/login",
+		})
+	}
+	return c.JSON(providers)
+}
+
+// OIDCLoginHandler redirects the browser to the provider. The state is
+// also set in a cookie so the callback only completes in the browser that
+// started the login.
+func (s *FiberServer) OIDCLoginHandler(c *fiber.Ctx) error {
+	provider, ok := s.oidcProviders[c.Params("provider")]
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "unknown provider"})
+	}
+
+	state, err := oidc.RandomString()
+	if err != nil {
+		return s.oidcFailed(c, "generating state", err)
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		return s.oidcFailed(c, "generating nonce", err)
+	}
+	pending := oidcState{Provider: provider.Name(), Nonce: nonce, Verifier: oidc.NewVerifier()}
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return s.oidcFailed(c, "encoding state", err)
+	}
+	if err := s.redisClient.Set(c.UserContext(), "oidc_state:"+state, data, oidcStateTTL).Err(); err != nil {
+		return s.oidcFailed(c, "storing state", err)
+	}
+
+	authURL, err := provider.AuthCodeURL(c.UserContext(), state, nonce, pending.Verifier)
+	if err != nil {
+		return s.oidcFailed(c, "building authorization url", err)
+	}
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/auth/oidc",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		Secure:   c.Protocol() == "https",
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+	return c.Redirect(authURL, fiber.StatusFound)
+}
+
+// OIDCCallbackHandler finishes the login started by OIDCLoginHandler and
+// sends the browser back to the client with a one-time code for
+// POST /auth/oidc/exchange. Errors are reported to the client the same way.
+func (s *FiberServer) OIDCCallbackHandler(c *fiber.Ctx) error {
+	provider, ok := s.oidcProviders[c.Params("provider")]
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "unknown provider"})
+	}
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/auth/oidc",
+		MaxAge:   -1,
+		HTTPOnly: true,
+	})
+
+	if reason := c.Query("error"); reason != "" {
+		s.log(c).Warn("oidc provider returned an error", "provider", provider.Name(), "error", reason)
+		return s.oidcRedirect(c, url.Values{"error": {reason}})
+	}
+	state := c.Query("state")
+	if state == "" || state != c.Cookies(oidcStateCookie) {
+		return s.oidcRedirect(c, url.Values{"error": {"invalid_state"}})
+	}
+
+	data, err := s.redisClient.GetDel(c.UserContext(), "oidc_state:"+state).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return s.oidcRedirect(c, url.Values{"error": {"invalid_state"}})
+	}
+	if err != nil {
+		s.log(c).Error("loading oidc state", "error", err)
+		return s.oidcRedirect(c, url.Values{"error": {"server_error"}})
+	}
+	var pending oidcState
+	if err := json.Unmarshal(data, &pending); err != nil || pending.Provider != provider.Name() {
+		return s.oidcRedirect(c, url.Values{"error": {"invalid_state"}})
+	}
+
+	identity, err := provider.Exchange(c.UserContext(), c.Query("code"), pending.Verifier, pending.Nonce)
+	if err != nil {
+		s.log(c).Warn("oidc exchange failed", "provider", provider.Name(), "error", err)
+		s.metrics.SignIn(metrics.SignInFailure)
+		return s.oidcRedirect(c, url.Values{"error": {"access_denied"}})
+	}
+
+	user, err := s.oidcUser(c.UserContext(), provider.Name(), identity)
+	if errors.Is(err, errOIDCEmailUnverified) || errors.Is(err, errOIDCAccountExists) {
+		s.metrics.SignIn(metrics.SignInFailure)
+		return s.oidcRedirect(c, url.Values{"error": {"account_not_linked"}, "error_description": {err.Error()}})
+	}
+	if err != nil {
+		s.log(c).Error("resolving oidc user", "provider", provider.Name(), "error", err)
+		return s.oidcRedirect(c, url.Values{"error": {"server_error"}})
+	}
+
+	session, err := json.Marshal(types.UserSession{Id: user.ID, UserName: user.UserName, Email: user.Email})
+	if err != nil {
+		s.log(c).Error("encoding session", "error", err)
+		return s.oidcRedirect(c, url.Values{"error": {"server_error"}})
+	}
+	code, err := oidc.RandomString()
+	if err == nil {
+		err = s.redisClient.Set(c.UserContext(), "oidc_login:"+code, session, oidcLoginTTL).Err()
+	}
+	if err != nil {
+		s.log(c).Error("storing oidc login", "error", err)
+		return s.oidcRedirect(c, url.Values{"error": {"server_error"}})
+	}
+
+	return s.oidcRedirect(c, url.Values{"code": {code}})
+}
+
+// OIDCExchangeHandler trades the one-time code from the callback redirect
+// for a session, exactly as /signin would return one. Users with 2FA, or
+// who must enroll in it, get the second step's challenge instead.
+func (s *FiberServer) OIDCExchangeHandler(c *fiber.Ctx) error {
+	req := new(oidcExchangeRequest)
+	if err := c.BodyParser(req); err != nil || req.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "code is required"})
+	}
+
+	data, err := s.redisClient.GetDel(c.UserContext(), "oidc_login:"+req.Code).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "invalid or expired code"})
+	}
+	if err != nil {
+		s.log(c).Error("loading oidc login", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "internal server error"})
+	}
+	var userSession types.UserSession
+	if err := json.Unmarshal(data, &userSession); err != nil {
+		s.log(c).Error("decoding oidc login", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "internal server error"})
+	}
+
+	step, err := s.secondFactor(c.UserContext(), userSession.Id)
+	if err != nil {
+		s.log(c).Error("oidc sign in: two factor lookup failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "internal server error"})
+	}
+	if step != "" {
+		return s.startChallenge(c, step, userSession)
+	}
+	attempt := &types.LoginAttempt{
+		UserId:    &userSession.Id,
+		Email:     auth.NormalizeEmail(userSession.Email),
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	}
+	return s.completeSignIn(c, attempt, userSession, nil)
+}
+
+// oidcUser finds or creates the user for identity. An identity seen before
+// maps to the same user. Otherwise it is linked by email, which the
+// provider must have verified. An existing account is only linked when its
+// own email is verified too, so nobody can pre-register someone else's
+// address and inherit their login.
+func (s *FiberServer) oidcUser(ctx context.Context, provider string, identity *oidc.Identity) (*types.User, error) {
+	user, err := s.db.GetUserByIdentity(ctx, provider, identity.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, database.ErrUserNotFound) {
+		return nil, err
+	}
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, errOIDCEmailUnverified
+	}
+
+	// a new account is created, verified and linked together, or not at
+	// all, so a failed sign in can be tried again
+	err = s.db.WithTx(ctx, func(db database.Service) error {
+		user, err = db.GetUserByEmail(ctx, identity.Email)
+		switch {
+		case err == nil:
+			if user.EmailVerifiedAt == nil {
+				return errOIDCAccountExists
+			}
+		case errors.Is(err, database.ErrUserNotFound):
+			// no password is set; one can be added with a password reset
+			user = &types.User{
+				UserName:  oidcUserName(identity),
+				Email:     identity.Email,
+				CreatedAt: time.Now(),
+			}
+			if err := db.CreateUser(ctx, user); err != nil {
+				return fmt.Errorf("creating user: %w", err)
+			}
+			if err := db.MarkEmailVerified(ctx, user.ID, user.Email); err != nil {
+				return fmt.Errorf("verifying email: %w", err)
+			}
+		default:
+			return err
+		}
+		return db.LinkIdentity(ctx, &types.UserIdentity{
+			Provider: provider,
+			Subject:  identity.Subject,
+			UserId:   user.ID,
+			Email:    identity.Email,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func oidcUserName(identity *oidc.Identity) string {
+	switch {
+	case identity.PreferredUsername != "":
+		return identity.PreferredUsername
+	case identity.Name != "":
+		return identity.Name
+	default:
+		name, _, _ := strings.Cut(identity.Email, "@")
+		return name
+	}
+}
+
+// oidcRedirect sends the browser back to the client's callback page.
+func (s *FiberServer) oidcRedirect(c *fiber.Ctx, params url.Values) error {
+	return c.Redirect(s.cfg.Mail.AppURL+"/oidc/callback?"+params.Encode(), fiber.StatusFound)
+}
+
+func (s *FiberServer) oidcFailed(c *fiber.Ctx, msg string, err error) error {
+	s.log(c).Error(msg, "error", err)
+	return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"message": "could not start sign in with the provider"})
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	"net/url"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/koderkt/teenyurl/internal/types"
+)
+
+//go:embed templates/*.html
+var pageFiles embed.FS
+
+var pages = template.Must(template.ParseFS(pageFiles, "templates/*.html"))
+
+// Safety statuses shown on preview pages.
+const (
+	previewOK        = "ok"
+	previewUntrusted = "untrusted"
+	previewBlocked   = "blocked"
+)
+
+// previewPage shows where a link leads instead of redirecting. Blocked
+// links show no way through.
+type previewPage struct {
+	ShortURL    string
+	Destination string
+	Host        string
+	Title       string
+	Description string
+	Status      string
+	Threat      string
+	// ScannedAt is when a scanner last checked the destination.
+	ScannedAt *time.Time
+}
+
+// sendPage renders one of the HTML pages. They are never cached, since
+// the link they describe can change, and load nothing from elsewhere.
+func sendPage(c *fiber.Ctx, status int, name string, data any) error {
+	var buf bytes.Buffer
+	if err := pages.ExecuteTemplate(&buf, name, data); err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(fiber.HeaderContentSecurityPolicy, "default-src 'none'; style-src 'unsafe-inline'")
+	c.Set(fiber.HeaderReferrerPolicy, "no-referrer")
+	return c.Status(status).Send(buf.Bytes())
+}
+
+// sendPreview renders the preview page of link.
+func (s *FiberServer) sendPreview(c *fiber.Ctx, status int, link *types.Link) error {
+	page := previewPage{
+		ShortURL:    s.shortLinkURL(c, link.ShortURL),
+		Destination: link.OriginalURL,
+		Title:       link.Title,
+		Description: link.LinkMetadata.Description,
+		Status:      previewOK,
+	}
+	if u, err := url.Parse(link.OriginalURL); err == nil {
+		page.Host = u.Hostname()
+	}
+	if page.Title == "" {
+		page.Title = link.LinkMetadata.Title
+	}
+	switch {
+	case link.FlaggedAt != nil:
+		page.Status, page.Threat = previewBlocked, link.FlagReason
+	case !s.urlPolicy.Trusted(link.OriginalURL):
+		page.Status = previewUntrusted
+	}
+	if s.urlScanner != nil {
+		page.ScannedAt = link.ScannedAt
+	}
+	return sendPage(c, status, "preview.html", page)
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/koderkt/teenyurl/internal/qr"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	defaultQRSize   = 256
+	minQRSize       = 64
+	defaultQRMargin = 4
+	maxQRMargin     = 16
+)
+
+// QRCodeHandler returns a QR code for one of the user's links as PNG or
+// SVG. Query parameters: format (png, svg), size in pixels, level (L, M,
+// Q, H), margin in modules, fg and bg as hex colors, and logo=true to draw
+// the configured logo in the center. Images are cached in Redis by their
+// content and options, and clients get an ETag to revalidate with.
+func (s *FiberServer) QRCodeHandler(c *fiber.Ctx) error {
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return notLoggedIn(c)
+	}
+	link, err := s.ownedLink(c, user.Id, c.Params("shortCode"))
+	if link == nil {
+		return err
+	}
+
+	format := c.Query("format", "png")
+	if format != "png" && format != "svg" {
+		return badQRRequest(c, "format must be png or svg")
+	}
+	opts := qr.Options{
+		Size:   c.QueryInt("size", defaultQRSize),
+		Level:  strings.ToUpper(c.Query("level", "M")),
+		Margin: c.QueryInt("margin", defaultQRMargin),
+	}
+	if opts.Size < minQRSize || opts.Size > s.cfg.QR.MaxSize {
+		return badQRRequest(c, fmt.Sprintf("size must be between %d and %d", minQRSize, s.cfg.QR.MaxSize))
+	}
+	if _, err := qr.ParseLevel(opts.Level); err != nil {
+		return badQRRequest(c, "level must be one of L, M, Q or H")
+	}
+	if opts.Margin < 0 || opts.Margin > maxQRMargin {
+		return badQRRequest(c, fmt.Sprintf("margin must be between 0 and %d", maxQRMargin))
+	}
+	if opts.Foreground, err = qr.ParseColor(c.Query("fg", "000000")); err != nil {
+		return badQRRequest(c, "fg must be a hex color")
+	}
+	if opts.Background, err = qr.ParseColor(c.Query("bg", "ffffff")); err != nil {
+		return badQRRequest(c, "bg must be a hex color")
+	}
+	if c.QueryBool("logo") {
+		if s.qrLogo == nil {
+			return badQRRequest(c, "no logo is configured")
+		}
+		opts.Logo = s.qrLogo
+	}
+
+	content := s.shortLinkURL(c, link.ShortURL)
+	logo := ""
+	if opts.Logo != nil {
+		logo = opts.Logo.ID()
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d|%s|%d|%v|%v|%s",
+		content, format, opts.Size, opts.Level, opts.Margin, opts.Foreground, opts.Background, logo)))
+	key := hex.EncodeToString(sum[:16])
+	etag := `"` + key + `"`
+
+	contentType := "image/png"
+	if format == "svg" {
+		contentType = "image/svg+xml"
+	}
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderCacheControl, fmt.Sprintf("private, max-age=%d", int(s.cfg.QR.CacheTTL.Seconds())))
+	if c.Get(fiber.HeaderIfNoneMatch) == etag {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	image, err := s.redisClient.Get(c.UserContext(), "qr:"+key).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			s.log(c).Warn("reading cached qr code", "error", err)
+		}
+		if format == "svg" {
+			image, err = qr.SVG(content, opts)
+		} else {
+			image, err = qr.PNG(content, opts)
+		}
+		if errors.Is(err, qr.ErrTooSmall) {
+			return badQRRequest(c, "size is too small for this code")
+		}
+		if err != nil {
+			s.log(c).Error("rendering qr code", "error", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+		}
+		if s.cfg.QR.CacheTTL > 0 {
+			if err := s.redisClient.Set(c.UserContext(), "qr:"+key, image, s.cfg.QR.CacheTTL).Err(); err != nil {
+				s.log(c).Warn("caching qr code", "error", err)
+			}
+		}
+	}
+
+	c.Set(fiber.HeaderContentType, contentType)
+	return c.Send(image)
+}
+
+func badQRRequest(c *fiber.Ctx, msg string) error {
+	return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": msg})
+}
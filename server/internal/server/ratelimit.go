@@ -0,0 +1,50 @@
+package server
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/koderkt/teenyurl/internal/config"
+)
+
+// rateLimit limits a route group. Requests carrying a valid session are
+// counted per user when perUser is set; everything else is counted per IP.
+// If the limiter itself errors the request is let through.
+func (s *FiberServer) rateLimit(group string, limit config.Limit, perUser bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if s.limiter == nil || limit.Requests == 0 {
+			return c.Next()
+		}
+
+		key := group + ":ip:" + c.IP()
+		if perUser {
+			if user, ok := s.sessionUser(c); ok {
+				key = group + ":user:" + strconv.Itoa(user.Id)
+			}
+		}
+
+		res, err := s.limiter.Allow(c.UserContext(), key, limit)
+		if err != nil {
+			s.log(c).Error("rate limiter", "group", group, "error", err)
+			return c.Next()
+		}
+
+		c.Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+		c.Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		c.Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+		if !res.Allowed {
+			s.metrics.RateLimited(group)
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(ceilSeconds(res.Reset)))
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"message": "too many requests",
+			})
+		}
+		return c.Next()
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -1,37 +1,102 @@
 package server
 
 import (
-	"context"
-	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"net/url"
 	"strconv"
+	"strings"
 
 	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
-	"github.com/google/uuid"
+	"github.com/koderkt/teenyurl/internal/auth"
+	"github.com/koderkt/teenyurl/internal/config"
+	"github.com/koderkt/teenyurl/internal/database"
+	"github.com/koderkt/teenyurl/internal/metrics"
 	"github.com/koderkt/teenyurl/internal/types"
 	"github.com/koderkt/teenyurl/internal/utils"
 	"golang.org/x/crypto/bcrypt"
 )
 
 func (s *FiberServer) RegisterFiberRoutes() {
+	limits := config.RateLimit{}
+	if s.cfg != nil {
+		limits = s.cfg.RateLimit
+	}
+	authLimit := s.rateLimit("auth", limits.Auth, false)
+	writeLimit := s.rateLimit("write", limits.Write, true)
+	readLimit := s.rateLimit("read", limits.Read, true)
+	redirectLimit := s.rateLimit("redirect", limits.Redirect, false)
+
 	s.App.Get("/", s.HelloWorldHandler)
 	s.App.Get("/health", s.healthHandler)
-	s.App.Post("/signup", s.SignUpHandler)
-	s.App.Post("/signin", s.SignInHandler)
+	s.App.Get("/livez", s.livenessHandler)
+	s.App.Get("/readyz", s.readinessHandler)
+	if s.metrics != nil {
+		s.App.Get(s.cfg.Metrics.Path, s.metrics.Handler())
+	}
+	s.App.Post("/signup", authLimit, s.SignUpHandler)
+	s.App.Post("/signin", authLimit, s.SignInHandler)
+	s.App.Post("/signin/2fa", authLimit, s.TwoFactorSignInHandler)
+	s.App.Post("/signin/2fa/setup", authLimit, s.TwoFactorSignInSetupHandler)
 	s.App.Post("/signout", s.SignOutHandler)
-	s.App.Post("/links", s.CreateShortURLHandler)
-	s.App.Get("/links", s.GetLinksHandler)
-	s.App.Get("/:shortCode", s.ShortURLHandler)
-
-	s.App.Post("/:shortCode", s.EditLongURLHandler)
-	s.App.Post("/:shortCode/:val", s.EnableDisbaleURLHandler)
-	s.App.Get("/analytics/:shortCode", s.AnalyticsHandler)
+	s.App.Post("/token/refresh", authLimit, s.RefreshTokenHandler)
+	s.App.Post("/verify-email", authLimit, s.VerifyEmailHandler)
+	s.App.Post("/verify-email/resend", authLimit, s.ResendVerificationHandler)
+	s.App.Post("/password/forgot", authLimit, s.ForgotPasswordHandler)
+	s.App.Post("/password/reset", authLimit, s.ResetPasswordHandler)
+	s.App.Get("/auth/oidc/providers", s.OIDCProvidersHandler)
+	s.App.Get("/auth/oidc/:provider/login", authLimit, s.OIDCLoginHandler)
+	s.App.Get("/auth/oidc/:provider/callback", authLimit, s.OIDCCallbackHandler)
+	s.App.Post("/auth/oidc/exchange", authLimit, s.OIDCExchangeHandler)
+	s.App.Get("/account/signins", readLimit, s.SignInActivityHandler)
+	s.App.Post("/account/api-keys", writeLimit, s.CreateAPIKeyHandler)
+	s.App.Get("/account/api-keys", readLimit, s.GetAPIKeysHandler)
+	s.App.Delete("/account/api-keys/:id", writeLimit, s.DeleteAPIKeyHandler)
+	s.App.Get("/account/sessions", readLimit, s.ListSessionsHandler)
+	s.App.Delete("/account/sessions", writeLimit, s.RevokeOtherSessionsHandler)
+	s.App.Delete("/account/sessions/:id", writeLimit, s.RevokeSessionHandler)
+	s.App.Get("/account/2fa", readLimit, s.TwoFactorStatusHandler)
+	s.App.Post("/account/2fa/setup", authLimit, s.TwoFactorSetupHandler)
+	s.App.Post("/account/2fa/enable", authLimit, s.TwoFactorEnableHandler)
+	s.App.Post("/account/2fa/disable", authLimit, s.TwoFactorDisableHandler)
+	s.App.Post("/account/2fa/recovery-codes", authLimit, s.RecoveryCodesHandler)
+
+	canRead := s.requireScope(auth.ScopeRead)
+	canWriteLinks := s.requireScope(auth.ScopeLinksWrite)
+	canReadAnalytics := s.requireScope(auth.ScopeRead, auth.ScopeAnalyticsRead)
+
+	api := s.App.Group(apiPrefix)
+	api.Post("/links", writeLimit, canWriteLinks, s.CreateLinkHandler)
+	api.Get("/links", readLimit, canRead, s.ListLinksHandler)
+	api.Get("/links/:shortCode", readLimit, canRead, s.GetLinkHandler)
+	api.Patch("/links/:shortCode", writeLimit, canWriteLinks, s.UpdateLinkHandler)
+	api.Delete("/links/:shortCode", writeLimit, canWriteLinks, s.DeleteLinkHandler)
+	api.Put("/links/:shortCode/status", writeLimit, canWriteLinks, s.SetLinkStatusHandler)
+	api.Post("/links/:shortCode/restore", writeLimit, canWriteLinks, s.RestoreLinkHandler)
+	api.Get("/links/:shortCode/analytics", readLimit, canReadAnalytics, s.LinkAnalyticsHandler)
+	api.Get("/links/:shortCode/qr", readLimit, canRead, s.QRCodeHandler)
+	api.Post("/links/bulk", writeLimit, canWriteLinks, s.BulkUpdateLinksHandler)
+	api.Get("/tags", readLimit, canRead, s.ListTagsHandler)
+	api.Post("/tags", writeLimit, canWriteLinks, s.CreateTagHandler)
+	api.Patch("/tags/:id", writeLimit, canWriteLinks, s.RenameTagHandler)
+	api.Delete("/tags/:id", writeLimit, canWriteLinks, s.DeleteTagHandler)
+	api.Get("/folders", readLimit, canRead, s.ListFoldersHandler)
+	api.Post("/folders", writeLimit, canWriteLinks, s.CreateFolderHandler)
+	api.Patch("/folders/:id", writeLimit, canWriteLinks, s.RenameFolderHandler)
+	api.Delete("/folders/:id", writeLimit, canWriteLinks, s.DeleteFolderHandler)
+	api.Get("/analytics/tags", readLimit, canReadAnalytics, s.TagAnalyticsHandler)
+
+	// Routes from before /api/v1, kept until clients have moved over.
+	s.App.Post("/links", s.deprecated(apiPrefix+"/links"), writeLimit, canWriteLinks, s.CreateShortURLHandler)
+	s.App.Get("/links", s.deprecated(apiPrefix+"/links"), readLimit, canRead, s.GetLinksHandler)
+	s.App.Get("/links/:shortCode/qr", s.deprecated(apiPrefix+"/links/:shortCode/qr"), readLimit, canRead, s.QRCodeHandler)
+	s.App.Get("/analytics/:shortCode", s.deprecated(apiPrefix+"/links/:shortCode/analytics"), readLimit, canReadAnalytics, s.LinkAnalyticsHandler)
+
+	s.App.Get("/:shortCode", redirectLimit, s.ShortURLHandler)
+	s.App.Post("/:shortCode", s.deprecated(apiPrefix+"/links/:shortCode"), writeLimit, canWriteLinks, s.EditLongURLHandler)
+	s.App.Post("/:shortCode/:val", s.deprecated(apiPrefix+"/links/:shortCode/status"), writeLimit, canWriteLinks, s.EnableDisbaleURLHandler)
 }
 
 func (s *FiberServer) HelloWorldHandler(c *fiber.Ctx) error {
@@ -47,6 +112,7 @@ func (s *FiberServer) SignInHandler(c *fiber.Ctx) error {
 	userSignRequest := new(types.UserSignInRequest)
 	err := c.BodyParser(userSignRequest)
 	if err != nil {
+		s.metrics.SignIn(metrics.SignInFailure)
 		c.Status(400)
 		c.JSON(fiber.Error{
 			Code:    fiber.StatusBadRequest,
@@ -59,6 +125,7 @@ func (s *FiberServer) SignInHandler(c *fiber.Ctx) error {
 	err = validate.Struct(userSignRequest)
 
 	if err != nil {
+		s.metrics.SignIn(metrics.SignInFailure)
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"success": false,
 
@@ -66,21 +133,53 @@ func (s *FiberServer) SignInHandler(c *fiber.Ctx) error {
 		})
 	}
 
-	// Get User by email
-	user, err := s.db.GetUserByEmail(userSignRequest.Email)
+	ctx := c.UserContext()
+	email := auth.NormalizeEmail(userSignRequest.Email)
+	attempt := &types.LoginAttempt{
+		Email:     email,
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	}
+
+	// Locked emails are refused before the password is even checked
+	lockedFor, err := s.lockout.Locked(ctx, email)
 	if err != nil {
-		fmt.Println(err)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"success": false,
+		s.log(c).Warn("checking sign in lockout", "error", err)
+	}
+	if lockedFor > 0 {
+		attempt.Reason = "locked"
+		s.recordLoginAttempt(c, attempt)
+		s.metrics.SignIn(metrics.SignInFailure)
+		return signInLocked(c, lockedFor)
+	}
 
-			"message": "email or password is incorrect",
+	// Get User by email
+	user, err := s.db.GetUserByEmail(ctx, userSignRequest.Email)
+	if err != nil && !errors.Is(err, database.ErrUserNotFound) {
+		s.log(c).Error("sign in: user lookup failed", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "internal server error",
 		})
 	}
 
-	// Validate password
-	isValidPassword := utils.CheckPasswordHash(userSignRequest.Password, user.EncryptedPassword)
+	// Validate password. Unknown emails go through the same amount of work
+	// and get the same answer so accounts cannot be enumerated.
+	isValidPassword := false
+	if user != nil {
+		attempt.UserId = &user.ID
+		isValidPassword = utils.CheckPasswordHash(userSignRequest.Password, user.EncryptedPassword)
+	} else {
+		auth.CompareDummyPassword(userSignRequest.Password)
+	}
 
 	if !isValidPassword {
+		attempt.Reason = "invalid_credentials"
+		s.recordLoginAttempt(c, attempt)
+		s.metrics.SignIn(metrics.SignInFailure)
+		if _, err := s.lockout.Fail(ctx, email); err != nil {
+			s.log(c).Warn("recording failed sign in", "error", err)
+		}
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"success": false,
 
@@ -88,67 +187,31 @@ func (s *FiberServer) SignInHandler(c *fiber.Ctx) error {
 		})
 	}
 
-	// Generate session_id
-	sessionId := uuid.NewString()
 	userSession := types.UserSession{
 		Id:       user.ID,
 		UserName: user.UserName,
 		Email:    user.Email,
 	}
-	userSessionBytes, err := json.Marshal(userSession)
+	step, err := s.secondFactor(ctx, user.ID)
 	if err != nil {
+		s.log(c).Error("sign in: two factor lookup failed", "error", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"success": false,
-
 			"message": "internal server error",
 		})
 	}
-
-	// Store session_id and send it to client
-	err = s.redisClient.Set(context.Background(), "session:"+sessionId, string(userSessionBytes), 2*time.Hour).Err()
-	if err != nil {
-		fmt.Println(err)
-
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"success": false,
-			"message": "internal server error",
-		})
+	if step != "" {
+		return s.startChallenge(c, step, userSession)
 	}
-
-	c.Response().Header.Set("Authorization", fmt.Sprintf("Bearer %s", sessionId))
-
-	return c.JSON(fiber.Map{"success": true,
-		"user": userSession,
-	})
+	return s.completeSignIn(c, attempt, userSession, nil)
 }
 
-func (s *FiberServer) SignOutHandler(c *fiber.Ctx) error {
-	sessionHeader := c.Get("Authorization")
-	if sessionHeader == "" || len(sessionHeader) < 8 || sessionHeader[:7] != "Bearer " {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "invalid session header"})
-	}
-	// get the session id
-	sessionId := sessionHeader[7:]
-	_, err := s.GetSession("session:" + sessionId)
-	if err != nil {
-		c.SendStatus(401)
-		return c.JSON(fiber.Map{"message": "unauthorized"})
-	}
-	err = s.redisClient.Del(context.Background(), "session:"+sessionId).Err()
-	if err != nil {
-		c.SendStatus(401)
-		return c.JSON(fiber.Map{"message": "internal server error"})
-	}
-	return c.Status(200).JSON(fiber.Map{
-		"message": "logout successful",
-	})
-}
 func (s *FiberServer) SignUpHandler(c *fiber.Ctx) error {
 	userCreationRequest := new(types.CreateUserRequest)
 
 	err := c.BodyParser(userCreationRequest)
 	if err != nil {
-		log.Printf("%v | Parsing CreateUserRequest | %s", time.Now().Local(), err.Error())
+		s.log(c).Warn("parsing sign up request", "error", err)
 
 		c.Status(400)
 		return c.JSON(fiber.Error{
@@ -161,7 +224,7 @@ func (s *FiberServer) SignUpHandler(c *fiber.Ctx) error {
 	err = validate.Struct(userCreationRequest)
 
 	if err != nil {
-		log.Printf("%v | | %s", time.Now().Local(), err.Error())
+		s.log(c).Warn("validating sign up request", "error", err)
 
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  fiber.StatusBadRequest,
@@ -173,7 +236,7 @@ func (s *FiberServer) SignUpHandler(c *fiber.Ctx) error {
 	validate = validator.New()
 	validate.RegisterValidation("password", utils.PasswordValidator)
 	if err := validate.Var(userCreationRequest.Password, "required,password"); err != nil {
-		log.Printf("%v | Validating password |%s", time.Now().Local(), err.Error())
+		s.log(c).Warn("validating password", "error", err)
 
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Error{
 			Code:    fiber.StatusBadRequest,
@@ -183,7 +246,7 @@ func (s *FiberServer) SignUpHandler(c *fiber.Ctx) error {
 	// Encrypt password before storing
 	encpw, err := bcrypt.GenerateFromPassword([]byte(userCreationRequest.Password), bcrypt.DefaultCost)
 	if err != nil {
-		log.Printf("%v | %s", time.Now().Local(), err.Error())
+		s.log(c).Error("hashing password", "error", err)
 
 		return c.JSON(fiber.Error{
 			Code:    fiber.StatusInternalServerError,
@@ -197,15 +260,16 @@ func (s *FiberServer) SignUpHandler(c *fiber.Ctx) error {
 		EncryptedPassword: string(encpw),
 		CreatedAt:         time.Now(),
 	}
-	err = s.db.CreateUser(&user)
+	err = s.db.CreateUser(c.UserContext(), &user)
 	if err != nil {
-		log.Printf("%v | %s", time.Now().Local(), err.Error())
+		s.log(c).Warn("creating user", "error", err)
 
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Error{
 			Code:    fiber.StatusBadRequest,
 			Message: err.Error(),
 		})
 	}
+	s.sendVerification(c, &user)
 
 	return c.JSON(fiber.Error{
 		Code:    fiber.StatusAccepted,
@@ -213,63 +277,29 @@ func (s *FiberServer) SignUpHandler(c *fiber.Ctx) error {
 	})
 }
 
+// CreateShortURLHandler is the deprecated POST /links. It answers in the
+// old shape; new clients use CreateLinkHandler.
 func (s *FiberServer) CreateShortURLHandler(c *fiber.Ctx) error {
-	sessionHeader := c.Get("Authorization")
-	// ensure the session header is not empty and in the correct format
-	if sessionHeader == "" || len(sessionHeader) < 8 || sessionHeader[:7] != "Bearer " {
-		return c.JSON(fiber.Map{"error": "invalid session header"})
-	}
-	// get the session id
-	sessionId := sessionHeader[7:]
-	userSession, err := s.GetSession("session:" + sessionId)
-	if err != nil {
-		c.SendStatus(401)
-		return c.JSON(fiber.Map{"message": "You are not logged in..."})
+	userSession, ok := s.sessionUser(c)
+	if !ok {
+		return notLoggedIn(c)
+	}
+	if ok, err := s.requireVerified(c, userSession.Id); !ok {
+		return err
 	}
 	longURLRequst := new(types.ShortenRequest)
 
-	err = c.BodyParser(longURLRequst)
-	if err != nil {
-		c.SendStatus(fiber.StatusInternalServerError)
-		return c.JSON(fiber.Map{"message": err.Error()})
-	}
-	parsedURL, err := url.ParseRequestURI(longURLRequst.LongUrl)
+	err := c.BodyParser(longURLRequst)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "Invalid url"})
-	}
-
-	// Check if the scheme (protocol) and host (domain) are valid
-	if parsedURL.Scheme == "" || parsedURL.Host == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid url"})
-	}
-
-	// Validate whether the link is valid
-	genaratedShortCode := utils.GenerateShortCode(6)
-
-	_, err = s.db.GetLink(genaratedShortCode)
-
-	if err != sql.ErrNoRows {
-		c.SendStatus(fiber.StatusInternalServerError)
-		return c.JSON(fiber.Map{"message": err.Error()})
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
 	}
 
-	link := &types.Link{
+	recordFromDB, err := s.createLink(c, &types.Link{
 		OriginalURL: longURLRequst.LongUrl,
-		ShortURL:    genaratedShortCode,
 		UserId:      userSession.Id,
-	}
-
-	err = s.db.CreateShortURL(link)
-	if err != nil {
-		log.Printf("%v | %s", time.Now(), err.Error())
-		return c.JSON(fiber.Map{"error": "failed to create short url"})
-	}
-
-	recordFromDB, err := s.db.GetLink(link.ShortURL)
-
-	if err != nil {
-		c.SendStatus(fiber.StatusInternalServerError)
-		return c.JSON(fiber.Map{"message": "failed to create short url"})
+	}, nil)
+	if recordFromDB == nil {
+		return err
 	}
 	responseData := types.CreateShortURLResponse{
 		ShortURL:    string(c.Request().Host()) + "/" + recordFromDB.ShortURL,
@@ -283,27 +313,7 @@ func (s *FiberServer) healthHandler(c *fiber.Ctx) error {
 	return c.JSON(s.db.Health())
 }
 
-func (s *FiberServer) GetSession(session string) (*types.UserSession, error) {
-	data, err := s.redisClient.Get(context.Background(), session).Result()
-	if err != nil {
-		log.Printf("%v | %s", time.Now().Local(), err.Error())
-
-		return nil, err
-	}
-
-	var userSession types.UserSession
-	err = json.Unmarshal([]byte(data), &userSession)
-	if err != nil {
-		log.Printf("%v | %s", time.Now().Local(), err.Error())
-		return nil, err
-	}
-
-	return &userSession, nil
-
-}
-
 func (s *FiberServer) ShortURLHandler(c *fiber.Ctx) error {
-	fmt.Println("hello")
 	// sessionHeader := c.Get("Authorizati  on")
 
 	// // ensure the session header is not empty and in the correct format
@@ -317,207 +327,132 @@ func (s *FiberServer) ShortURLHandler(c *fiber.Ctx) error {
 	// 	c.SendStatus(401)
 	// 	return c.JSON(fiber.Map{"message": "You are not logged in..."})
 	// }
-	shortCode := c.Params("shortCode")
-	link, err := s.db.GetLink(shortCode)
-	fmt.Println("Before....")
-
-	if err != nil {
+	// a trailing + asks for the preview page instead of the redirect
+	shortCode, wantPreview := strings.CutSuffix(c.Params("shortCode"), "+")
+	link, err := s.db.GetLink(c.UserContext(), shortCode)
 
+	if err != nil || link.DeletedAt != nil {
+		s.metrics.Redirect(metrics.RedirectNotFound)
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "link not found",
 		})
 	}
+	if link.FlaggedAt != nil {
+		s.metrics.Redirect(metrics.RedirectFlagged)
+		return s.sendPreview(c, fiber.StatusForbidden, link)
+	}
 	if !link.IsEnabled {
+		s.metrics.Redirect(metrics.RedirectDisabled)
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 			"error": "link is disabled at the moment",
 		})
 	}
-	analyticsData := types.Clicks{
-		ShortCode:  shortCode,
-		DeviceType: "Unknown",
-		Location:   "Unknown",
-	}
-	fmt.Println("InsertAnalytics....")
-	err = s.db.InsertAnalytics(&analyticsData)
-	if err != nil {
-		log.Printf("%v | %s", time.Now(), err.Error())
-
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "failed to record analytics",
-		})
-	}
-	return c.Redirect(link.OriginalURL, fiber.StatusPermanentRedirect)
-}
-
-func (s *FiberServer) AnalyticsHandler(c *fiber.Ctx) error {
-	sessionHeader := c.Get("Authorization")
-
-	// ensure the session header is not empty and in the correct format
-	if sessionHeader == "" || len(sessionHeader) < 8 || sessionHeader[:7] != "Bearer " {
-		return c.JSON(fiber.Map{"error": "invalid session header"})
-	}
-	// get the session id
-	sessionId := sessionHeader[7:]
-	_, err := s.GetSession(sessionId)
-	if err != nil {
-		c.SendStatus(401)
-		return c.JSON(fiber.Map{"message": "You are not logged in..."})
+	if wantPreview {
+		s.metrics.Redirect(metrics.RedirectPreview)
+		return s.sendPreview(c, fiber.StatusOK, link)
 	}
-	shortCode := c.Params("shortCode")
-
-	clicks, err := s.db.GetAnalystics(shortCode)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			c.SendStatus(fiber.StatusAccepted)
-			return err
-		} else {
-			log.Printf("%v | %s", time.Now(), err.Error())
-
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	if s.cfg.Analytics.Enabled {
+		analyticsData := types.Clicks{
+			ShortCode:  shortCode,
+			DeviceType: "Unknown",
+			Location:   "Unknown",
+		}
+		err = s.clicks.Record(c.UserContext(), analyticsData)
+		if err != nil {
+			s.log(c).Warn("recording click", "short_code", shortCode, "error", err)
 		}
 	}
-
-	return c.Status(fiber.StatusAccepted).JSON(clicks)
-
+	// links asking for it, and those outside the trusted domains, show
+	// the preview. Visitors got there through the link, so it counts as a
+	// click.
+	if link.Preview || !s.urlPolicy.Trusted(link.OriginalURL) {
+		s.metrics.Redirect(metrics.RedirectPreview)
+		return s.sendPreview(c, fiber.StatusOK, link)
+	}
+	s.metrics.Redirect(metrics.RedirectHit)
+	status := s.redirectStatus(link)
+	// permanent redirects are kept by browsers, so they are only cached
+	// for a while to let edits through eventually. Temporary ones aren't
+	// cached at all, so every visit is counted.
+	if status == fiber.StatusMovedPermanently || status == fiber.StatusPermanentRedirect {
+		c.Set(fiber.HeaderCacheControl, fmt.Sprintf("public, max-age=%d", int(s.cfg.Links.PermanentMaxAge.Seconds())))
+	} else {
+		c.Set(fiber.HeaderCacheControl, "private, no-store")
+	}
+	return c.Redirect(link.OriginalURL, status)
 }
 
+// GetLinksHandler is the deprecated GET /links. It returns every link in
+// one array; new clients page through ListLinksHandler.
 func (s *FiberServer) GetLinksHandler(c *fiber.Ctx) error {
-	sessionHeader := c.Get("Authorization")
-
-	// ensure the session header is not empty and in the correct format
-	if sessionHeader == "" || len(sessionHeader) < 8 || sessionHeader[:7] != "Bearer " {
-		return c.Status(400).JSON(fiber.Map{"error": "invalid session header"})
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return notLoggedIn(c)
 	}
-	// get the session id
-	sessionId := sessionHeader[7:]
 
-	user, err := s.GetSession("session:" + sessionId)
+	links, err := s.db.GetLinks(c.UserContext(), user.Id)
 	if err != nil {
-		log.Printf("%v | %s", time.Now(), err.Error())
-
-		c.SendStatus(401)
-		return c.JSON(fiber.Map{"message": "You are not logged in..."})
+		s.log(c).Error("listing links", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
 	}
-
-	links, err := s.db.GetLinks(user.Id)
 	linksResponse := []types.LinkResponse{}
-	if err != nil {
-		if err == sql.ErrNoRows {
-			c.SendStatus(fiber.StatusAccepted)
-			return err
-		} else {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
-		}
-	}
-	for _, link := range *links {
-		var linkResponse types.LinkResponse
-		clicks, err := s.db.GetNumberOfClicks(link.ShortURL)
+	for i := range *links {
+		resp, err := s.linkResponse(c, &(*links)[i])
 		if err != nil {
+			s.log(c).Error("counting clicks", "error", err)
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
 		}
-		linkResponse.ShortURL = string(c.Request().Host()) + "/" + link.ShortURL
-		linkResponse.OriginalURL = link.OriginalURL
-		linkResponse.CreatedAt = link.CreatedAt
-		linkResponse.Clicks = clicks
-		linkResponse.IsEnabled = link.IsEnabled
-		linkResponse.Id = link.Id
-
-		linksResponse = append(linksResponse, linkResponse)
+		linksResponse = append(linksResponse, resp)
 	}
-
 	return c.Status(fiber.StatusAccepted).JSON(linksResponse)
 }
 
+// EditLongURLHandler is the deprecated POST /:shortCode. New clients use
+// UpdateLinkHandler.
 func (s *FiberServer) EditLongURLHandler(c *fiber.Ctx) error {
-	sessionHeader := c.Get("Authorization")
-
 	longURL := types.CreateShortURLResponse{}
 
 	err := c.BodyParser(&longURL)
 	if err != nil {
-		log.Printf("%v | %s", time.Now(), err.Error())
+		s.log(c).Warn("parsing edit request", "error", err)
 
 		return c.Status(400).JSON(fiber.Map{"error": "bad request"})
 	}
-	// ensure the session header is not empty and in the correct format
-	if sessionHeader == "" || len(sessionHeader) < 8 || sessionHeader[:7] != "Bearer " {
-		return c.Status(400).JSON(fiber.Map{"error": "invalid session header"})
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return notLoggedIn(c)
 	}
-	// get the session id
-	sessionId := sessionHeader[7:]
-
-	_, err = s.GetSession("session:" + sessionId)
-	if err != nil {
-		log.Printf("%v | %s", time.Now(), err.Error())
 
-		c.SendStatus(401)
-		return c.JSON(fiber.Map{"message": "You are not logged in..."})
+	link, err := s.ownedLink(c, user.Id, c.Params("shortCode"))
+	if link == nil {
+		return err
 	}
-
-	shortCode := c.Params("shortCode")
-
-	link, err := s.db.GetLink(shortCode)
-	linksResponse := []types.LinkResponse{}
-	if err != nil {
-		if err == sql.ErrNoRows {
-			c.SendStatus(fiber.StatusAccepted)
-			return err
-		} else {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
-		}
-	}
-	link.OriginalURL = longURL.OriginalURL
-
-	err = s.db.EditLink(link)
-	if err != nil {
-		log.Printf("%v | %s", time.Now(), err.Error())
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	if ok, err := s.editLongURL(c, link, longURL.OriginalURL); !ok {
+		return err
 	}
-	return c.Status(fiber.StatusAccepted).JSON(linksResponse)
+	return c.Status(fiber.StatusAccepted).JSON([]types.LinkResponse{})
 }
 
+// EnableDisbaleURLHandler is the deprecated POST /:shortCode/:val. New
+// clients use SetLinkStatusHandler.
 func (s *FiberServer) EnableDisbaleURLHandler(c *fiber.Ctx) error {
-	sessionHeader := c.Get("Authorization")
-
-	// ensure the session header is not empty and in the correct format
-	if sessionHeader == "" || len(sessionHeader) < 8 || sessionHeader[:7] != "Bearer " {
-		return c.Status(400).JSON(fiber.Map{"error": "invalid session header"})
-	}
-	// get the session id
-	sessionId := sessionHeader[7:]
-
-	_, err := s.GetSession("session:" + sessionId)
-	if err != nil {
-		log.Printf("%v | %s", time.Now(), err.Error())
-
-		c.SendStatus(401)
-		return c.JSON(fiber.Map{"message": "You are not logged in..."})
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return notLoggedIn(c)
 	}
 
-	shortCode := c.Params("shortCode")
-
 	val, err := strconv.ParseBool(c.Params("val"))
 	if err != nil {
 		c.SendStatus(400)
 		return c.JSON(fiber.Map{"message": "bad rerquest"})
 	}
 
-	link, err := s.db.GetLink(shortCode)
-	linksResponse := []types.LinkResponse{}
-	if err != nil {
-		if err == sql.ErrNoRows {
-			c.SendStatus(fiber.StatusAccepted)
-			return err
-		} else {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
-		}
+	link, err := s.ownedLink(c, user.Id, c.Params("shortCode"))
+	if link == nil {
+		return err
 	}
-
-	link.IsEnabled = val
-	err = s.db.EnableDisableLink(link)
-	if err != nil {
-		log.Printf("%v | %s", time.Now(), err.Error())
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	if ok, err := s.setLinkEnabled(c, link, val); !ok {
+		return err
 	}
-	return c.Status(fiber.StatusAccepted).JSON(linksResponse)
+	return c.Status(fiber.StatusAccepted).JSON([]types.LinkResponse{})
 }
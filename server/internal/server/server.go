@@ -1,31 +1,229 @@
 package server
 
 import (
-	"log"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/koderkt/teenyurl/internal/analytics"
+	"github.com/koderkt/teenyurl/internal/auth"
+	"github.com/koderkt/teenyurl/internal/config"
 	"github.com/koderkt/teenyurl/internal/database"
+	"github.com/koderkt/teenyurl/internal/links"
+	"github.com/koderkt/teenyurl/internal/mail"
+	"github.com/koderkt/teenyurl/internal/metadata"
+	"github.com/koderkt/teenyurl/internal/metrics"
+	"github.com/koderkt/teenyurl/internal/oidc"
+	"github.com/koderkt/teenyurl/internal/qr"
+	"github.com/koderkt/teenyurl/internal/ratelimit"
+	"github.com/koderkt/teenyurl/internal/scanner"
+	"github.com/koderkt/teenyurl/internal/urlpolicy"
 	"github.com/redis/go-redis/v9"
 )
 
 type FiberServer struct {
 	*fiber.App
+	cfg         *config.Config
+	logger      *slog.Logger
 	redisClient *redis.Client
 	db          database.Service
+	clicks      *analytics.Recorder
+	purger      *links.Purger
+	// linkMetadata is nil when metadata fetching is turned off.
+	linkMetadata *metadata.Worker
+	urlPolicy    *urlpolicy.Policy
+	// urlScanner and rechecker are nil when scanning is turned off.
+	urlScanner scanner.URLScanner
+	rechecker  *scanner.Rechecker
+	metrics    *metrics.Metrics
+	limiter    ratelimit.Limiter
+	lockout    *auth.Lockout
+	sessions   *auth.Sessions
+	// tokens and refreshTokens are only set when Session.Mode is "jwt".
+	tokens        *auth.Tokens
+	refreshTokens *auth.RefreshTokens
+	accountTokens *auth.AccountTokens
+	mailer        mail.Mailer
+	oidcProviders map[string]*oidc.Provider
+	qrLogo        *qr.Logo
+	// mails tracks messages still being sent in the background.
+	mails sync.WaitGroup
+	// draining is set once shutdown starts so /readyz fails while
+	// in-flight requests finish.
+	draining atomic.Bool
 }
 
-func New() *FiberServer {
+func New(cfg *config.Config, logger *slog.Logger) *FiberServer {
+	db := database.WithTracing(database.New(cfg.Postgres, logger))
+	redisClient, err := database.CreateRedisConnection(cfg.Redis)
+	if err != nil {
+		logger.Error("connecting to redis", "error", err)
+		os.Exit(1)
+	}
+	if err := db.Init(); err != nil {
+		logger.Error("initialising database", "error", err)
+		os.Exit(1)
+	}
+	return NewWithStores(cfg, logger, db, redisClient)
+}
+
+// NewWithStores builds the server on an initialised database and a Redis
+// client, which tests replace with fakes.
+func NewWithStores(cfg *config.Config, logger *slog.Logger, db database.Service, redisClient *redis.Client) *FiberServer {
 	server := &FiberServer{
 		App: fiber.New(fiber.Config{
 			ServerHeader: "teenyurl",
 			AppName:      "teenyurl",
+			// startup is logged through slog instead
+			DisableStartupMessage:   true,
+			ProxyHeader:             cfg.HTTP.ProxyHeader,
+			EnableTrustedProxyCheck: len(cfg.HTTP.TrustedProxies) > 0,
+			TrustedProxies:          cfg.HTTP.TrustedProxies,
+			ReadTimeout:             cfg.HTTP.ReadTimeout,
+			WriteTimeout:            cfg.HTTP.WriteTimeout,
+			IdleTimeout:             cfg.HTTP.IdleTimeout,
 		}),
-		redisClient: database.CreateRedisConnection(),
-		db:          database.New(),
+		cfg:         cfg,
+		logger:      logger,
+		db:          db,
+		redisClient: redisClient,
+	}
+	var err error
+	server.clicks = analytics.NewRecorder(server.db, cfg.Analytics.BufferSize, logger)
+	if cfg.Metadata.Enabled {
+		server.linkMetadata = metadata.NewWorker(server.db, metadata.NewFetcher(cfg.Metadata), cfg.Metadata, logger)
+	}
+	server.urlPolicy = urlpolicy.New(cfg.URLPolicy, cfg.ShortCode.BaseURL, nil)
+	if cfg.Scanner.BlocklistFile != "" {
+		server.urlScanner, err = scanner.NewBlocklist(cfg.Scanner.BlocklistFile)
+		if err != nil {
+			logger.Error("loading blocklist", "error", err)
+			os.Exit(1)
+		}
+		if cfg.Scanner.RecheckInterval > 0 {
+			server.rechecker = scanner.NewRechecker(server.db, server.urlScanner, cfg.Scanner, logger)
+		}
+	}
+	if cfg.Links.PurgeInterval > 0 {
+		server.purger = links.NewPurger(server.db, cfg.Links, logger)
+	}
+
+	server.lockout = auth.NewLockout(server.redisClient, cfg.Lockout)
+	server.sessions = auth.NewSessions(server.redisClient, cfg.Session.TTL)
+
+	if cfg.Session.Mode == "jwt" {
+		server.tokens, err = auth.NewTokens(cfg.Session.JWT)
+		if err != nil {
+			logger.Error("loading signing keys", "error", err)
+			os.Exit(1)
+		}
+		server.refreshTokens = auth.NewRefreshTokens(server.redisClient, cfg.Session.JWT.RefreshTokenTTL)
 	}
-	err := server.db.Init()
+
+	var secretSet bool
+	server.accountTokens, secretSet, err = auth.NewAccountTokens(cfg.Account)
 	if err != nil {
-		log.Fatal(err)
+		logger.Error("creating account token signer", "error", err)
+		os.Exit(1)
+	}
+	if !secretSet {
+		logger.Warn("account.token_secret is not set, emailed links will stop working on restart")
+	}
+	server.mailer, err = mail.New(cfg.Mail, logger)
+	if err != nil {
+		logger.Error("creating mailer", "error", err)
+		os.Exit(1)
+	}
+
+	if cfg.QR.LogoFile != "" {
+		server.qrLogo, err = qr.LoadLogo(cfg.QR.LogoFile)
+		if err != nil {
+			logger.Error("loading qr logo", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	server.oidcProviders = map[string]*oidc.Provider{}
+	for _, p := range cfg.OIDC.Providers {
+		server.oidcProviders[p.Name] = oidc.NewProvider(p)
+	}
+
+	if cfg.RateLimit.Enabled {
+		server.limiter = &ratelimit.Fallback{
+			Primary:   ratelimit.NewRedis(server.redisClient),
+			Secondary: ratelimit.NewMemory(),
+			Logger:    logger,
+		}
+	}
+
+	server.App.Use(requestIDMiddleware())
+	server.App.Use(tracingMiddleware())
+	server.App.Use(server.requestLogger())
+
+	if cfg.Metrics.Enabled {
+		server.metrics = metrics.New()
+		server.metrics.RegisterDBStats(cfg.Postgres.Database, server.db.Stats)
+		server.metrics.RegisterRedisStats(server.redisClient)
+		server.App.Use(server.metrics.Middleware())
 	}
 	return server
 }
+
+// GracefulShutdown fails readiness for the drain delay, stops accepting
+// connections and waits for in-flight requests. Then it waits for mail
+// being sent, stops the metadata fetcher, the rechecker and the purge job,
+// flushes queued clicks and closes Postgres and Redis.
+// Everything shares the deadline of ctx.
+func (s *FiberServer) GracefulShutdown(ctx context.Context) error {
+	var errs []error
+
+	s.draining.Store(true)
+	if s.cfg != nil && s.cfg.HTTP.DrainDelay > 0 {
+		select {
+		case <-time.After(s.cfg.HTTP.DrainDelay):
+		case <-ctx.Done():
+		}
+	}
+	if err := s.App.ShutdownWithContext(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if err := s.waitForMail(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if s.linkMetadata != nil {
+		if err := s.linkMetadata.Close(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if s.rechecker != nil {
+		if err := s.rechecker.Close(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if s.purger != nil {
+		if err := s.purger.Close(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if s.clicks != nil {
+		if err := s.clicks.Close(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if s.db != nil {
+		if err := s.db.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if s.redisClient != nil {
+		if err := s.redisClient.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
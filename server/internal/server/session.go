@@ -0,0 +1,226 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/koderkt/teenyurl/internal/auth"
+	"github.com/koderkt/teenyurl/internal/types"
+)
+
+const (
+	sessionUserKey = "session_user"
+	// sessionIdKey holds the session ID when the request was made with one,
+	// or the refresh token family of an access token.
+	sessionIdKey = "session_id"
+)
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+type tokenResponse struct {
+	Success      bool              `json:"success"`
+	User         types.UserSession `json:"user"`
+	TokenType    string            `json:"token_type"`
+	AccessToken  string            `json:"access_token"`
+	ExpiresIn    int               `json:"expires_in"`
+	RefreshToken string            `json:"refresh_token"`
+	// RecoveryCodes is only set when the sign-in also enrolled 2FA.
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+func notLoggedIn(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "You are not logged in..."})
+}
+
+func bearerToken(c *fiber.Ctx) (string, bool) {
+	token, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	return token, ok && token != ""
+}
+
+// sessionUser resolves the credential on the request, if any, and
+// remembers it for the rest of the request. API keys are accepted in
+// X-API-Key or as Bearer. Signed access tokens are accepted when JWT mode
+// is on; opaque session IDs are always accepted so sessions issued before
+// switching modes keep working until they expire.
+func (s *FiberServer) sessionUser(c *fiber.Ctx) (*types.UserSession, bool) {
+	if user, ok := c.Locals(sessionUserKey).(*types.UserSession); ok {
+		return user, true
+	}
+	if key := c.Get(apiKeyHeader); key != "" {
+		return s.apiKeyUser(c, key)
+	}
+	token, ok := bearerToken(c)
+	if !ok {
+		return nil, false
+	}
+	if auth.IsAPIKey(token) {
+		return s.apiKeyUser(c, token)
+	}
+
+	var user *types.UserSession
+	var err error
+	if s.tokens != nil && strings.Count(token, ".") == 2 {
+		var family string
+		user, family, err = s.tokens.Verify(token)
+		if err == nil && family != "" {
+			c.Locals(sessionIdKey, family)
+		}
+	} else {
+		var session *types.Session
+		session, err = s.sessions.Get(c.UserContext(), token)
+		if err == nil {
+			s.touchSession(c, token, session)
+			user = &session.UserSession
+			c.Locals(sessionIdKey, token)
+		}
+	}
+	if err != nil {
+		s.log(c).Debug("unauthorized", "error", err)
+		return nil, false
+	}
+	c.Locals(sessionUserKey, user)
+	return user, true
+}
+
+// startSession signs the user in: a Redis session in session mode, or an
+// access and refresh token pair in JWT mode. Either way the credential to
+// send back as Bearer is also set in the Authorization response header.
+func (s *FiberServer) startSession(c *fiber.Ctx, userSession types.UserSession) error {
+	return s.issueSession(c, userSession, nil)
+}
+
+// issueSession is startSession that also hands back the recovery codes of
+// a 2FA enrollment completed as part of the sign-in.
+func (s *FiberServer) issueSession(c *fiber.Ctx, userSession types.UserSession, recoveryCodes []string) error {
+	now := time.Now()
+	session := &types.Session{
+		UserSession: userSession,
+		CreatedAt:   now,
+		LastSeenAt:  now,
+		IP:          c.IP(),
+		UserAgent:   c.Get(fiber.HeaderUserAgent),
+	}
+
+	if s.tokens != nil {
+		family, refreshToken, err := s.refreshTokens.Issue(c.UserContext(), session)
+		if err != nil {
+			s.log(c).Error("issuing refresh token", "error", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"success": false,
+				"message": "internal server error",
+			})
+		}
+		return s.sendTokens(c, userSession, family, refreshToken, recoveryCodes)
+	}
+
+	// Store the session and send its id to the client
+	sessionId, err := s.sessions.Create(c.UserContext(), session)
+	if err != nil {
+		s.log(c).Error("storing session", "error", err)
+
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "internal server error",
+		})
+	}
+
+	c.Response().Header.Set("Authorization", fmt.Sprintf("Bearer %s", sessionId))
+
+	resp := fiber.Map{"success": true,
+		"user": userSession,
+	}
+	if recoveryCodes != nil {
+		resp["recovery_codes"] = recoveryCodes
+	}
+	return c.JSON(resp)
+}
+
+func (s *FiberServer) sendTokens(c *fiber.Ctx, userSession types.UserSession, family, refreshToken string, recoveryCodes []string) error {
+	accessToken, err := s.tokens.Issue(userSession, family)
+	if err != nil {
+		s.log(c).Error("signing access token", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"success": false,
+			"message": "internal server error",
+		})
+	}
+
+	c.Response().Header.Set("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+	return c.JSON(tokenResponse{
+		Success:       true,
+		User:          userSession,
+		TokenType:     "Bearer",
+		AccessToken:   accessToken,
+		ExpiresIn:     int(s.tokens.TTL().Seconds()),
+		RefreshToken:  refreshToken,
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+// RefreshTokenHandler exchanges a refresh token for a new access token and
+// the next refresh token. Presenting a token that was already exchanged
+// revokes every token descended from the same sign-in.
+func (s *FiberServer) RefreshTokenHandler(c *fiber.Ctx) error {
+	if s.refreshTokens == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "token refresh is not enabled"})
+	}
+
+	req := new(refreshRequest)
+	if err := c.BodyParser(req); err != nil || req.RefreshToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "refresh_token is required"})
+	}
+
+	session, next, err := s.refreshTokens.Rotate(c.UserContext(), req.RefreshToken, c.IP())
+	switch {
+	case errors.Is(err, auth.ErrRefreshTokenReused):
+		s.log(c).Warn("refresh token reuse detected, family revoked")
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "invalid refresh token"})
+	case errors.Is(err, auth.ErrInvalidRefreshToken):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "invalid refresh token"})
+	case err != nil:
+		s.log(c).Error("rotating refresh token", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "internal server error"})
+	}
+	return s.sendTokens(c, session.UserSession, session.ID, next, nil)
+}
+
+// SignOutHandler ends the session named by the Bearer header. In JWT mode
+// the refresh token in the body is revoked instead; the short-lived access
+// token simply runs out.
+func (s *FiberServer) SignOutHandler(c *fiber.Ctx) error {
+	if s.refreshTokens != nil {
+		req := new(refreshRequest)
+		if err := c.BodyParser(req); err == nil && req.RefreshToken != "" {
+			if err := s.refreshTokens.Revoke(c.UserContext(), req.RefreshToken); err != nil {
+				s.log(c).Error("revoking refresh token", "error", err)
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "internal server error"})
+			}
+			return c.Status(200).JSON(fiber.Map{
+				"message": "logout successful",
+			})
+		}
+	}
+
+	sessionId, ok := bearerToken(c)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "invalid session header"})
+	}
+	session, err := s.sessions.Get(c.UserContext(), sessionId)
+	if err != nil {
+		c.SendStatus(401)
+		return c.JSON(fiber.Map{"message": "unauthorized"})
+	}
+	err = s.sessions.Delete(c.UserContext(), session.Id, sessionId)
+	if err != nil {
+		c.SendStatus(401)
+		return c.JSON(fiber.Map{"message": "internal server error"})
+	}
+	return c.Status(200).JSON(fiber.Map{
+		"message": "logout successful",
+	})
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/koderkt/teenyurl/internal/auth"
+	"github.com/koderkt/teenyurl/internal/types"
+)
+
+// touchSession records that the session was just used, and from where.
+func (s *FiberServer) touchSession(c *fiber.Ctx, sessionId string, session *types.Session) {
+	if err := s.sessions.Touch(c.UserContext(), sessionId, session, c.IP(), time.Now()); err != nil {
+		s.log(c).Warn("updating session last seen", "error", err)
+	}
+}
+
+// listSessions returns the user's opaque sessions and, in JWT mode, the
+// sessions of their refresh token families.
+func (s *FiberServer) listSessions(ctx context.Context, userId int) (sessions, families []auth.StoredSession, err error) {
+	sessions, err = s.sessions.List(ctx, userId)
+	if err != nil || s.refreshTokens == nil {
+		return sessions, nil, err
+	}
+	families, err = s.refreshTokens.List(ctx, userId)
+	return sessions, families, err
+}
+
+// revokeSessions ends the user's sessions and refresh token families
+// except keep, which may be empty, and returns how many were ended.
+func (s *FiberServer) revokeSessions(ctx context.Context, userId int, keep string) (int, error) {
+	revoked, err := s.sessions.RevokeAll(ctx, userId, keep)
+	if err != nil || s.refreshTokens == nil {
+		return revoked, err
+	}
+	families, err := s.refreshTokens.RevokeAll(ctx, userId, keep)
+	return revoked + families, err
+}
+
+// ListSessionsHandler lists the current user's active sessions, newest
+// first. In JWT mode each refresh token family counts as one.
+func (s *FiberServer) ListSessionsHandler(c *fiber.Ctx) error {
+	user, err := s.accountUser(c)
+	if user == nil {
+		return err
+	}
+	sessions, families, err := s.listSessions(c.UserContext(), user.Id)
+	if err != nil {
+		s.log(c).Error("listing sessions", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+
+	sessions = append(sessions, families...)
+	slices.SortStableFunc(sessions, func(a, b auth.StoredSession) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+
+	current, _ := c.Locals(sessionIdKey).(string)
+	active := make([]types.ActiveSession, 0, len(sessions))
+	for _, session := range sessions {
+		active = append(active, types.ActiveSession{
+			Id:         auth.SessionHandle(session.ID),
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			IP:         session.IP,
+			UserAgent:  session.UserAgent,
+			Current:    session.ID == current,
+		})
+	}
+	return c.JSON(active)
+}
+
+// RevokeSessionHandler ends one of the current user's sessions.
+func (s *FiberServer) RevokeSessionHandler(c *fiber.Ctx) error {
+	user, err := s.accountUser(c)
+	if user == nil {
+		return err
+	}
+	sessions, families, err := s.listSessions(c.UserContext(), user.Id)
+	if err != nil {
+		s.log(c).Error("listing sessions", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+
+	handle := func(session auth.StoredSession) bool {
+		return auth.SessionHandle(session.ID) == c.Params("id")
+	}
+	if i := slices.IndexFunc(sessions, handle); i >= 0 {
+		err = s.sessions.Delete(c.UserContext(), user.Id, sessions[i].ID)
+	} else if i := slices.IndexFunc(families, handle); i >= 0 {
+		err = s.refreshTokens.RevokeFamily(c.UserContext(), families[i].ID)
+	} else {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": "session not found"})
+	}
+	if err != nil {
+		s.log(c).Error("revoking session", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// RevokeOtherSessionsHandler ends every session of the current user but
+// the one making the request. In JWT mode that is every refresh token
+// family but the one of the access token.
+func (s *FiberServer) RevokeOtherSessionsHandler(c *fiber.Ctx) error {
+	user, err := s.accountUser(c)
+	if user == nil {
+		return err
+	}
+	current, _ := c.Locals(sessionIdKey).(string)
+	revoked, err := s.revokeSessions(c.UserContext(), user.Id, current)
+	if err != nil {
+		s.log(c).Error("revoking sessions", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	return c.JSON(fiber.Map{"revoked": revoked})
+}
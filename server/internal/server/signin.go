@@ -0,0 +1,53 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/koderkt/teenyurl/internal/types"
+)
+
+const (
+	defaultSignInActivity = 20
+	maxSignInActivity     = 100
+)
+
+// recordLoginAttempt writes the audit record. A failure to write it is
+// logged but never blocks the sign-in itself.
+func (s *FiberServer) recordLoginAttempt(c *fiber.Ctx, attempt *types.LoginAttempt) {
+	if err := s.db.RecordLoginAttempt(c.UserContext(), attempt); err != nil {
+		s.log(c).Error("recording login attempt", "error", err)
+	}
+}
+
+// signInLocked answers a sign-in for a locked email. Unknown emails are
+// locked in exactly the same way, so this says nothing about the account.
+func signInLocked(c *fiber.Ctx, lockedFor time.Duration) error {
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(ceilSeconds(lockedFor)))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"success": false,
+		"message": "too many failed sign in attempts, try again later",
+	})
+}
+
+// SignInActivityHandler lists the most recent sign-in attempts against the
+// current user's account, newest first. ?limit= caps the number returned.
+func (s *FiberServer) SignInActivityHandler(c *fiber.Ctx) error {
+	user, err := s.accountUser(c)
+	if user == nil {
+		return err
+	}
+
+	limit := c.QueryInt("limit", defaultSignInActivity)
+	if limit < 1 || limit > maxSignInActivity {
+		limit = defaultSignInActivity
+	}
+
+	attempts, err := s.db.GetLoginAttempts(c.UserContext(), user.Id, limit)
+	if err != nil {
+		s.log(c).Error("fetching sign in activity", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	return c.JSON(attempts)
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/koderkt/teenyurl/internal/database"
+	"github.com/koderkt/teenyurl/internal/types"
+)
+
+const (
+	maxTagsPerLink = 20
+	maxTagName     = 50
+)
+
+// cleanTagNames trims the names and drops repeats, ignoring case.
+func cleanTagNames(names []string) ([]string, error) {
+	seen := map[string]bool{}
+	clean := []string{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || len(name) > maxTagName {
+			return nil, fmt.Errorf("tag names must be 1 to %d characters", maxTagName)
+		}
+		if seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+		clean = append(clean, name)
+	}
+	if len(clean) > maxTagsPerLink {
+		return nil, fmt.Errorf("a link can have at most %d tags", maxTagsPerLink)
+	}
+	return clean, nil
+}
+
+// checkFolder makes sure a requested folder_id is 0 or one of the user's
+// folders. On failure it writes the response and returns false.
+func (s *FiberServer) checkFolder(c *fiber.Ctx, userId int, folderId int) (bool, error) {
+	if folderId == 0 {
+		return true, nil
+	}
+	_, err := s.db.GetFolder(c.UserContext(), userId, folderId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "folder not found"})
+	}
+	if err != nil {
+		s.log(c).Error("fetching folder", "error", err)
+		return false, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	return true, nil
+}
+
+// folderColumn is the stored value of a requested folder_id, where 0
+// means no folder.
+func folderColumn(folderId int) *int {
+	if folderId == 0 {
+		return nil
+	}
+	return &folderId
+}
+
+// parseName reads a NameRequest. On failure it writes the response and
+// returns an empty name.
+func parseName(c *fiber.Ctx) (string, error) {
+	req := new(types.NameRequest)
+	if err := c.BodyParser(req); err != nil {
+		return "", c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "bad request"})
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if err := validator.New().Struct(req); err != nil {
+		return "", c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": fmt.Sprintf("name must be 1 to %d characters", maxTagName)})
+	}
+	return req.Name, nil
+}
+
+// namedResult writes the response to renaming or deleting a tag or folder.
+func (s *FiberServer) namedResult(c *fiber.Ctx, what string, err error) error {
+	switch {
+	case err == nil:
+		return c.SendStatus(fiber.StatusNoContent)
+	case errors.Is(err, sql.ErrNoRows):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"message": what + " not found"})
+	case errors.Is(err, database.ErrNameTaken):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": err.Error()})
+	default:
+		s.log(c).Error("updating "+what, "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+}
+
+func (s *FiberServer) ListTagsHandler(c *fiber.Ctx) error {
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return notLoggedIn(c)
+	}
+	tags, err := s.db.GetTags(c.UserContext(), user.Id)
+	if err != nil {
+		s.log(c).Error("listing tags", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	return c.JSON(tags)
+}
+
+func (s *FiberServer) CreateTagHandler(c *fiber.Ctx) error {
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return notLoggedIn(c)
+	}
+	name, err := parseName(c)
+	if name == "" {
+		return err
+	}
+
+	tag := &types.Tag{UserId: user.Id, Name: name}
+	err = s.db.CreateTag(c.UserContext(), tag)
+	if errors.Is(err, database.ErrNameTaken) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": err.Error()})
+	}
+	if err != nil {
+		s.log(c).Error("creating tag", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(tag)
+}
+
+// RenameTagHandler renames a tag on every link that has it.
+func (s *FiberServer) RenameTagHandler(c *fiber.Ctx) error {
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return notLoggedIn(c)
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid tag id"})
+	}
+	name, err := parseName(c)
+	if name == "" {
+		return err
+	}
+	return s.namedResult(c, "tag", s.db.RenameTag(c.UserContext(), user.Id, id, name))
+}
+
+// DeleteTagHandler deletes a tag. The links keep their other tags.
+func (s *FiberServer) DeleteTagHandler(c *fiber.Ctx) error {
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return notLoggedIn(c)
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid tag id"})
+	}
+	return s.namedResult(c, "tag", s.db.DeleteTag(c.UserContext(), user.Id, id))
+}
+
+// TagAnalyticsHandler groups the user's clicks by tag. A link with several
+// tags counts towards each of them. from and to limit the clicks counted
+// and take the same formats as created_from and created_to on the link
+// list.
+func (s *FiberServer) TagAnalyticsHandler(c *fiber.Ctx) error {
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return notLoggedIn(c)
+	}
+	from, err := parseTimeParam(c.Query("from"), false)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "from must be a date or an RFC 3339 time"})
+	}
+	to, err := parseTimeParam(c.Query("to"), true)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "to must be a date or an RFC 3339 time"})
+	}
+
+	stats, err := s.db.GetTagClicks(c.UserContext(), user.Id, from, to)
+	if err != nil {
+		s.log(c).Error("fetching tag analytics", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	return c.JSON(stats)
+}
+
+func (s *FiberServer) ListFoldersHandler(c *fiber.Ctx) error {
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return notLoggedIn(c)
+	}
+	folders, err := s.db.GetFolders(c.UserContext(), user.Id)
+	if err != nil {
+		s.log(c).Error("listing folders", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	return c.JSON(folders)
+}
+
+func (s *FiberServer) CreateFolderHandler(c *fiber.Ctx) error {
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return notLoggedIn(c)
+	}
+	name, err := parseName(c)
+	if name == "" {
+		return err
+	}
+
+	folder := &types.Folder{UserId: user.Id, Name: name}
+	err = s.db.CreateFolder(c.UserContext(), folder)
+	if errors.Is(err, database.ErrNameTaken) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": err.Error()})
+	}
+	if err != nil {
+		s.log(c).Error("creating folder", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "something went wrong"})
+	}
+	return c.Status(fiber.StatusCreated).JSON(folder)
+}
+
+func (s *FiberServer) RenameFolderHandler(c *fiber.Ctx) error {
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return notLoggedIn(c)
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid folder id"})
+	}
+	name, err := parseName(c)
+	if name == "" {
+		return err
+	}
+	return s.namedResult(c, "folder", s.db.RenameFolder(c.UserContext(), user.Id, id, name))
+}
+
+// DeleteFolderHandler deletes a folder. Its links are kept, outside any
+// folder.
+func (s *FiberServer) DeleteFolderHandler(c *fiber.Ctx) error {
+	user, ok := s.sessionUser(c)
+	if !ok {
+		return notLoggedIn(c)
+	}
+	id, err := c.ParamsInt("id")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "invalid folder id"})
+	}
+	return s.namedResult(c, "folder", s.db.DeleteFolder(c.UserContext(), user.Id, id))
+}
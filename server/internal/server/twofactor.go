@@ -0,0 +1,379 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/koderkt/teenyurl/internal/auth"
+	"github.com/koderkt/teenyurl/internal/metrics"
+	"github.com/koderkt/teenyurl/internal/types"
+	"github.com/redis/go-redis/v9"
+	"github.com/skip2/go-qrcode"
+)
+
+// Second sign-in steps. A user with 2FA enabled must verify a code; a user
+// without it, while 2FA is required, must enroll first.
+const (
+	challengeVerify = "verify"
+	challengeSetup  = "setup"
+
+	maxChallengeAttempts = 5
+)
+
+var (
+	errInvalidTwoFactorCode = errors.New("invalid two factor code")
+	errNotEnrolling         = errors.New("two factor setup has not been started")
+)
+
+// twoFactorChallenge is the state of a sign-in waiting for its second step.
+type twoFactorChallenge struct {
+	id      string
+	kind    string
+	session types.UserSession
+}
+
+// completeSignIn finishes a sign-in whose every factor has been checked.
+func (s *FiberServer) completeSignIn(c *fiber.Ctx, attempt *types.LoginAttempt, userSession types.UserSession, recoveryCodes []string) error {
+	if err := s.lockout.Reset(c.UserContext(), attempt.Email); err != nil {
+		s.log(c).Warn("resetting sign in lockout", "error", err)
+	}
+	attempt.Success = true
+	attempt.Reason = ""
+	s.recordLoginAttempt(c, attempt)
+	s.metrics.SignIn(metrics.SignInSuccess)
+	return s.issueSession(c, userSession, recoveryCodes)
+}
+
+// secondFactor returns the step a user must complete after their password,
+// or "" if there is none.
+func (s *FiberServer) secondFactor(ctx context.Context, userId int) (string, error) {
+	totp, err := s.db.GetTOTP(ctx, userId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+	switch {
+	case totp != nil && totp.EnabledAt != nil:
+		return challengeVerify, nil
+	case s.cfg.TwoFactor.Required:
+		return challengeSetup, nil
+	default:
+		return "", nil
+	}
+}
+
+// startChallenge answers a correct password with the second step instead
+// of a session. The lockout is only reset once that step is passed.
+func (s *FiberServer) startChallenge(c *fiber.Ctx, kind string, userSession types.UserSession) error {
+	id := uuid.NewString()
+	session, err := json.Marshal(userSession)
+	if err != nil {
+		return s.twoFactorFailed(c, "encoding session", err)
+	}
+
+	key := "2fa_challenge:" + id
+	pipe := s.redisClient.TxPipeline()
+	pipe.HSet(c.UserContext(), key, "kind", kind, "session", session)
+	pipe.Expire(c.UserContext(), key, s.cfg.TwoFactor.ChallengeTTL)
+	if _, err := pipe.Exec(c.UserContext()); err != nil {
+		return s.twoFactorFailed(c, "storing two factor challenge", err)
+	}
+
+	return c.JSON(fiber.Map{
+		"success":    false,
+		"two_factor": kind,
+		"challenge":  id,
+		"expires_in": int(s.cfg.TwoFactor.ChallengeTTL.Seconds()),
+	})
+}
+
+// loadChallenge fetches the challenge named in the request and counts the
+// attempt against it. On failure it writes the response and returns nil.
+func (s *FiberServer) loadChallenge(c *fiber.Ctx, req *types.TwoFactorChallengeRequest, kinds ...string) (*twoFactorChallenge, error) {
+	if err := c.BodyParser(req); err != nil || req.Challenge == "" {
+		return nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "challenge is required"})
+	}
+
+	ctx := c.UserContext()
+	key := "2fa_challenge:" + req.Challenge
+	fields, err := s.redisClient.HGetAll(ctx, key).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, s.twoFactorFailed(c, "loading two factor challenge", err)
+	}
+	if !slices.Contains(kinds, fields["kind"]) {
+		return nil, c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "invalid or expired challenge, sign in again"})
+	}
+
+	attempts, err := s.redisClient.HIncrBy(ctx, key, "attempts", 1).Result()
+	if err != nil {
+		return nil, s.twoFactorFailed(c, "counting two factor attempt", err)
+	}
+	if attempts > maxChallengeAttempts {
+		s.redisClient.Del(ctx, key)
+		return nil, c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": "invalid or expired challenge, sign in again"})
+	}
+
+	challenge := &twoFactorChallenge{id: req.Challenge, kind: fields["kind"]}
+	if err := json.Unmarshal([]byte(fields["session"]), &challenge.session); err != nil {
+		return nil, s.twoFactorFailed(c, "decoding two factor challenge", err)
+	}
+	return challenge, nil
+}
+
+// TwoFactorSignInHandler is the second sign-in step. It takes a code from
+// the authenticator app or a recovery code. For a setup challenge the code
+// confirms the enrollment and the recovery codes come back with the
+// session.
+func (s *FiberServer) TwoFactorSignInHandler(c *fiber.Ctx) error {
+	req := new(types.TwoFactorChallengeRequest)
+	challenge, err := s.loadChallenge(c, req, challengeVerify, challengeSetup)
+	if challenge == nil {
+		return err
+	}
+
+	ctx := c.UserContext()
+	userId := challenge.session.Id
+	attempt := &types.LoginAttempt{
+		UserId:    &userId,
+		Email:     auth.NormalizeEmail(challenge.session.Email),
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+	}
+	lockedFor, err := s.lockout.Locked(ctx, attempt.Email)
+	if err != nil {
+		s.log(c).Warn("checking sign in lockout", "error", err)
+	}
+	if lockedFor > 0 {
+		attempt.Reason = "locked"
+		s.recordLoginAttempt(c, attempt)
+		s.metrics.SignIn(metrics.SignInFailure)
+		return signInLocked(c, lockedFor)
+	}
+
+	var recoveryCodes []string
+	if challenge.kind == challengeSetup {
+		recoveryCodes, err = s.confirmEnrollment(ctx, userId, req.Code)
+	} else {
+		err = s.checkSecondFactor(ctx, userId, req.Code)
+	}
+	if errors.Is(err, errInvalidTwoFactorCode) || errors.Is(err, errNotEnrolling) {
+		attempt.Reason = "invalid_2fa_code"
+		s.recordLoginAttempt(c, attempt)
+		s.metrics.SignIn(metrics.SignInFailure)
+		if _, err := s.lockout.Fail(ctx, attempt.Email); err != nil {
+			s.log(c).Warn("recording failed sign in", "error", err)
+		}
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": err.Error()})
+	}
+	if err != nil {
+		return s.twoFactorFailed(c, "checking two factor code", err)
+	}
+
+	s.redisClient.Del(ctx, "2fa_challenge:"+challenge.id)
+	return s.completeSignIn(c, attempt, challenge.session, recoveryCodes)
+}
+
+// TwoFactorSignInSetupHandler starts enrollment for a user who must set up
+// 2FA before their sign-in can complete.
+func (s *FiberServer) TwoFactorSignInSetupHandler(c *fiber.Ctx) error {
+	challenge, err := s.loadChallenge(c, new(types.TwoFactorChallengeRequest), challengeSetup)
+	if challenge == nil {
+		return err
+	}
+	return s.beginEnrollment(c, challenge.session)
+}
+
+// TwoFactorStatusHandler reports whether the current user has 2FA on.
+func (s *FiberServer) TwoFactorStatusHandler(c *fiber.Ctx) error {
+	user, err := s.accountUser(c)
+	if user == nil {
+		return err
+	}
+	totp, err := s.db.GetTOTP(c.UserContext(), user.Id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return s.twoFactorFailed(c, "fetching two factor status", err)
+	}
+	return c.JSON(fiber.Map{
+		"enabled":  totp != nil && totp.EnabledAt != nil,
+		"required": s.cfg.TwoFactor.Required,
+	})
+}
+
+// TwoFactorSetupHandler starts enrollment for the current user.
+func (s *FiberServer) TwoFactorSetupHandler(c *fiber.Ctx) error {
+	user, err := s.accountUser(c)
+	if user == nil {
+		return err
+	}
+	return s.beginEnrollment(c, *user)
+}
+
+// TwoFactorEnableHandler confirms enrollment with a first code and returns
+// the recovery codes, which are not shown again.
+func (s *FiberServer) TwoFactorEnableHandler(c *fiber.Ctx) error {
+	user, req, err := s.twoFactorAccountRequest(c)
+	if user == nil {
+		return err
+	}
+	codes, err := s.confirmEnrollment(c.UserContext(), user.Id, req.Code)
+	if errors.Is(err, errInvalidTwoFactorCode) || errors.Is(err, errNotEnrolling) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": err.Error()})
+	}
+	if err != nil {
+		return s.twoFactorFailed(c, "enabling two factor", err)
+	}
+	return c.JSON(fiber.Map{"recovery_codes": codes})
+}
+
+// TwoFactorDisableHandler turns 2FA off. It needs a current code, and is
+// refused while 2FA is required.
+func (s *FiberServer) TwoFactorDisableHandler(c *fiber.Ctx) error {
+	user, req, err := s.twoFactorAccountRequest(c)
+	if user == nil {
+		return err
+	}
+	if s.cfg.TwoFactor.Required {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"message": "two factor authentication is required"})
+	}
+	if err := s.checkSecondFactor(c.UserContext(), user.Id, req.Code); err != nil {
+		return s.twoFactorCodeFailed(c, err)
+	}
+	if err := s.db.DeleteTOTP(c.UserContext(), user.Id); err != nil {
+		return s.twoFactorFailed(c, "disabling two factor", err)
+	}
+	return c.JSON(fiber.Map{"message": "two factor authentication disabled"})
+}
+
+// RecoveryCodesHandler replaces the user's recovery codes with new ones.
+func (s *FiberServer) RecoveryCodesHandler(c *fiber.Ctx) error {
+	user, req, err := s.twoFactorAccountRequest(c)
+	if user == nil {
+		return err
+	}
+	if err := s.checkSecondFactor(c.UserContext(), user.Id, req.Code); err != nil {
+		return s.twoFactorCodeFailed(c, err)
+	}
+	codes, hashes, err := auth.GenerateRecoveryCodes(s.cfg.TwoFactor.RecoveryCodes)
+	if err != nil {
+		return s.twoFactorFailed(c, "generating recovery codes", err)
+	}
+	if err := s.db.ReplaceRecoveryCodes(c.UserContext(), user.Id, hashes); err != nil {
+		return s.twoFactorFailed(c, "storing recovery codes", err)
+	}
+	return c.JSON(fiber.Map{"recovery_codes": codes})
+}
+
+func (s *FiberServer) twoFactorAccountRequest(c *fiber.Ctx) (*types.UserSession, *types.TwoFactorCodeRequest, error) {
+	user, err := s.accountUser(c)
+	if user == nil {
+		return nil, nil, err
+	}
+	req := new(types.TwoFactorCodeRequest)
+	if err := c.BodyParser(req); err != nil || req.Code == "" {
+		return nil, nil, c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"message": "code is required"})
+	}
+	return user, req, nil
+}
+
+// beginEnrollment creates a pending secret and returns it with its
+// provisioning URI and a QR code of that URI.
+func (s *FiberServer) beginEnrollment(c *fiber.Ctx, user types.UserSession) error {
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return s.twoFactorFailed(c, "generating totp secret", err)
+	}
+	err = s.db.SaveTOTPSecret(c.UserContext(), user.Id, secret)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"message": "two factor authentication is already enabled"})
+	}
+	if err != nil {
+		return s.twoFactorFailed(c, "storing totp secret", err)
+	}
+
+	uri := auth.TOTPURI(s.cfg.TwoFactor.Issuer, user.Email, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		return s.twoFactorFailed(c, "rendering qr code", err)
+	}
+	return c.JSON(fiber.Map{
+		"secret": secret,
+		"uri":    uri,
+		"qr":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+	})
+}
+
+// confirmEnrollment enables the pending secret if code matches it, and
+// returns the new recovery codes.
+func (s *FiberServer) confirmEnrollment(ctx context.Context, userId int, code string) ([]string, error) {
+	totp, err := s.db.GetTOTP(ctx, userId)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && totp.EnabledAt != nil) {
+		return nil, errNotEnrolling
+	}
+	if err != nil {
+		return nil, err
+	}
+	step, ok := auth.ValidateTOTP(totp.Secret, code, time.Now())
+	if !ok {
+		return nil, errInvalidTwoFactorCode
+	}
+
+	codes, hashes, err := auth.GenerateRecoveryCodes(s.cfg.TwoFactor.RecoveryCodes)
+	if err != nil {
+		return nil, err
+	}
+	err = s.db.EnableTOTP(ctx, userId, step, hashes)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errNotEnrolling
+	}
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// checkSecondFactor accepts either a current authenticator code, which
+// can't be used twice, or an unused recovery code, which is then spent.
+func (s *FiberServer) checkSecondFactor(ctx context.Context, userId int, code string) error {
+	if !auth.IsTOTPCode(code) {
+		err := s.db.UseRecoveryCode(ctx, userId, auth.HashRecoveryCode(code))
+		if errors.Is(err, sql.ErrNoRows) {
+			return errInvalidTwoFactorCode
+		}
+		return err
+	}
+
+	totp, err := s.db.GetTOTP(ctx, userId)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && totp.EnabledAt == nil) {
+		return errInvalidTwoFactorCode
+	}
+	if err != nil {
+		return err
+	}
+	step, ok := auth.ValidateTOTP(totp.Secret, code, time.Now())
+	if !ok {
+		return errInvalidTwoFactorCode
+	}
+	err = s.db.UseTOTPStep(ctx, userId, step)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errInvalidTwoFactorCode
+	}
+	return err
+}
+
+func (s *FiberServer) twoFactorCodeFailed(c *fiber.Ctx, err error) error {
+	if errors.Is(err, errInvalidTwoFactorCode) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"message": err.Error()})
+	}
+	return s.twoFactorFailed(c, "checking two factor code", err)
+}
+
+func (s *FiberServer) twoFactorFailed(c *fiber.Ctx, msg string, err error) error {
+	s.log(c).Error(msg, "error", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"message": "internal server error"})
+}
@@ -1,14 +1,19 @@
 package types
 
-import "time"
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
 
 type User struct {
 	ID       int    `db:"id"`
 	UserName string `db:"user_name"`
 
-	Email             string    `db:"email"`
-	EncryptedPassword string    `db:"encrypted_password"`
-	CreatedAt         time.Time `db:"created_at"`
+	Email             string     `db:"email"`
+	EncryptedPassword string     `db:"encrypted_password"`
+	CreatedAt         time.Time  `db:"created_at"`
+	EmailVerifiedAt   *time.Time `db:"email_verified_at"`
 }
 
 type CreateUserRequest struct {
@@ -33,16 +38,140 @@ type ErrorResponse struct {
 }
 
 type ShortenRequest struct {
-	LongUrl string `json:"long_url" validate:"required,long_url"`
+	LongUrl  string   `json:"long_url" validate:"required,long_url"`
+	Title    string   `json:"title"`
+	Notes    string   `json:"notes"`
+	Tags     []string `json:"tags"`
+	FolderId *int     `json:"folder_id"`
+	Preview  bool     `json:"preview"`
+	// RedirectStatus is 301, 302, 307 or 308. 0 uses the server default.
+	RedirectStatus int `json:"redirect_status"`
 }
 
 type Link struct {
-	Id          int       `json:"id" db:"id"`
-	OriginalURL string    `json:"original_url" db:"original_url"`
-	ShortURL    string    `json:"short_url" db:"short_url"`
-	CreatedAt   time.Time `json:"created_at" db:"created_at"`
-	UserId      int       `json:"user_id" db:"user_id"`
-	IsEnabled   bool      `json:"is_enabled" db:"is_enabled"`
+	Id          int        `json:"id" db:"id"`
+	OriginalURL string     `json:"original_url" db:"original_url"`
+	ShortURL    string     `json:"short_url" db:"short_url"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	UserId      int        `json:"user_id" db:"user_id"`
+	IsEnabled   bool       `json:"is_enabled" db:"is_enabled"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	// Domain is the lowercased host of OriginalURL, kept by Postgres.
+	Domain       string `json:"domain" db:"domain"`
+	FolderId     *int   `json:"folder_id" db:"folder_id"`
+	Title        string `json:"title" db:"title"`
+	Notes        string `json:"notes" db:"notes"`
+	LinkMetadata `json:"metadata"`
+	// FlaggedAt is set when a scanner found the destination malicious.
+	// Flagged links are disabled and show a warning instead of redirecting.
+	FlaggedAt  *time.Time `json:"flagged_at,omitempty" db:"flagged_at"`
+	FlagReason string     `json:"flag_reason,omitempty" db:"flag_reason"`
+	ScannedAt  *time.Time `json:"-" db:"scanned_at"`
+	// Preview shows visitors a preview page instead of redirecting.
+	Preview bool `json:"preview" db:"preview"`
+	// RedirectStatus is the HTTP status visitors are redirected with. 0
+	// uses the server default.
+	RedirectStatus int `json:"redirect_status" db:"redirect_status"`
+}
+
+// LinkMetadata describes a link's destination page, as fetched from it.
+type LinkMetadata struct {
+	Title       string     `json:"title" db:"meta_title"`
+	Description string     `json:"description" db:"meta_description"`
+	Image       string     `json:"image" db:"meta_image"`
+	Favicon     string     `json:"favicon" db:"meta_favicon"`
+	FetchedAt   *time.Time `json:"fetched_at" db:"metadata_fetched_at"`
+}
+
+// Tag labels links. A link can have many tags. Names are unique per user,
+// ignoring case.
+type Tag struct {
+	Id        int       `json:"id" db:"id"`
+	UserId    int       `json:"-" db:"user_id"`
+	Name      string    `json:"name" db:"name"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	// Links counts the live links with the tag.
+	Links int `json:"links" db:"links"`
+}
+
+// Folder holds links. A link is in at most one folder.
+type Folder struct {
+	Id        int       `json:"id" db:"id"`
+	UserId    int       `json:"-" db:"user_id"`
+	Name      string    `json:"name" db:"name"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	Links     int       `json:"links" db:"links"`
+}
+
+type NameRequest struct {
+	Name string `json:"name" validate:"required,max=50"`
+}
+
+// TagClicks is the analytics of one tag.
+type TagClicks struct {
+	Tag    string `json:"tag" db:"tag"`
+	Links  int    `json:"links" db:"links"`
+	Clicks int    `json:"clicks" db:"clicks"`
+}
+
+// BulkLinkRequest changes several links at once. FolderId moves them,
+// with 0 meaning no folder.
+type BulkLinkRequest struct {
+	Codes      []string `json:"codes"`
+	AddTags    []string `json:"add_tags"`
+	RemoveTags []string `json:"remove_tags"`
+	FolderId   *int     `json:"folder_id"`
+}
+
+// Sort orders accepted by LinkQuery.
+const (
+	LinkSortCreated     = "created"
+	LinkSortClicks      = "clicks"
+	LinkSortDestination = "destination"
+)
+
+// LinkQuery selects one page of a user's links.
+type LinkQuery struct {
+	UserId int
+	Sort   string
+	Desc   bool
+	Limit  int
+	// After continues from the last link of the previous page.
+	After       *LinkCursor
+	Enabled     *bool
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	// Domain matches the destination host and its subdomains.
+	Domain string
+	// Tag matches links with a tag of that name.
+	Tag string
+	// FolderId matches links in that folder, or in none when it is 0.
+	FolderId *int
+	// Search matches a substring of the destination or the short code.
+	Search string
+	// DeletedSince lists the links deleted after it instead of live ones.
+	DeletedSince *time.Time
+}
+
+// LinkCursor marks a position in a sorted list of links: the sort value of
+// the last link seen, and its id to break ties.
+type LinkCursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v"`
+	Id    int    `json:"i"`
+}
+
+// LinkStats is a link with its click count.
+type LinkStats struct {
+	Link
+	Clicks int            `db:"clicks"`
+	Tags   pq.StringArray `db:"tags"`
+}
+
+type LinkPage struct {
+	Links      []LinkResponse `json:"links"`
+	NextCursor string         `json:"next_cursor,omitempty"`
 }
 
 type CreateShortURLResponse struct {
@@ -61,9 +190,142 @@ type Clicks struct {
 
 type LinkResponse struct {
 	Id          int       `json:"id" db:"id"`
+	ShortCode   string    `json:"short_code"`
 	OriginalURL string    `json:"original_url" db:"original_url"`
 	ShortURL    string    `json:"short_url" db:"short_url"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 	IsEnabled   bool      `json:"is_enabled" db:"is_enabled"`
-	Clicks int `json:"clicks"`
+	Clicks      int       `json:"clicks"`
+	// Title is the user's title, or else the one fetched from the page.
+	Title      string        `json:"title"`
+	Notes      string        `json:"notes"`
+	Metadata   *LinkMetadata `json:"metadata,omitempty"`
+	Tags       []string      `json:"tags"`
+	FolderId   *int          `json:"folder_id"`
+	DeletedAt  *time.Time    `json:"deleted_at,omitempty"`
+	FlaggedAt  *time.Time    `json:"flagged_at,omitempty"`
+	FlagReason string        `json:"flag_reason,omitempty"`
+	Preview    bool          `json:"preview"`
+	// RedirectStatus is the status the link redirects with, its own or
+	// the server default.
+	RedirectStatus int `json:"redirect_status"`
+	// RestorableUntil is set on deleted links.
+	RestorableUntil *time.Time `json:"restorable_until,omitempty"`
+}
+
+// UpdateLinkRequest is the body of PATCH /api/v1/links/:shortCode. Fields
+// left out are not changed.
+type UpdateLinkRequest struct {
+	OriginalURL *string `json:"original_url"`
+	Title       *string `json:"title"`
+	Notes       *string `json:"notes"`
+	// Tags replaces all of the link's tags.
+	Tags *[]string `json:"tags"`
+	// FolderId moves the link, with 0 meaning no folder.
+	FolderId *int  `json:"folder_id"`
+	Preview  *bool `json:"preview"`
+	// RedirectStatus 0 goes back to the server default.
+	RedirectStatus *int `json:"redirect_status"`
+}
+
+type LinkStatusRequest struct {
+	Enabled *bool `json:"enabled" validate:"required"`
+}
+
+type LoginAttempt struct {
+	Id        int       `json:"id" db:"id"`
+	UserId    *int      `json:"-" db:"user_id"`
+	Email     string    `json:"-" db:"email"`
+	IP        string    `json:"ip" db:"ip"`
+	UserAgent string    `json:"user_agent" db:"user_agent"`
+	Success   bool      `json:"success" db:"success"`
+	Reason    string    `json:"reason,omitempty" db:"reason"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// APIKey is a personal access key. Only the SHA-256 of the key is stored;
+// Prefix is kept so users can tell their keys apart.
+type APIKey struct {
+	Id         int            `json:"id" db:"id"`
+	UserId     int            `json:"-" db:"user_id"`
+	Name       string         `json:"name" db:"name"`
+	Prefix     string         `json:"prefix" db:"prefix"`
+	KeyHash    string         `json:"-" db:"key_hash"`
+	Scopes     pq.StringArray `json:"scopes" db:"scopes"`
+	CreatedAt  time.Time      `json:"created_at" db:"created_at"`
+	LastUsedAt *time.Time     `json:"last_used_at" db:"last_used_at"`
+}
+
+// APIKeyOwner is an API key joined with the user it belongs to.
+type APIKeyOwner struct {
+	APIKey
+	UserName string `db:"user_name"`
+	Email    string `db:"email"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name" validate:"required,max=100"`
+	Scopes []string `json:"scopes" validate:"required,min=1"`
+}
+
+type EmailRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type TokenRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+// UserIdentity links a user to their account at an OIDC provider.
+type UserIdentity struct {
+	Provider  string    `json:"provider" db:"provider"`
+	Subject   string    `json:"-" db:"subject"`
+	UserId    int       `json:"-" db:"user_id"`
+	Email     string    `json:"email" db:"email"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// TOTP is a user's authenticator secret. It is pending until EnabledAt is
+// set by confirming a first code.
+type TOTP struct {
+	UserId       int        `db:"user_id"`
+	Secret       string     `db:"secret"`
+	EnabledAt    *time.Time `db:"enabled_at"`
+	LastUsedStep int64      `db:"last_used_step"`
+	CreatedAt    time.Time  `db:"created_at"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type TwoFactorChallengeRequest struct {
+	Challenge string `json:"challenge" validate:"required"`
+	Code      string `json:"code"`
+}
+
+// Session is what is kept in Redis for a signed-in session. Sessions
+// stored before the extra fields existed decode with them zero.
+type Session struct {
+	UserSession
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+}
+
+// ActiveSession describes a session to its owner. Id is a handle derived
+// from the session ID, which is a bearer credential and never shown.
+type ActiveSession struct {
+	Id         string    `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	Current    bool      `json:"current"`
 }
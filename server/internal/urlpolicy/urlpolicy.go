@@ -0,0 +1,232 @@
+// Package urlpolicy decides which destinations short links may point to.
+package urlpolicy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/koderkt/teenyurl/internal/config"
+	"github.com/koderkt/teenyurl/internal/netguard"
+)
+
+// Every error returned by Check wraps one of these. Their messages are
+// meant to be shown to the user.
+var (
+	ErrInvalidURL       = errors.New("invalid url")
+	ErrScheme           = errors.New("url scheme is not allowed")
+	ErrCredentials      = errors.New("urls with a username or password are not allowed")
+	ErrDomain           = errors.New("destination domain is not allowed")
+	ErrPrivateAddress   = errors.New("destination is not a public address")
+	ErrUnresolvable     = errors.New("destination host does not resolve")
+	ErrRedirectLoop     = errors.New("destination leads back to a short link")
+	ErrTooManyRedirects = errors.New("destination redirects too many times")
+)
+
+// Resolver looks up the addresses of a host. *net.Resolver implements it.
+type Resolver interface {
+	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+}
+
+// Policy checks destinations against the configured rules.
+type Policy struct {
+	cfg        config.URLPolicy
+	schemes    map[string]bool
+	shortHosts []string
+	resolver   Resolver
+	client     *http.Client
+}
+
+// New builds a policy. baseURL is the public origin of short links, whose
+// host is treated as one of ours. A nil resolver uses the system one.
+func New(cfg config.URLPolicy, baseURL string, resolver Resolver) *Policy {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	p := &Policy{
+		cfg:      cfg,
+		schemes:  make(map[string]bool, len(cfg.Schemes)),
+		resolver: resolver,
+	}
+	for _, scheme := range cfg.Schemes {
+		p.schemes[strings.ToLower(scheme)] = true
+	}
+	for _, host := range cfg.ShortDomains {
+		p.shortHosts = append(p.shortHosts, normalizeHost(host))
+	}
+	if u, err := url.Parse(baseURL); err == nil && u.Hostname() != "" {
+		p.shortHosts = append(p.shortHosts, normalizeHost(u.Hostname()))
+	}
+
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if cfg.BlockPrivateNetworks {
+		dialer.Control = netguard.Control
+	}
+	p.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   cfg.Timeout,
+			ResponseHeaderTimeout: cfg.Timeout,
+		},
+		// redirects are followed by hand so every hop is checked
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return p
+}
+
+// Check reports whether links may point to raw. shortHosts adds hostnames
+// serving short links to the configured ones, typically the host the
+// request came in on.
+func (p *Policy) Check(ctx context.Context, raw string, shortHosts ...string) error {
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+
+	hosts := make([]string, len(shortHosts))
+	for i, host := range shortHosts {
+		hosts[i] = normalizeHost(host)
+	}
+	u, err := p.check(ctx, raw, hosts)
+	if err != nil {
+		return err
+	}
+	if !p.cfg.FollowRedirects {
+		return nil
+	}
+	return p.followRedirects(ctx, u, hosts)
+}
+
+func (p *Policy) check(ctx context.Context, raw string, shortHosts []string) (*url.URL, error) {
+	u, err := url.ParseRequestURI(raw)
+	if err != nil {
+		return nil, ErrInvalidURL
+	}
+	if !p.schemes[u.Scheme] {
+		return nil, fmt.Errorf("%w: %q", ErrScheme, u.Scheme)
+	}
+	host := normalizeHost(u.Hostname())
+	if host == "" {
+		return nil, ErrInvalidURL
+	}
+	if u.User != nil {
+		return nil, ErrCredentials
+	}
+	if slices.Contains(p.shortHosts, host) || slices.Contains(shortHosts, host) {
+		return nil, ErrRedirectLoop
+	}
+	if matchDomain(host, p.cfg.DenyDomains) ||
+		p.cfg.AllowMode != "warn" && !p.trustedHost(host) {
+		return nil, fmt.Errorf("%w: %s", ErrDomain, host)
+	}
+	if p.cfg.BlockPrivateNetworks {
+		if err := p.checkAddresses(ctx, host); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// Trusted reports whether raw points inside the allowed domains, which
+// is always the case when there are none. In warn mode visitors of links
+// that don't are shown a preview first.
+func (p *Policy) Trusted(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return p.trustedHost(normalizeHost(u.Hostname()))
+}
+
+func (p *Policy) trustedHost(host string) bool {
+	return len(p.cfg.AllowDomains) == 0 || matchDomain(host, p.cfg.AllowDomains)
+}
+
+// checkAddresses refuses hosts that are, or resolve to, addresses that are
+// not public. Every address is checked since any of them may be used.
+func (p *Policy) checkAddresses(ctx context.Context, host string) error {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if !netguard.IsPublic(ip) {
+			return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+		}
+		return nil
+	}
+	addrs, err := p.resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("%w: %s", ErrUnresolvable, host)
+	}
+	for _, ip := range addrs {
+		if !netguard.IsPublic(ip) {
+			return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+		}
+	}
+	return nil
+}
+
+// followRedirects asks the destination where it leads and checks each hop
+// like the destination itself. Destinations that can't be reached are let
+// through: only redirects we actually see are judged.
+func (p *Policy) followRedirects(ctx context.Context, u *url.URL, shortHosts []string) error {
+	for hop := 0; ; hop++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.String(), nil)
+		if err != nil {
+			return ErrInvalidURL
+		}
+		resp, err := p.client.Do(req)
+		if err != nil {
+			if errors.Is(err, netguard.ErrBlockedAddress) {
+				return fmt.Errorf("%w: %s", ErrPrivateAddress, u.Hostname())
+			}
+			return nil
+		}
+		resp.Body.Close()
+
+		location := resp.Header.Get("Location")
+		if resp.StatusCode < 300 || resp.StatusCode > 399 || location == "" {
+			return nil
+		}
+		if hop == p.cfg.MaxRedirects {
+			return ErrTooManyRedirects
+		}
+		next, err := u.Parse(location)
+		if err != nil {
+			return fmt.Errorf("destination redirects to an %w", ErrInvalidURL)
+		}
+		if u, err = p.check(ctx, next.String(), shortHosts); err != nil {
+			return fmt.Errorf("destination redirects to %s: %w", next.Redacted(), err)
+		}
+	}
+}
+
+// normalizeHost lower-cases host and drops any port, brackets and
+// trailing dot.
+func normalizeHost(host string) string {
+	host = strings.TrimSpace(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// matchDomain reports whether host is one of domains or a subdomain of
+// one.
+func matchDomain(host string, domains []string) bool {
+	for _, domain := range domains {
+		domain = normalizeHost(domain)
+		if domain == "" {
+			continue
+		}
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+// Package version exposes build metadata. The variables are set at link time:
+//
+//	go build -ldflags "-X github.com/koderkt/teenyurl/internal/version.Version=v1.2.3"
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildTime = ""
+)
+
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build metadata, falling back to the VCS information
+// embedded by the Go toolchain when Commit was not set via ldflags.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+	if info.Commit == "" {
+		if bi, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range bi.Settings {
+				switch setting.Key {
+				case "vcs.revision":
+					info.Commit = setting.Value
+				case "vcs.time":
+					if info.BuildTime == "" {
+						info.BuildTime = setting.Value
+					}
+				}
+			}
+		}
+	}
+	return info
+}
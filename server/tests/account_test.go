@@ -0,0 +1,268 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koderkt/teenyurl/internal/auth"
+	"github.com/koderkt/teenyurl/internal/config"
+	"github.com/koderkt/teenyurl/internal/mail"
+	"github.com/koderkt/teenyurl/internal/types"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func accountTokens(t *testing.T) *auth.AccountTokens {
+	tokens, ok, err := auth.NewAccountTokens(config.Account{
+		TokenSecret:      "account-secret-account-secret-000",
+		VerificationTTL:  time.Hour,
+		PasswordResetTTL: time.Hour,
+	})
+	if err != nil || !ok {
+		t.Fatalf("error creating account tokens. Err: %v", err)
+	}
+	return tokens
+}
+
+func TestAccountTokenRoundTrip(t *testing.T) {
+	tokens := accountTokens(t)
+	stamp := auth.PasswordStamp("$2a$10$hash")
+	token := tokens.Sign(auth.PurposeResetPassword, 42, stamp)
+
+	id, err := auth.UserId(token)
+	if err != nil || id != 42 {
+		t.Fatalf("expected user 42; got %d (%v)", id, err)
+	}
+	if err := tokens.Verify(auth.PurposeResetPassword, token, stamp); err != nil {
+		t.Errorf("expected token to verify; got %v", err)
+	}
+}
+
+func TestAccountTokenRejections(t *testing.T) {
+	tokens := accountTokens(t)
+	token := tokens.Sign(auth.PurposeVerifyEmail, 1, "someone@example.com")
+
+	cases := map[string]func() error{
+		"wrong purpose": func() error {
+			return tokens.Verify(auth.PurposeResetPassword, token, "someone@example.com")
+		},
+		"changed stamp": func() error {
+			return tokens.Verify(auth.PurposeVerifyEmail, token, "other@example.com")
+		},
+		"other user": func() error {
+			return tokens.Verify(auth.PurposeVerifyEmail, "2"+token[1:], "someone@example.com")
+		},
+		"other secret": func() error {
+			other, _, _ := auth.NewAccountTokens(config.Account{VerificationTTL: time.Hour, PasswordResetTTL: time.Hour})
+			return other.Verify(auth.PurposeVerifyEmail, token, "someone@example.com")
+		},
+		"garbage": func() error {
+			return tokens.Verify(auth.PurposeVerifyEmail, "nope", "someone@example.com")
+		},
+	}
+	for name, verify := range cases {
+		if err := verify(); !errors.Is(err, auth.ErrInvalidAccountToken) {
+			t.Errorf("%s: expected ErrInvalidAccountToken; got %v", name, err)
+		}
+	}
+}
+
+func TestAccountTokenExpires(t *testing.T) {
+	tokens, _, err := auth.NewAccountTokens(config.Account{
+		TokenSecret:      "account-secret-account-secret-000",
+		VerificationTTL:  -time.Second,
+		PasswordResetTTL: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("error creating account tokens. Err: %v", err)
+	}
+	token := tokens.Sign(auth.PurposeVerifyEmail, 1, "someone@example.com")
+	if err := tokens.Verify(auth.PurposeVerifyEmail, token, "someone@example.com"); !errors.Is(err, auth.ErrInvalidAccountToken) {
+		t.Errorf("expected expired token to fail; got %v", err)
+	}
+}
+
+func TestOutboxMailer(t *testing.T) {
+	dir := t.TempDir()
+	mailer, err := mail.New(config.Mail{Driver: "outbox", OutboxDir: dir, From: "teenyurl <no-reply@example.com>"}, nil)
+	if err != nil {
+		t.Fatalf("error creating mailer. Err: %v", err)
+	}
+
+	err = mailer.Send(context.Background(), mail.Message{
+		To:      "someone@example.com",
+		Subject: "Hello\r\nBcc: evil@example.com",
+		Body:    "line one\nline two",
+	})
+	if err != nil {
+		t.Fatalf("error sending mail. Err: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected one message in the outbox; got %d", len(files))
+	}
+	raw, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("error reading message. Err: %v", err)
+	}
+	msg := string(raw)
+	if !strings.Contains(msg, "To: someone@example.com\r\n") {
+		t.Errorf("expected To header; got %q", msg)
+	}
+	if strings.Contains(msg, "\r\nBcc:") {
+		t.Errorf("expected header injection to be stripped; got %q", msg)
+	}
+	if !strings.HasSuffix(msg, "\r\n\r\nline one\r\nline two") {
+		t.Errorf("expected CRLF body; got %q", msg)
+	}
+}
+
+// newAccountServer starts a test server whose account tokens can be signed
+// by the test.
+func newAccountServer(t *testing.T) (*testServer, *auth.AccountTokens) {
+	ts := newTestServer(t, func(cfg *config.Config) {
+		cfg.Account.TokenSecret = accountSecret
+	})
+	tokens, _, err := auth.NewAccountTokens(ts.cfg.Account)
+	if err != nil {
+		t.Fatalf("error creating account tokens. Err: %v", err)
+	}
+	return ts, tokens
+}
+
+// waitForMails waits for n mails to be logged and returns them.
+func waitForMails(t *testing.T, ts *testServer, n int) []map[string]any {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mails := ts.logs.lines(t, "mail")
+		if len(mails) >= n || time.Now().After(deadline) {
+			return mails
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	ts, tokens := newAccountServer(t)
+	ts.store.addUser(&types.User{ID: 21, UserName: "someone", Email: "someone@example.com"})
+
+	cases := map[string]string{
+		"garbage":       "nope",
+		"other email":   tokens.Sign(auth.PurposeVerifyEmail, 21, "other@example.com"),
+		"wrong purpose": tokens.Sign(auth.PurposeResetPassword, 21, "someone@example.com"),
+		"unknown user":  tokens.Sign(auth.PurposeVerifyEmail, 99, "someone@example.com"),
+	}
+	for name, token := range cases {
+		resp, body := ts.do(t, "POST", "/verify-email", map[string]string{"token": token})
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected status Bad Request; got %v: %s", name, resp.Status, body)
+		}
+	}
+	if user, _ := ts.store.GetUserById(context.Background(), 21); user.EmailVerifiedAt != nil {
+		t.Fatal("expected the email to stay unverified")
+	}
+
+	token := tokens.Sign(auth.PurposeVerifyEmail, 21, "someone@example.com")
+	resp, body := ts.do(t, "POST", "/verify-email", map[string]string{"token": token})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status OK; got %v: %s", resp.Status, body)
+	}
+	if user, _ := ts.store.GetUserById(context.Background(), 21); user.EmailVerifiedAt == nil {
+		t.Error("expected the email to be verified")
+	}
+}
+
+func TestUnverifiedUserCannotCreateLinks(t *testing.T) {
+	ts, _ := newAccountServer(t)
+	user := types.UserSession{Id: 21, UserName: "someone", Email: "someone@example.com"}
+	ts.store.addUser(&types.User{ID: user.Id, UserName: user.UserName, Email: user.Email})
+	token := "Bearer " + ts.signIn(t, user)
+
+	resp, body := ts.do(t, "POST", "/api/v1/links", map[string]any{"long_url": "https://example.com/"}, "Authorization", token)
+	if resp.StatusCode != http.StatusForbidden || message(t, body) != "verify your email address first" {
+		t.Errorf("expected status Forbidden; got %v: %s", resp.Status, body)
+	}
+	if len(ts.store.links) != 0 {
+		t.Errorf("expected no link created; got %v", len(ts.store.links))
+	}
+
+	ts.store.MarkEmailVerified(context.Background(), user.Id, user.Email)
+	if resp, _ := createLink(t, ts, token, map[string]any{"long_url": "https://example.com/"}); resp.StatusCode != http.StatusCreated {
+		t.Errorf("expected status Created once verified; got %v", resp.Status)
+	}
+}
+
+func TestForgotPasswordAnswersTheSame(t *testing.T) {
+	ts, _ := newAccountServer(t)
+	ts.store.addUser(&types.User{ID: 21, UserName: "someone", Email: "someone@example.com", EncryptedPassword: "old"})
+
+	missingResp, missingBody := ts.do(t, "POST", "/password/forgot", map[string]string{"email": "nobody@example.com"})
+	resp, body := ts.do(t, "POST", "/password/forgot", map[string]string{"email": "someone@example.com"})
+	if resp.StatusCode != http.StatusAccepted || string(body) != string(missingBody) || missingResp.StatusCode != resp.StatusCode {
+		t.Errorf("expected the same answer for both; got %v: %s and %v: %s", resp.Status, body, missingResp.Status, missingBody)
+	}
+
+	mails := waitForMails(t, ts, 1)
+	if len(mails) != 1 || mails[0]["to"] != "someone@example.com" {
+		t.Fatalf("expected one mail to the account; got %v", mails)
+	}
+	if body, _ := mails[0]["body"].(string); !strings.Contains(body, "/reset-password?token=") {
+		t.Errorf("expected a reset link in the mail; got %q", body)
+	}
+
+	resp, _ = ts.do(t, "POST", "/password/forgot", map[string]string{"email": "not an email"})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status Bad Request for a bad email; got %v", resp.Status)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	ts, tokens := newAccountServer(t)
+	now := time.Now()
+	ts.store.addUser(&types.User{ID: 21, UserName: "someone", Email: "someone@example.com", EncryptedPassword: "old", EmailVerifiedAt: &now})
+	session := "Bearer " + ts.signIn(t, types.UserSession{Id: 21, UserName: "someone", Email: "someone@example.com"})
+
+	client := redis.NewClient(&redis.Options{Addr: ts.redis.Addr()})
+	defer client.Close()
+	lockout := auth.NewLockout(client, ts.cfg.Lockout)
+	ctx := context.Background()
+	for i := 0; i < ts.cfg.Lockout.Threshold; i++ {
+		lockout.Fail(ctx, "someone@example.com")
+	}
+	if locked, _ := lockout.Locked(ctx, "someone@example.com"); locked == 0 {
+		t.Fatal("expected the account to be locked out")
+	}
+
+	token := tokens.Sign(auth.PurposeResetPassword, 21, auth.PasswordStamp("old"))
+	resp, body := ts.do(t, "POST", "/password/reset", map[string]string{"token": token, "password": "weak"})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status Bad Request for a weak password; got %v: %s", resp.Status, body)
+	}
+	resp, body = ts.do(t, "POST", "/password/reset", map[string]string{"token": token, "password": "New-password1"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status OK; got %v: %s", resp.Status, body)
+	}
+
+	user, _ := ts.store.GetUserById(ctx, 21)
+	if bcrypt.CompareHashAndPassword([]byte(user.EncryptedPassword), []byte("New-password1")) != nil {
+		t.Error("expected the new password stored")
+	}
+	if resp, _ := ts.do(t, "GET", "/account/sessions", nil, "Authorization", session); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected the old session revoked; got %v", resp.Status)
+	}
+	if locked, _ := lockout.Locked(ctx, "someone@example.com"); locked != 0 {
+		t.Errorf("expected the lockout cleared; got %v", locked)
+	}
+
+	resp, body = ts.do(t, "POST", "/password/reset", map[string]string{"token": token, "password": "Other-password1"})
+	if resp.StatusCode != http.StatusBadRequest || message(t, body) != auth.ErrInvalidAccountToken.Error() {
+		t.Errorf("expected the token to stop working once the password changed; got %v: %s", resp.Status, body)
+	}
+}
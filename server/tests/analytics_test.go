@@ -0,0 +1,51 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/koderkt/teenyurl/internal/analytics"
+	"github.com/koderkt/teenyurl/internal/types"
+)
+
+func TestRecorderFlushesOnClose(t *testing.T) {
+	store := newFakeStore()
+	store.clickDelay = time.Millisecond
+	recorder := analytics.NewRecorder(store, 100, slog.Default())
+
+	for i := 0; i < 50; i++ {
+		if err := recorder.Record(context.Background(), types.Clicks{ShortCode: "abc123"}); err != nil {
+			t.Fatalf("error recording click. Err: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := recorder.Close(ctx); err != nil {
+		t.Fatalf("error closing recorder. Err: %v", err)
+	}
+	if store.clickCount() != 50 {
+		t.Errorf("expected 50 clicks to be flushed; got %v", store.clickCount())
+	}
+	if err := recorder.Record(context.Background(), types.Clicks{ShortCode: "abc123"}); !errors.Is(err, analytics.ErrRecorderClosed) {
+		t.Errorf("expected ErrRecorderClosed after close; got %v", err)
+	}
+}
+
+func TestRecorderCloseHonoursDeadline(t *testing.T) {
+	store := newFakeStore()
+	store.clickDelay = 50 * time.Millisecond
+	recorder := analytics.NewRecorder(store, 100, slog.Default())
+	for i := 0; i < 10; i++ {
+		recorder.Record(context.Background(), types.Clicks{ShortCode: "abc123"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := recorder.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded; got %v", err)
+	}
+}
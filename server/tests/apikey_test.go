@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/koderkt/teenyurl/internal/auth"
+	"github.com/koderkt/teenyurl/internal/types"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("error generating api key. Err: %v", err)
+	}
+	if !auth.IsAPIKey(key) {
+		t.Errorf("expected key to start with %q; got %q", auth.APIKeyPrefix, key)
+	}
+	if !strings.HasPrefix(key, prefix) || len(prefix) >= len(key) {
+		t.Errorf("expected %q to be a short prefix of the key", prefix)
+	}
+	if hash != auth.HashAPIKey(key) || strings.Contains(hash, key) {
+		t.Errorf("expected stored hash to be the key's hash; got %q", hash)
+	}
+
+	other, _, _, err := auth.GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("error generating api key. Err: %v", err)
+	}
+	if other == key {
+		t.Errorf("expected keys to be unique")
+	}
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	if err := auth.ValidateScopes([]string{auth.ScopeRead, auth.ScopeLinksWrite}); err != nil {
+		t.Errorf("expected known scopes to be valid; got %v", err)
+	}
+	if err := auth.ValidateScopes([]string{"links:delete"}); err == nil {
+		t.Errorf("expected unknown scope to be rejected")
+	}
+
+	granted := []string{auth.ScopeAnalyticsRead}
+	if !auth.HasScope(granted, auth.ScopeRead, auth.ScopeAnalyticsRead) {
+		t.Errorf("expected analytics:read to satisfy analytics access")
+	}
+	if auth.HasScope(granted, auth.ScopeLinksWrite) {
+		t.Errorf("expected analytics:read not to allow writing links")
+	}
+}
+
+func TestAPIKeyAuthenticatesRequests(t *testing.T) {
+	ts := newTestServer(t, nil)
+	signInVerified(t, ts, linkOwner)
+	ts.store.addLink(types.Link{ShortURL: "abc123", OriginalURL: "https://example.com/", UserId: linkOwner.Id, IsEnabled: true})
+	key := ts.store.addAPIKey(t, linkOwner, auth.ScopeRead, auth.ScopeLinksWrite)
+
+	for _, header := range [][]string{{"X-API-Key", key}, {"Authorization", "Bearer " + key}} {
+		resp, body := ts.do(t, "GET", "/api/v1/links/abc123", nil, header...)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: expected status OK; got %v: %s", header[0], resp.Status, body)
+		}
+	}
+	resp, body := ts.do(t, "POST", "/api/v1/links", map[string]any{"long_url": "https://example.org/"}, "X-API-Key", key)
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("expected a link created with the key; got %v: %s", resp.Status, body)
+	}
+
+	stored, err := ts.store.GetAPIKeyByHash(context.Background(), auth.HashAPIKey(key))
+	if err != nil {
+		t.Fatalf("error fetching api key. Err: %v", err)
+	}
+	if stored.LastUsedAt == nil {
+		t.Error("expected the key's use to be recorded")
+	}
+
+	unknown, _, _, _ := auth.GenerateAPIKey()
+	resp, _ = ts.do(t, "GET", "/api/v1/links/abc123", nil, "X-API-Key", unknown)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status Unauthorized for an unknown key; got %v", resp.Status)
+	}
+}
+
+func TestAPIKeyMissingScope(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.store.addLink(types.Link{ShortURL: "abc123", OriginalURL: "https://example.com/", UserId: linkOwner.Id, IsEnabled: true, Title: "Before"})
+	readOnly := ts.store.addAPIKey(t, linkOwner, auth.ScopeRead)
+	analyticsOnly := ts.store.addAPIKey(t, linkOwner, auth.ScopeAnalyticsRead)
+
+	requests := []struct {
+		key, method, path string
+		body              any
+		expected          int
+	}{
+		{readOnly, "PATCH", "/api/v1/links/abc123", map[string]any{"title": "After"}, http.StatusForbidden},
+		{readOnly, "DELETE", "/api/v1/links/abc123", nil, http.StatusForbidden},
+		{readOnly, "POST", "/api/v1/links", map[string]any{"long_url": "https://example.org/"}, http.StatusForbidden},
+		{analyticsOnly, "GET", "/api/v1/links/abc123", nil, http.StatusForbidden},
+		{analyticsOnly, "GET", "/api/v1/analytics/tags", nil, http.StatusOK},
+	}
+	for _, r := range requests {
+		resp, body := ts.do(t, r.method, r.path, r.body, "X-API-Key", r.key)
+		if resp.StatusCode != r.expected {
+			t.Errorf("%s %s: expected status %d; got %v: %s", r.method, r.path, r.expected, resp.Status, body)
+		}
+		if r.expected == http.StatusForbidden && message(t, body) != "api key is missing the required scope" {
+			t.Errorf("%s %s: expected the missing scope named; got %s", r.method, r.path, body)
+		}
+	}
+	if stored := ts.store.link("abc123"); stored.Title != "Before" || stored.DeletedAt != nil {
+		t.Errorf("expected the link untouched; got %+v", stored)
+	}
+}
+
+func TestAPIKeyCannotManageKeys(t *testing.T) {
+	ts := newTestServer(t, nil)
+	key := ts.store.addAPIKey(t, linkOwner, auth.ScopeRead, auth.ScopeLinksWrite, auth.ScopeAnalyticsRead)
+
+	requests := []struct {
+		method, path string
+		body         any
+	}{
+		{"GET", "/account/api-keys", nil},
+		{"POST", "/account/api-keys", map[string]any{"name": "more", "scopes": []string{auth.ScopeRead}}},
+		{"DELETE", "/account/api-keys/1", nil},
+	}
+	for _, r := range requests {
+		resp, body := ts.do(t, r.method, r.path, r.body, "Authorization", "Bearer "+key)
+		if resp.StatusCode != http.StatusForbidden || message(t, body) != "api keys cannot manage the account" {
+			t.Errorf("%s %s: expected status Forbidden; got %v: %s", r.method, r.path, resp.Status, body)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package tests
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koderkt/teenyurl/internal/config"
+)
+
+func TestPostgresDSN(t *testing.T) {
+	cfg := config.Postgres{
+		Host:        "db.internal",
+		Port:        "6432",
+		Database:    "teenyurl",
+		Username:    "app",
+		Password:    "p@ss word",
+		Schema:      "shortener",
+		SSLMode:     "verify-full",
+		SSLRootCert: "/etc/ssl/root.crt",
+	}
+	dsn, err := url.Parse(cfg.DSN())
+	if err != nil {
+		t.Fatalf("error parsing dsn. Err: %v", err)
+	}
+	if dsn.Host != "db.internal:6432" {
+		t.Errorf("expected host db.internal:6432; got %v", dsn.Host)
+	}
+	if dsn.Path != "/teenyurl" {
+		t.Errorf("expected path /teenyurl; got %v", dsn.Path)
+	}
+	if pw, _ := dsn.User.Password(); pw != "p@ss word" {
+		t.Errorf("expected password to round trip; got %v", pw)
+	}
+	query := dsn.Query()
+	if query.Get("sslmode") != "verify-full" {
+		t.Errorf("expected sslmode verify-full; got %v", query.Get("sslmode"))
+	}
+	if query.Get("sslrootcert") != "/etc/ssl/root.crt" {
+		t.Errorf("expected sslrootcert to be set; got %v", query.Get("sslrootcert"))
+	}
+	if query.Get("search_path") != "shortener" {
+		t.Errorf("expected search_path shortener; got %v", query.Get("search_path"))
+	}
+}
+
+func TestPostgresDSNPrefersURL(t *testing.T) {
+	cfg := config.Postgres{
+		URL:  "postgres://u:p@example.com:5432/db?sslmode=require",
+		Host: "ignored",
+	}
+	if cfg.DSN() != cfg.URL {
+		t.Errorf("expected DATABASE_URL to be used verbatim; got %v", cfg.DSN())
+	}
+}
+
+func TestLoadFileAndEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "teenyurl.yaml")
+	data := `
+http:
+  port: 9000
+postgres:
+  database: teenyurl
+  username: app
+session:
+  ttl: 30m
+`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("error writing config file. Err: %v", err)
+	}
+	t.Setenv("PORT", "")
+	t.Setenv("DB_HOST", "db.internal")
+	t.Setenv("SHORT_CODE_LENGTH", "8")
+
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("error loading config. Err: %v", err)
+	}
+	if cfg.HTTP.Port != 9000 {
+		t.Errorf("expected port from file; got %v", cfg.HTTP.Port)
+	}
+	if cfg.Session.TTL.Minutes() != 30 {
+		t.Errorf("expected session ttl 30m; got %v", cfg.Session.TTL)
+	}
+	if cfg.Postgres.Host != "db.internal" {
+		t.Errorf("expected host from env; got %v", cfg.Postgres.Host)
+	}
+	if cfg.ShortCode.Length != 8 {
+		t.Errorf("expected short code length from env; got %v", cfg.ShortCode.Length)
+	}
+}
+
+func TestLoadRejectsInvalidConfig(t *testing.T) {
+	t.Setenv("DB_DATABASE", "teenyurl")
+	t.Setenv("DB_USERNAME", "app")
+	t.Setenv("PORT", "70000")
+
+	_, err := config.Load("")
+	if err == nil {
+		t.Fatal("expected an error for an out of range port")
+	}
+	if !strings.Contains(err.Error(), "HTTP.Port") {
+		t.Errorf("expected the error to name the field; got %v", err)
+	}
+
+	t.Setenv("PORT", "not-a-number")
+	if _, err := config.Load(""); err == nil {
+		t.Fatal("expected an error for a non numeric port")
+	}
+}
+
+func TestProxyHeaderNeedsTrustedProxies(t *testing.T) {
+	t.Setenv("DB_DATABASE", "teenyurl")
+	t.Setenv("DB_USERNAME", "app")
+	t.Setenv("HTTP_PROXY_HEADER", "X-Forwarded-For")
+
+	_, err := config.Load("")
+	if err == nil || !strings.Contains(err.Error(), "HTTP.TrustedProxies") {
+		t.Errorf("expected an error naming HTTP.TrustedProxies; got %v", err)
+	}
+
+	t.Setenv("HTTP_TRUSTED_PROXIES", "10.0.0.0/8,192.168.1.1")
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatalf("error loading config. Err: %v", err)
+	}
+	if len(cfg.HTTP.TrustedProxies) != 2 {
+		t.Errorf("expected 2 trusted proxies; got %v", cfg.HTTP.TrustedProxies)
+	}
+}
+
+func TestLinksRedirectStatus(t *testing.T) {
+	t.Setenv("DB_DATABASE", "teenyurl")
+	t.Setenv("DB_USERNAME", "app")
+
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatalf("error loading config. Err: %v", err)
+	}
+	if cfg.Links.RedirectStatus != 302 {
+		t.Errorf("expected a temporary redirect by default; got %v", cfg.Links.RedirectStatus)
+	}
+
+	t.Setenv("LINKS_REDIRECT_STATUS", "307")
+	if cfg, err = config.Load(""); err != nil || cfg.Links.RedirectStatus != 307 {
+		t.Errorf("expected redirect status from env; got %v, %v", cfg, err)
+	}
+
+	t.Setenv("LINKS_REDIRECT_STATUS", "303")
+	_, err = config.Load("")
+	if err == nil || !strings.Contains(err.Error(), "Links.RedirectStatus") {
+		t.Errorf("expected an error naming Links.RedirectStatus; got %v", err)
+	}
+}
+
+func TestDrainDelayWithinShutdownTimeout(t *testing.T) {
+	t.Setenv("DB_DATABASE", "teenyurl")
+	t.Setenv("DB_USERNAME", "app")
+	t.Setenv("HTTP_DRAIN_DELAY", "3s")
+
+	cfg, err := config.Load("")
+	if err != nil || cfg.HTTP.DrainDelay != 3*time.Second {
+		t.Fatalf("expected drain delay from env; got %v, %v", cfg, err)
+	}
+
+	t.Setenv("HTTP_SHUTDOWN_TIMEOUT", "3s")
+	_, err = config.Load("")
+	if err == nil || !strings.Contains(err.Error(), "HTTP.DrainDelay") {
+		t.Errorf("expected an error naming HTTP.DrainDelay; got %v", err)
+	}
+}
@@ -39,3 +39,23 @@ func TestHandler(t *testing.T) {
 		t.Errorf("expected response body to be %v; got %v", expected, string(body))
 	}
 }
+
+func TestProbes(t *testing.T) {
+	app := fiber.New()
+	s := &server.FiberServer{App: app}
+	s.RegisterFiberRoutes()
+
+	for _, path := range []string{"/livez", "/readyz"} {
+		req, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatalf("error creating request. Err: %v", err)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("error making request to server. Err: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: expected status OK; got %v", path, resp.Status)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/koderkt/teenyurl/internal/config"
+)
+
+type readiness struct {
+	Status       string `json:"status"`
+	Dependencies map[string]struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	} `json:"dependencies"`
+}
+
+func checkReadiness(t *testing.T, ts *testServer) (int, readiness) {
+	resp, body := ts.do(t, "GET", "/readyz", nil)
+	var ready readiness
+	decode(t, body, &ready)
+	return resp.StatusCode, ready
+}
+
+func TestReadinessUp(t *testing.T) {
+	ts := newTestServer(t, nil)
+	status, ready := checkReadiness(t, ts)
+	if status != http.StatusOK || ready.Status != "up" {
+		t.Errorf("expected ready; got %v %+v", status, ready)
+	}
+	for _, name := range []string{"postgres", "redis"} {
+		if ready.Dependencies[name].Status != "up" {
+			t.Errorf("expected %s up; got %+v", name, ready.Dependencies[name])
+		}
+	}
+}
+
+func TestReadinessPostgresDown(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.store.failOn("Ping", errors.New("connection refused"))
+
+	status, ready := checkReadiness(t, ts)
+	if status != http.StatusServiceUnavailable || ready.Status != "down" {
+		t.Errorf("expected status 503 and down; got %v %+v", status, ready)
+	}
+	if pg := ready.Dependencies["postgres"]; pg.Status != "down" || pg.Error != "connection refused" {
+		t.Errorf("expected postgres down with its error; got %+v", pg)
+	}
+	if ready.Dependencies["redis"].Status != "up" {
+		t.Errorf("expected redis up; got %+v", ready.Dependencies["redis"])
+	}
+
+	// liveness doesn't depend on Postgres
+	resp, _ := ts.do(t, "GET", "/livez", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected /livez to stay OK; got %v", resp.Status)
+	}
+}
+
+func TestReadinessRedisDown(t *testing.T) {
+	ts := newTestServer(t, func(cfg *config.Config) {
+		cfg.Health.Timeout = time.Second
+	})
+	ts.redis.Close()
+
+	status, ready := checkReadiness(t, ts)
+	if status != http.StatusServiceUnavailable || ready.Status != "down" {
+		t.Errorf("expected status 503 and down; got %v %+v", status, ready)
+	}
+	if ready.Dependencies["redis"].Status != "down" || ready.Dependencies["postgres"].Status != "up" {
+		t.Errorf("expected only redis down; got %+v", ready.Dependencies)
+	}
+}
+
+func TestReadinessDrainsBeforeShutdown(t *testing.T) {
+	drainDelay := 200 * time.Millisecond
+	ts := newTestServer(t, func(cfg *config.Config) {
+		cfg.HTTP.DrainDelay = drainDelay
+	})
+
+	started := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done <- ts.GracefulShutdown(ctx)
+	}()
+
+	// requests are still served while draining, but readiness fails
+	deadline := time.Now().Add(drainDelay / 2)
+	status, ready := checkReadiness(t, ts)
+	for ready.Status != "draining" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		status, ready = checkReadiness(t, ts)
+	}
+	if status != http.StatusServiceUnavailable || ready.Status != "draining" {
+		t.Errorf("expected status 503 and draining; got %v %+v", status, ready)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("error shutting down. Err: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed < drainDelay {
+		t.Errorf("expected shutdown to wait out the drain delay; took %v", elapsed)
+	}
+}
@@ -0,0 +1,852 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/koderkt/teenyurl/internal/auth"
+	"github.com/koderkt/teenyurl/internal/config"
+	"github.com/koderkt/teenyurl/internal/database"
+	"github.com/koderkt/teenyurl/internal/server"
+	"github.com/koderkt/teenyurl/internal/types"
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeStore keeps what the tests need in memory; every other database call
+// panics. It serves the test server as well as the background jobs.
+type fakeStore struct {
+	database.Service
+	mu       sync.Mutex
+	users    map[int]*types.User
+	totp     map[int]*types.TOTP
+	recovery map[int]map[string]bool
+	attempts []types.LoginAttempt
+	// identities maps a provider and subject to a user id
+	identities map[string]int
+
+	// links by short code
+	links  map[string]*types.Link
+	lastId int
+	// tags by user and lowercased name, and the lowercased tag names of
+	// each link
+	tags     map[int]map[string]string
+	linkTags map[int]map[string]bool
+	folders  map[int]*types.Folder
+	clicks   []types.Clicks
+	// clickDelay slows down InsertAnalytics
+	clickDelay time.Duration
+	// purges holds the cutoff of every PurgeDeletedLinks call
+	purges []time.Time
+	// apiKeys by hash
+	apiKeys map[string]*types.APIKeyOwner
+	// failures makes the named methods return an error
+	failures map[string]error
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		users:      map[int]*types.User{},
+		totp:       map[int]*types.TOTP{},
+		recovery:   map[int]map[string]bool{},
+		identities: map[string]int{},
+		links:      map[string]*types.Link{},
+		tags:       map[int]map[string]string{},
+		linkTags:   map[int]map[string]bool{},
+		folders:    map[int]*types.Folder{},
+		apiKeys:    map[string]*types.APIKeyOwner{},
+		failures:   map[string]error{},
+	}
+}
+
+func (s *fakeStore) Close() error { return nil }
+
+func (s *fakeStore) Ping(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.failures["Ping"]
+}
+
+func (s *fakeStore) addUser(user *types.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[user.ID] = user
+}
+
+func (s *fakeStore) GetUserById(ctx context.Context, id int) (*types.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[id]
+	if !ok {
+		return nil, database.ErrUserNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (s *fakeStore) GetUserByEmail(ctx context.Context, email string) (*types.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, user := range s.users {
+		if user.Email == email {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, database.ErrUserNotFound
+}
+
+func (s *fakeStore) CreateUser(ctx context.Context, user *types.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.failures["CreateUser"]; err != nil {
+		return err
+	}
+	s.lastId++
+	user.ID = s.lastId
+	stored := *user
+	s.users[user.ID] = &stored
+	return nil
+}
+
+func (s *fakeStore) MarkEmailVerified(ctx context.Context, id int, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, ok := s.users[id]; ok && user.Email == email && user.EmailVerifiedAt == nil {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+	}
+	return nil
+}
+
+func (s *fakeStore) GetUserByIdentity(ctx context.Context, provider string, subject string) (*types.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[s.identities[provider+" "+subject]]
+	if !ok {
+		return nil, database.ErrUserNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (s *fakeStore) LinkIdentity(ctx context.Context, identity *types.UserIdentity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.failures["LinkIdentity"]; err != nil {
+		return err
+	}
+	key := identity.Provider + " " + identity.Subject
+	if _, ok := s.identities[key]; !ok {
+		s.identities[key] = identity.UserId
+	}
+	return nil
+}
+
+func (s *fakeStore) UpdatePassword(ctx context.Context, id int, encryptedPassword string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, ok := s.users[id]; ok {
+		user.EncryptedPassword = encryptedPassword
+	}
+	return nil
+}
+
+func (s *fakeStore) RecordLoginAttempt(ctx context.Context, attempt *types.LoginAttempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts = append(s.attempts, *attempt)
+	return nil
+}
+
+// enableTOTP turns 2FA on for userId with secret and the recovery code
+// hashes.
+func (s *fakeStore) enableTOTP(userId int, secret string, recoveryHashes ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.totp[userId] = &types.TOTP{UserId: userId, Secret: secret, EnabledAt: &now, CreatedAt: now}
+	s.recovery[userId] = map[string]bool{}
+	for _, hash := range recoveryHashes {
+		s.recovery[userId][hash] = false
+	}
+}
+
+func (s *fakeStore) GetTOTP(ctx context.Context, userId int) (*types.TOTP, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	totp, ok := s.totp[userId]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *totp
+	return &copied, nil
+}
+
+func (s *fakeStore) UseTOTPStep(ctx context.Context, userId int, step int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	totp, ok := s.totp[userId]
+	if !ok || totp.EnabledAt == nil || totp.LastUsedStep >= step {
+		return sql.ErrNoRows
+	}
+	totp.LastUsedStep = step
+	return nil
+}
+
+func (s *fakeStore) UseRecoveryCode(ctx context.Context, userId int, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	used, ok := s.recovery[userId][hash]
+	if !ok || used {
+		return sql.ErrNoRows
+	}
+	s.recovery[userId][hash] = true
+	return nil
+}
+
+// failOn makes method return err from now on.
+func (s *fakeStore) failOn(method string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[method] = err
+}
+
+// WithTx runs fn on the store itself and puts the users, identities, links
+// and tags back as they were when it fails.
+func (s *fakeStore) WithTx(ctx context.Context, fn func(database.Service) error) error {
+	s.mu.Lock()
+	users := map[int]*types.User{}
+	for id, user := range s.users {
+		copied := *user
+		users[id] = &copied
+	}
+	identities := maps.Clone(s.identities)
+	links := map[string]*types.Link{}
+	for code, link := range s.links {
+		copied := *link
+		links[code] = &copied
+	}
+	tags := map[int]map[string]string{}
+	for userId, names := range s.tags {
+		tags[userId] = maps.Clone(names)
+	}
+	linkTags := map[int]map[string]bool{}
+	for linkId, names := range s.linkTags {
+		linkTags[linkId] = maps.Clone(names)
+	}
+	s.mu.Unlock()
+
+	err := fn(s)
+	if err != nil {
+		s.mu.Lock()
+		s.users, s.identities = users, identities
+		s.links, s.tags, s.linkTags = links, tags, linkTags
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// addLink stores link as it is, with the next id, and returns the id.
+func (s *fakeStore) addLink(link types.Link) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastId++
+	link.Id = s.lastId
+	if link.CreatedAt.IsZero() {
+		link.CreatedAt = time.Now()
+	}
+	s.links[link.ShortURL] = &link
+	return link.Id
+}
+
+// link returns a copy of the link stored under code, or nil.
+func (s *fakeStore) link(code string) *types.Link {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	link, ok := s.links[code]
+	if !ok {
+		return nil
+	}
+	copied := *link
+	return &copied
+}
+
+func (s *fakeStore) addFolder(userId int, name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastId++
+	s.folders[s.lastId] = &types.Folder{Id: s.lastId, UserId: userId, Name: name, CreatedAt: time.Now()}
+	return s.lastId
+}
+
+func (s *fakeStore) addClick(code string, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clicks = append(s.clicks, types.Clicks{ShortCode: code, Timestamp: at})
+}
+
+func (s *fakeStore) CreateShortURL(ctx context.Context, link *types.Link) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.failures["CreateShortURL"]; err != nil {
+		return err
+	}
+	s.lastId++
+	stored := *link
+	stored.Id = s.lastId
+	stored.CreatedAt = time.Now()
+	stored.IsEnabled = true
+	if u, err := url.Parse(link.OriginalURL); err == nil {
+		stored.Domain = strings.ToLower(u.Hostname())
+	}
+	s.links[link.ShortURL] = &stored
+	return nil
+}
+
+func (s *fakeStore) GetLink(ctx context.Context, shortURL string) (*types.Link, error) {
+	if link := s.link(shortURL); link != nil {
+		return link, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (s *fakeStore) GetNumberOfClicks(ctx context.Context, shortURL string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, click := range s.clicks {
+		if click.ShortCode == shortURL {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// ListLinks filters and orders links as the SQL does, counting their
+// clicks and collecting their tags.
+func (s *fakeStore) ListLinks(ctx context.Context, q *types.LinkQuery) ([]types.LinkStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	links := []types.LinkStats{}
+	for _, link := range s.links {
+		switch {
+		case link.UserId != q.UserId,
+			q.DeletedSince == nil && link.DeletedAt != nil,
+			q.DeletedSince != nil && (link.DeletedAt == nil || !link.DeletedAt.After(*q.DeletedSince)),
+			q.Enabled != nil && link.IsEnabled != *q.Enabled,
+			q.CreatedFrom != nil && link.CreatedAt.Before(*q.CreatedFrom),
+			q.CreatedTo != nil && !link.CreatedAt.Before(*q.CreatedTo),
+			q.Domain != "" && link.Domain != q.Domain && !strings.HasSuffix(link.Domain, "."+q.Domain),
+			q.Tag != "" && !s.linkTags[link.Id][strings.ToLower(q.Tag)],
+			q.FolderId != nil && *q.FolderId == 0 && link.FolderId != nil,
+			q.FolderId != nil && *q.FolderId != 0 && (link.FolderId == nil || *link.FolderId != *q.FolderId),
+			q.Search != "" && !strings.Contains(strings.ToLower(link.OriginalURL+" "+link.ShortURL), strings.ToLower(q.Search)):
+			continue
+		}
+		stats := types.LinkStats{Link: *link}
+		for _, click := range s.clicks {
+			if click.ShortCode == link.ShortURL {
+				stats.Clicks++
+			}
+		}
+		for lower := range s.linkTags[link.Id] {
+			stats.Tags = append(stats.Tags, s.tags[link.UserId][lower])
+		}
+		slices.SortFunc(stats.Tags, func(a, b string) int { return strings.Compare(strings.ToLower(a), strings.ToLower(b)) })
+		links = append(links, stats)
+	}
+
+	compare := func(a, b types.LinkStats) int {
+		n := 0
+		switch q.Sort {
+		case types.LinkSortCreated:
+			n = a.CreatedAt.Compare(b.CreatedAt)
+		case types.LinkSortClicks:
+			n = a.Clicks - b.Clicks
+		case types.LinkSortDestination:
+			n = strings.Compare(a.OriginalURL, b.OriginalURL)
+		}
+		if n == 0 {
+			n = a.Id - b.Id
+		}
+		if q.Desc {
+			return -n
+		}
+		return n
+	}
+	if q.After != nil {
+		after := types.LinkStats{Link: types.Link{Id: q.After.Id, OriginalURL: q.After.Value}}
+		after.CreatedAt, _ = time.Parse(time.RFC3339Nano, q.After.Value)
+		after.Clicks, _ = strconv.Atoi(q.After.Value)
+		links = slices.DeleteFunc(links, func(link types.LinkStats) bool { return compare(link, after) <= 0 })
+	}
+	slices.SortFunc(links, compare)
+	if len(links) > q.Limit {
+		links = links[:q.Limit]
+	}
+	return links, nil
+}
+
+func (s *fakeStore) InsertAnalytics(ctx context.Context, click *types.Clicks) error {
+	time.Sleep(s.clickDelay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.failures["InsertAnalytics"]; err != nil {
+		return err
+	}
+	s.clicks = append(s.clicks, *click)
+	return nil
+}
+
+func (s *fakeStore) clickCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.clicks)
+}
+
+func (s *fakeStore) EditLink(ctx context.Context, link *types.Link) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.failures["EditLink"]; err != nil {
+		return err
+	}
+	if stored, ok := s.links[link.ShortURL]; ok {
+		stored.OriginalURL = link.OriginalURL
+		stored.LinkMetadata = types.LinkMetadata{}
+		stored.FlaggedAt, stored.FlagReason = nil, ""
+		stored.ScannedAt = link.ScannedAt
+	}
+	return nil
+}
+
+func (s *fakeStore) UpdateLinkDetails(ctx context.Context, link *types.Link) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.failures["UpdateLinkDetails"]; err != nil {
+		return err
+	}
+	stored, ok := s.links[link.ShortURL]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	stored.Title, stored.Notes = link.Title, link.Notes
+	stored.Preview, stored.RedirectStatus = link.Preview, link.RedirectStatus
+	return nil
+}
+
+func (s *fakeStore) DeleteLink(ctx context.Context, link *types.Link) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.links[link.ShortURL]
+	if !ok || stored.DeletedAt != nil {
+		return sql.ErrNoRows
+	}
+	now := time.Now()
+	stored.DeletedAt = &now
+	return nil
+}
+
+func (s *fakeStore) RestoreLink(ctx context.Context, link *types.Link, since time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.links[link.ShortURL]
+	if !ok || stored.DeletedAt == nil || !stored.DeletedAt.After(since) {
+		return sql.ErrNoRows
+	}
+	stored.DeletedAt = nil
+	return nil
+}
+
+func (s *fakeStore) PurgeDeletedLinks(ctx context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purges = append(s.purges, before)
+	var n int64
+	for code, link := range s.links {
+		if link.DeletedAt != nil && link.DeletedAt.Before(before) {
+			delete(s.links, code)
+			s.clicks = slices.DeleteFunc(s.clicks, func(click types.Clicks) bool { return click.ShortCode == code })
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (s *fakeStore) purgeRuns() []time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]time.Time(nil), s.purges...)
+}
+
+func (s *fakeStore) SaveLinkMetadata(ctx context.Context, linkId int, originalURL string, meta *types.LinkMetadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, link := range s.links {
+		if link.Id == linkId && link.OriginalURL == originalURL {
+			link.LinkMetadata = *meta
+			link.LinkMetadata.FetchedAt = &now
+		}
+	}
+	return nil
+}
+
+func (s *fakeStore) GetLinksToScan(ctx context.Context, before time.Time, afterId, limit int) ([]types.Link, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	links := []types.Link{}
+	for _, link := range s.links {
+		if link.DeletedAt == nil && link.FlaggedAt == nil && (link.ScannedAt == nil || link.ScannedAt.Before(before)) && link.Id > afterId {
+			links = append(links, *link)
+		}
+	}
+	slices.SortFunc(links, func(a, b types.Link) int { return a.Id - b.Id })
+	if len(links) > limit {
+		links = links[:limit]
+	}
+	return links, nil
+}
+
+func (s *fakeStore) MarkLinksScanned(ctx context.Context, linkIds []int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, link := range s.links {
+		if slices.Contains(linkIds, link.Id) {
+			link.ScannedAt = &now
+		}
+	}
+	return nil
+}
+
+func (s *fakeStore) FlagLink(ctx context.Context, link *types.Link, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if stored, ok := s.links[link.ShortURL]; ok && stored.Id == link.Id && stored.OriginalURL == link.OriginalURL {
+		stored.FlaggedAt, stored.FlagReason, stored.ScannedAt = &now, reason, &now
+		stored.IsEnabled = false
+	}
+	return nil
+}
+
+func (s *fakeStore) GetLinkIds(ctx context.Context, userId int, codes []string) (map[string]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := map[string]int{}
+	for _, code := range codes {
+		if link, ok := s.links[code]; ok && link.UserId == userId && link.DeletedAt == nil {
+			ids[code] = link.Id
+		}
+	}
+	return ids, nil
+}
+
+func (s *fakeStore) GetLinkTags(ctx context.Context, linkId int) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := []string{}
+	for _, link := range s.links {
+		if link.Id != linkId {
+			continue
+		}
+		for lower := range s.linkTags[linkId] {
+			names = append(names, s.tags[link.UserId][lower])
+		}
+	}
+	slices.SortFunc(names, func(a, b string) int { return strings.Compare(strings.ToLower(a), strings.ToLower(b)) })
+	return names, nil
+}
+
+func (s *fakeStore) SetLinkTags(ctx context.Context, userId int, linkId int, names []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.failures["SetLinkTags"]; err != nil {
+		return err
+	}
+	s.linkTags[linkId] = map[string]bool{}
+	s.addLinkTags(userId, []int{linkId}, names)
+	return nil
+}
+
+func (s *fakeStore) TagLinks(ctx context.Context, userId int, linkIds []int, add []string, remove []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.failures["TagLinks"]; err != nil {
+		return err
+	}
+	for _, linkId := range linkIds {
+		for _, name := range remove {
+			delete(s.linkTags[linkId], strings.ToLower(name))
+		}
+	}
+	s.addLinkTags(userId, linkIds, add)
+	return nil
+}
+
+// addLinkTags creates missing tags and puts them on the links. The caller
+// holds mu.
+func (s *fakeStore) addLinkTags(userId int, linkIds []int, names []string) {
+	if s.tags[userId] == nil {
+		s.tags[userId] = map[string]string{}
+	}
+	for _, name := range names {
+		lower := strings.ToLower(name)
+		if _, ok := s.tags[userId][lower]; !ok {
+			s.tags[userId][lower] = name
+		}
+		for _, linkId := range linkIds {
+			if s.linkTags[linkId] == nil {
+				s.linkTags[linkId] = map[string]bool{}
+			}
+			s.linkTags[linkId][lower] = true
+		}
+	}
+}
+
+func (s *fakeStore) GetTagClicks(ctx context.Context, userId int, from *time.Time, to *time.Time) ([]types.TagClicks, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := []types.TagClicks{}
+	for lower, name := range s.tags[userId] {
+		stat := types.TagClicks{Tag: name}
+		for _, link := range s.links {
+			if link.UserId != userId || link.DeletedAt != nil || !s.linkTags[link.Id][lower] {
+				continue
+			}
+			stat.Links++
+			for _, click := range s.clicks {
+				if click.ShortCode == link.ShortURL &&
+					(from == nil || !click.Timestamp.Before(*from)) &&
+					(to == nil || click.Timestamp.Before(*to)) {
+					stat.Clicks++
+				}
+			}
+		}
+		stats = append(stats, stat)
+	}
+	slices.SortFunc(stats, func(a, b types.TagClicks) int {
+		if a.Clicks != b.Clicks {
+			return b.Clicks - a.Clicks
+		}
+		return strings.Compare(strings.ToLower(a.Tag), strings.ToLower(b.Tag))
+	})
+	return stats, nil
+}
+
+func (s *fakeStore) GetFolder(ctx context.Context, userId int, id int) (*types.Folder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	folder, ok := s.folders[id]
+	if !ok || folder.UserId != userId {
+		return nil, sql.ErrNoRows
+	}
+	copied := *folder
+	return &copied, nil
+}
+
+func (s *fakeStore) SetLinksFolder(ctx context.Context, linkIds []int, folderId *int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.failures["SetLinksFolder"]; err != nil {
+		return err
+	}
+	for _, link := range s.links {
+		if slices.Contains(linkIds, link.Id) {
+			link.FolderId = folderId
+		}
+	}
+	return nil
+}
+
+// addAPIKey gives user a key with scopes and returns the raw key.
+func (s *fakeStore) addAPIKey(t *testing.T, user types.UserSession, scopes ...string) string {
+	raw, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("error generating api key. Err: %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastId++
+	s.apiKeys[hash] = &types.APIKeyOwner{
+		APIKey: types.APIKey{
+			Id:        s.lastId,
+			UserId:    user.Id,
+			Name:      "test",
+			Prefix:    prefix,
+			KeyHash:   hash,
+			Scopes:    scopes,
+			CreatedAt: time.Now(),
+		},
+		UserName: user.UserName,
+		Email:    user.Email,
+	}
+	return raw
+}
+
+func (s *fakeStore) GetAPIKeyByHash(ctx context.Context, hash string) (*types.APIKeyOwner, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.apiKeys[hash]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *key
+	return &copied, nil
+}
+
+func (s *fakeStore) TouchAPIKey(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, key := range s.apiKeys {
+		if key.Id == id {
+			key.LastUsedAt = &now
+		}
+	}
+	return nil
+}
+
+// syncBuffer collects log output written from several goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// lines decodes the JSON log lines with message msg.
+func (b *syncBuffer) lines(t *testing.T, msg string) []map[string]any {
+	var found []map[string]any
+	for _, raw := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		var line map[string]any
+		if err := json.Unmarshal([]byte(raw), &line); err != nil {
+			t.Fatalf("error decoding log line %q. Err: %v", raw, err)
+		}
+		if line["msg"] == msg {
+			found = append(found, line)
+		}
+	}
+	return found
+}
+
+// testServer is the full server with its routes, on a fakeStore and
+// miniredis.
+type testServer struct {
+	*server.FiberServer
+	cfg   *config.Config
+	store *fakeStore
+	redis *miniredis.Miniredis
+	// logs holds everything logged, as JSON lines.
+	logs *syncBuffer
+}
+
+// newTestServer starts a server with the default configuration, minus the
+// background jobs and anything that reaches the network. configure may
+// change it further.
+func newTestServer(t *testing.T, configure func(*config.Config)) *testServer {
+	cfg := config.Default()
+	cfg.RateLimit.Enabled = false
+	cfg.Metrics.Enabled = false
+	cfg.Metadata.Enabled = false
+	cfg.Links.PurgeInterval = 0
+	cfg.URLPolicy.BlockPrivateNetworks = false
+	cfg.HTTP.DrainDelay = 0
+	if configure != nil {
+		configure(cfg)
+	}
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	logs := &syncBuffer{}
+	logger := slog.New(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	store := newFakeStore()
+
+	srv := server.NewWithStores(cfg, logger, store, client)
+	srv.RegisterFiberRoutes()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.GracefulShutdown(ctx)
+	})
+	return &testServer{FiberServer: srv, cfg: cfg, store: store, redis: mr, logs: logs}
+}
+
+// signIn creates a session for user and returns its bearer token.
+func (ts *testServer) signIn(t *testing.T, user types.UserSession) string {
+	client := redis.NewClient(&redis.Options{Addr: ts.redis.Addr()})
+	defer client.Close()
+	now := time.Now()
+	id, err := auth.NewSessions(client, ts.cfg.Session.TTL).Create(context.Background(), &types.Session{
+		UserSession: user,
+		CreatedAt:   now,
+		LastSeenAt:  now,
+	})
+	if err != nil {
+		t.Fatalf("error creating session. Err: %v", err)
+	}
+	return id
+}
+
+// do sends a request, with body encoded as JSON unless it is nil, and
+// returns the response and its body. Pairs of headers follow the body.
+func (ts *testServer) do(t *testing.T, method, path string, body any, headers ...string) (*http.Response, []byte) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("error encoding request body. Err: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, path, reader)
+	if err != nil {
+		t.Fatalf("error creating request. Err: %v", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	resp, err := ts.Test(req, -1)
+	if err != nil {
+		t.Fatalf("error making request to server. Err: %v", err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("error reading response body. Err: %v", err)
+	}
+	return resp, data
+}
+
+// decode unmarshals a JSON response body into v.
+func decode(t *testing.T, body []byte, v any) {
+	if err := json.Unmarshal(body, v); err != nil {
+		t.Fatalf("error decoding response %s. Err: %v", body, err)
+	}
+}
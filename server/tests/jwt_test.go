@@ -0,0 +1,191 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/koderkt/teenyurl/internal/auth"
+	"github.com/koderkt/teenyurl/internal/config"
+	"github.com/koderkt/teenyurl/internal/types"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	oldSecret = "old-secret-old-secret-old-secret-0"
+	newSecret = "new-secret-new-secret-new-secret-0"
+)
+
+func jwtConfig(active string, keys ...string) config.JWT {
+	return config.JWT{
+		Issuer:         "teenyurl",
+		AccessTokenTTL: time.Minute,
+		SigningKeys:    keys,
+		ActiveKey:      active,
+	}
+}
+
+func TestAccessTokenRoundTrip(t *testing.T) {
+	tokens, err := auth.NewTokens(jwtConfig("k1", "k1:"+oldSecret))
+	if err != nil {
+		t.Fatalf("error creating tokens. Err: %v", err)
+	}
+	user := types.UserSession{Id: 7, UserName: "someone", Email: "someone@example.com"}
+
+	raw, err := tokens.Issue(user, "family")
+	if err != nil {
+		t.Fatalf("error issuing token. Err: %v", err)
+	}
+	got, family, err := tokens.Verify(raw)
+	if err != nil {
+		t.Fatalf("error verifying token. Err: %v", err)
+	}
+	if *got != user {
+		t.Errorf("expected %+v; got %+v", user, *got)
+	}
+	if family != "family" {
+		t.Errorf("expected the refresh token family; got %q", family)
+	}
+
+	tampered := raw[:len(raw)-2] + "xx"
+	if _, _, err := tokens.Verify(tampered); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken for tampered token; got %v", err)
+	}
+}
+
+func TestAccessTokenKeyRotation(t *testing.T) {
+	before, err := auth.NewTokens(jwtConfig("k1", "k1:"+oldSecret))
+	if err != nil {
+		t.Fatalf("error creating tokens. Err: %v", err)
+	}
+	raw, err := before.Issue(types.UserSession{Id: 1}, "")
+	if err != nil {
+		t.Fatalf("error issuing token. Err: %v", err)
+	}
+
+	// k2 becomes active while k1 is still accepted
+	during, err := auth.NewTokens(jwtConfig("k2", "k1:"+oldSecret, "k2:"+newSecret))
+	if err != nil {
+		t.Fatalf("error creating tokens. Err: %v", err)
+	}
+	if _, _, err := during.Verify(raw); err != nil {
+		t.Errorf("expected token signed with retiring key to verify; got %v", err)
+	}
+
+	after, err := auth.NewTokens(jwtConfig("k2", "k2:"+newSecret))
+	if err != nil {
+		t.Fatalf("error creating tokens. Err: %v", err)
+	}
+	if _, _, err := after.Verify(raw); !errors.Is(err, auth.ErrInvalidToken) {
+		t.Errorf("expected token signed with removed key to fail; got %v", err)
+	}
+}
+
+func TestSigningKeysMustBeLongEnough(t *testing.T) {
+	if _, err := auth.NewTokens(jwtConfig("k1", "k1:short")); err == nil {
+		t.Errorf("expected error for short signing key")
+	}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	refresh := auth.NewRefreshTokens(client, time.Hour)
+	ctx := context.Background()
+	user := types.UserSession{Id: 3, UserName: "someone", Email: "someone@example.com"}
+
+	family, first, err := refresh.Issue(ctx, &types.Session{UserSession: user, CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("error issuing refresh token. Err: %v", err)
+	}
+	got, second, err := refresh.Rotate(ctx, first, "10.0.0.2")
+	if err != nil {
+		t.Fatalf("error rotating refresh token. Err: %v", err)
+	}
+	if got.UserSession != user || got.ID != family {
+		t.Errorf("expected %+v in family %s; got %+v in %s", user, family, got.UserSession, got.ID)
+	}
+	if got.IP != "10.0.0.2" {
+		t.Errorf("expected the refresh to be recorded; got IP %q", got.IP)
+	}
+	if second == first || strings.TrimSpace(second) == "" {
+		t.Fatalf("expected a new refresh token; got %q", second)
+	}
+
+	// replaying the first token is treated as theft
+	if _, _, err := refresh.Rotate(ctx, first, ""); !errors.Is(err, auth.ErrRefreshTokenReused) {
+		t.Errorf("expected ErrRefreshTokenReused; got %v", err)
+	}
+	if _, _, err := refresh.Rotate(ctx, second, ""); !errors.Is(err, auth.ErrInvalidRefreshToken) {
+		t.Errorf("expected family to be revoked after reuse; got %v", err)
+	}
+
+	if _, _, err := refresh.Rotate(ctx, "not-a-token", ""); !errors.Is(err, auth.ErrInvalidRefreshToken) {
+		t.Errorf("expected ErrInvalidRefreshToken; got %v", err)
+	}
+}
+
+func TestRefreshTokenRevoke(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	refresh := auth.NewRefreshTokens(client, time.Hour)
+	ctx := context.Background()
+
+	_, token, err := refresh.Issue(ctx, &types.Session{UserSession: types.UserSession{Id: 1}})
+	if err != nil {
+		t.Fatalf("error issuing refresh token. Err: %v", err)
+	}
+	if err := refresh.Revoke(ctx, token); err != nil {
+		t.Fatalf("error revoking refresh token. Err: %v", err)
+	}
+	if _, _, err := refresh.Rotate(ctx, token, ""); !errors.Is(err, auth.ErrInvalidRefreshToken) {
+		t.Errorf("expected revoked token to be rejected; got %v", err)
+	}
+}
+
+func TestRefreshTokenFamiliesPerUser(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	refresh := auth.NewRefreshTokens(client, time.Hour)
+	ctx := context.Background()
+
+	start := time.Now()
+	var families []string
+	for i := 0; i < 3; i++ {
+		session := &types.Session{UserSession: types.UserSession{Id: 1}, CreatedAt: start.Add(time.Duration(i) * time.Second)}
+		family, _, err := refresh.Issue(ctx, session)
+		if err != nil {
+			t.Fatalf("error issuing refresh token. Err: %v", err)
+		}
+		families = append(families, family)
+	}
+	if _, _, err := refresh.Issue(ctx, &types.Session{UserSession: types.UserSession{Id: 2}}); err != nil {
+		t.Fatalf("error issuing refresh token. Err: %v", err)
+	}
+
+	listed, err := refresh.List(ctx, 1)
+	if err != nil {
+		t.Fatalf("error listing families. Err: %v", err)
+	}
+	if len(listed) != 3 || listed[0].ID != families[2] {
+		t.Fatalf("expected user 1's 3 families newest first; got %+v", listed)
+	}
+
+	revoked, err := refresh.RevokeAll(ctx, 1, families[0])
+	if err != nil {
+		t.Fatalf("error revoking families. Err: %v", err)
+	}
+	if revoked != 2 {
+		t.Errorf("expected 2 families revoked; got %v", revoked)
+	}
+	listed, _ = refresh.List(ctx, 1)
+	if len(listed) != 1 || listed[0].ID != families[0] {
+		t.Errorf("expected only the kept family; got %+v", listed)
+	}
+	if other, _ := refresh.List(ctx, 2); len(other) != 1 {
+		t.Errorf("expected user 2's family to be untouched; got %+v", other)
+	}
+}
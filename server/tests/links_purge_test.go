@@ -0,0 +1,158 @@
+package tests
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koderkt/teenyurl/internal/config"
+	"github.com/koderkt/teenyurl/internal/links"
+	"github.com/koderkt/teenyurl/internal/types"
+)
+
+func TestPurgerRunsUntilClosed(t *testing.T) {
+	store := newFakeStore()
+	cfg := config.Links{
+		RestoreWindow: time.Hour,
+		Quarantine:    24 * time.Hour,
+		PurgeInterval: 10 * time.Millisecond,
+	}
+	started := time.Now()
+	purger := links.NewPurger(store, cfg, slog.Default())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(store.purgeRuns()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := purger.Close(ctx); err != nil {
+		t.Fatalf("error closing purger. Err: %v", err)
+	}
+
+	runs := store.purgeRuns()
+	if len(runs) < 2 {
+		t.Fatalf("expected the purge to run repeatedly; got %v runs", len(runs))
+	}
+	cutoff := runs[0]
+	if cutoff.After(started.Add(-cfg.Quarantine).Add(time.Second)) || cutoff.Before(started.Add(-cfg.Quarantine)) {
+		t.Errorf("expected cutoff one quarantine ago; got %v", cutoff)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if n := len(store.purgeRuns()); n != len(runs) {
+		t.Errorf("expected no purges after close; got %v more", n-len(runs))
+	}
+}
+
+func TestLoadRejectsShortQuarantine(t *testing.T) {
+	t.Setenv("DB_DATABASE", "teenyurl")
+	t.Setenv("DB_USERNAME", "app")
+	t.Setenv("LINKS_RESTORE_WINDOW", "48h")
+	t.Setenv("LINKS_QUARANTINE", "24h")
+
+	_, err := config.Load("")
+	if err == nil {
+		t.Fatal("expected an error for a quarantine shorter than the restore window")
+	}
+	if !strings.Contains(err.Error(), "Links.Quarantine") {
+		t.Errorf("expected error to mention Links.Quarantine; got %v", err)
+	}
+}
+
+func TestDeletedLinkStopsRedirecting(t *testing.T) {
+	ts := newTestServer(t, nil)
+	token := signInVerified(t, ts, linkOwner)
+	resp, link := createLink(t, ts, token, map[string]any{"long_url": "https://example.com/"})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status Created; got %v", resp.Status)
+	}
+	path := "/api/v1/links/" + link.ShortCode
+
+	resp, _ = ts.do(t, "DELETE", path, nil, "Authorization", token)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected status No Content; got %v", resp.Status)
+	}
+	resp, _ = ts.do(t, "GET", "/"+link.ShortCode, nil)
+	if resp.StatusCode != http.StatusNotFound || resp.Header.Get("Location") != "" {
+		t.Errorf("expected a deleted link not to redirect; got %v to %q", resp.Status, resp.Header.Get("Location"))
+	}
+	resp, _ = ts.do(t, "GET", path, nil, "Authorization", token)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected a deleted link to be not found; got %v", resp.Status)
+	}
+
+	resp, body := ts.do(t, "POST", path+"/restore", nil, "Authorization", token)
+	var restored types.LinkResponse
+	decode(t, body, &restored)
+	if resp.StatusCode != http.StatusOK || restored.DeletedAt != nil {
+		t.Fatalf("expected the link restored; got %v: %s", resp.Status, body)
+	}
+	resp, _ = ts.do(t, "GET", "/"+link.ShortCode, nil)
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "https://example.com/" {
+		t.Errorf("expected a restored link to redirect again; got %v to %q", resp.Status, resp.Header.Get("Location"))
+	}
+	resp, _ = ts.do(t, "POST", path+"/restore", nil, "Authorization", token)
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("expected status Conflict for a link that isn't deleted; got %v", resp.Status)
+	}
+}
+
+func TestRestoreWindow(t *testing.T) {
+	ts := newTestServer(t, func(cfg *config.Config) {
+		cfg.Links.RestoreWindow = time.Hour
+	})
+	token := signInVerified(t, ts, linkOwner)
+	recent := time.Now().Add(-30 * time.Minute)
+	old := time.Now().Add(-2 * time.Hour)
+	ts.store.addLink(types.Link{ShortURL: "recent", OriginalURL: "https://example.com/", UserId: linkOwner.Id, DeletedAt: &recent})
+	ts.store.addLink(types.Link{ShortURL: "old123", OriginalURL: "https://example.com/", UserId: linkOwner.Id, DeletedAt: &old})
+
+	resp, body := ts.do(t, "POST", "/api/v1/links/old123/restore", nil, "Authorization", token)
+	if resp.StatusCode != http.StatusGone {
+		t.Errorf("expected status Gone past the restore window; got %v: %s", resp.Status, body)
+	}
+	if ts.store.link("old123").DeletedAt == nil {
+		t.Error("expected the link to stay deleted")
+	}
+
+	resp, body = ts.do(t, "POST", "/api/v1/links/recent/restore", nil, "Authorization", token)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status OK within the restore window; got %v: %s", resp.Status, body)
+	}
+}
+
+func TestPurgeKeepsQuarantinedLinks(t *testing.T) {
+	ts := newTestServer(t, func(cfg *config.Config) {
+		cfg.Links.RestoreWindow = time.Hour
+		cfg.Links.Quarantine = 24 * time.Hour
+		cfg.Links.PurgeInterval = 10 * time.Millisecond
+	})
+	quarantined := time.Now().Add(-2 * time.Hour)
+	expired := time.Now().Add(-48 * time.Hour)
+	ts.store.addLink(types.Link{ShortURL: "quaran", OriginalURL: "https://example.com/", UserId: linkOwner.Id, DeletedAt: &quarantined})
+	ts.store.addLink(types.Link{ShortURL: "expire", OriginalURL: "https://example.com/", UserId: linkOwner.Id, DeletedAt: &expired})
+	ts.store.addClick("expire", expired)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for ts.store.link("expire") != nil && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if ts.store.link("expire") != nil {
+		t.Fatal("expected a link deleted before the quarantine to be purged")
+	}
+	if n, _ := ts.store.GetNumberOfClicks(context.Background(), "expire"); n != 0 {
+		t.Errorf("expected its clicks purged; got %v", n)
+	}
+	if ts.store.link("quaran") == nil {
+		t.Error("expected a quarantined link to be kept")
+	}
+	// the quarantined code still isn't served
+	resp, _ := ts.do(t, "GET", "/quaran", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status Not Found for a quarantined link; got %v", resp.Status)
+	}
+}
@@ -0,0 +1,186 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/koderkt/teenyurl/internal/links"
+	"github.com/koderkt/teenyurl/internal/types"
+)
+
+func TestLinkCursorRoundTrip(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 30, 0, 123456000, time.UTC)
+	link := &types.LinkStats{
+		Link:   types.Link{Id: 42, OriginalURL: "https://example.com/a", CreatedAt: created},
+		Clicks: 7,
+	}
+
+	for _, sort := range []string{types.LinkSortCreated, types.LinkSortClicks, types.LinkSortDestination} {
+		q := &types.LinkQuery{Sort: sort, Desc: true}
+		cursor := links.CursorAfter(q, link)
+		decoded, err := links.DecodeCursor(links.EncodeCursor(cursor))
+		if err != nil {
+			t.Fatalf("%s: error decoding cursor. Err: %v", sort, err)
+		}
+		if *decoded != cursor {
+			t.Errorf("%s: expected cursor %+v; got %+v", sort, cursor, *decoded)
+		}
+	}
+
+	q := &types.LinkQuery{Sort: types.LinkSortCreated}
+	cursor := links.CursorAfter(q, link)
+	if cursor.Value != "2024-05-01T12:30:00.123456Z" {
+		t.Errorf("expected cursor to keep microseconds; got %v", cursor.Value)
+	}
+}
+
+func TestLinkCursorRejectsGarbage(t *testing.T) {
+	bad := []string{
+		"not base64!",
+		links.EncodeCursor(types.LinkCursor{Sort: "name", Value: "x"}),
+		links.EncodeCursor(types.LinkCursor{Sort: types.LinkSortClicks, Value: "many"}),
+		links.EncodeCursor(types.LinkCursor{Sort: types.LinkSortCreated, Value: "yesterday"}),
+	}
+	for _, raw := range bad {
+		if _, err := links.DecodeCursor(raw); !errors.Is(err, links.ErrInvalidCursor) {
+			t.Errorf("expected ErrInvalidCursor for %q; got %v", raw, err)
+		}
+	}
+}
+
+// listLinks fetches one page of links with the query string query.
+func listLinks(t *testing.T, ts *testServer, token, query string) types.LinkPage {
+	resp, body := ts.do(t, "GET", "/api/v1/links?"+query, nil, "Authorization", token)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s: expected status OK; got %v: %s", query, resp.Status, body)
+	}
+	var page types.LinkPage
+	decode(t, body, &page)
+	return page
+}
+
+func codesOf(page types.LinkPage) []string {
+	codes := []string{}
+	for _, link := range page.Links {
+		codes = append(codes, link.ShortCode)
+	}
+	return codes
+}
+
+func TestListLinksRejectsBadQuery(t *testing.T) {
+	ts := newTestServer(t, nil)
+	token := signInVerified(t, ts, linkOwner)
+	clicksCursor := links.EncodeCursor(types.LinkCursor{Sort: types.LinkSortClicks, Desc: true, Value: "3", Id: 1})
+
+	cases := map[string]string{
+		"sort=name":              "sort must be created, clicks or destination",
+		"order=up":               "order must be asc or desc",
+		"limit=0":                "limit must be between 1 and 100",
+		"limit=101":              "limit must be between 1 and 100",
+		"cursor=garbage!":        "invalid cursor",
+		"cursor=" + clicksCursor: "cursor belongs to a different sort order",
+		"created_from=yesterday": "created_from must be a date or an RFC 3339 time",
+		"created_to=2024-13-01":  "created_to must be a date or an RFC 3339 time",
+		"enabled=maybe":          "enabled must be true or false",
+		"folder_id=-1":           "folder_id must be a folder id or 0",
+		"sort=clicks&order=asc&cursor=" + clicksCursor: "cursor belongs to a different sort order",
+	}
+	for query, expected := range cases {
+		resp, body := ts.do(t, "GET", "/api/v1/links?"+query, nil, "Authorization", token)
+		if resp.StatusCode != http.StatusBadRequest || message(t, body) != expected {
+			t.Errorf("%s: expected %q; got %v: %s", query, expected, resp.Status, body)
+		}
+	}
+}
+
+func TestListLinksPages(t *testing.T) {
+	ts := newTestServer(t, nil)
+	token := signInVerified(t, ts, linkOwner)
+	start := time.Now().Add(-time.Hour)
+	for i, clicks := range []int{2, 0, 2, 1, 2} {
+		code := fmt.Sprintf("code%d", i+1)
+		ts.store.addLink(types.Link{ShortURL: code, OriginalURL: "https://example.com/", UserId: linkOwner.Id,
+			IsEnabled: true, CreatedAt: start.Add(time.Duration(i) * time.Minute)})
+		for j := 0; j < clicks; j++ {
+			ts.store.addClick(code, start)
+		}
+	}
+	ts.store.addLink(types.Link{ShortURL: "theirs1", OriginalURL: "https://example.com/", UserId: linkOther.Id, IsEnabled: true})
+
+	cases := []struct {
+		query    string
+		limit    int
+		expected []string
+	}{
+		{"", 2, []string{"code5", "code4", "code3", "code2", "code1"}},
+		{"order=asc", 2, []string{"code1", "code2", "code3", "code4", "code5"}},
+		{"sort=clicks", 2, []string{"code5", "code3", "code1", "code4", "code2"}},
+		{"sort=clicks&order=asc", 3, []string{"code2", "code4", "code1", "code3", "code5"}},
+		{"", 5, []string{"code5", "code4", "code3", "code2", "code1"}},
+	}
+	for _, tc := range cases {
+		query := fmt.Sprintf("%s&limit=%d", tc.query, tc.limit)
+		var codes []string
+		pages := 1
+		for cursor := ""; ; pages++ {
+			page := listLinks(t, ts, token, query+"&cursor="+cursor)
+			codes = append(codes, codesOf(page)...)
+			if page.NextCursor == "" {
+				break
+			}
+			cursor = page.NextCursor
+		}
+		if !slices.Equal(codes, tc.expected) {
+			t.Errorf("%s: expected %v; got %v", query, tc.expected, codes)
+		}
+		if expected := (len(tc.expected) + tc.limit - 1) / tc.limit; pages != expected {
+			t.Errorf("%s: expected next_cursor on all but the last of %d pages; got %d pages", query, expected, pages)
+		}
+	}
+}
+
+func TestListLinksFilters(t *testing.T) {
+	ts := newTestServer(t, nil)
+	token := signInVerified(t, ts, linkOwner)
+	folder := ts.store.addFolder(linkOwner.Id, "work")
+	now := time.Now()
+	old := now.AddDate(0, 0, -10)
+	add := func(link types.Link) int {
+		link.UserId = linkOwner.Id
+		if link.CreatedAt.IsZero() {
+			link.CreatedAt = now
+		}
+		return ts.store.addLink(link)
+	}
+	news := add(types.Link{ShortURL: "news1", OriginalURL: "https://news.example.com/today", Domain: "news.example.com", IsEnabled: true})
+	add(types.Link{ShortURL: "shop1", OriginalURL: "https://shop.example.org/cart", Domain: "shop.example.org", IsEnabled: false, FolderId: &folder})
+	add(types.Link{ShortURL: "old1", OriginalURL: "https://example.com/archive", Domain: "example.com", IsEnabled: true, CreatedAt: old})
+	add(types.Link{ShortURL: "gone1", OriginalURL: "https://example.com/gone", Domain: "example.com", DeletedAt: &now})
+	ts.store.SetLinkTags(context.Background(), linkOwner.Id, news, []string{"Daily"})
+
+	cases := map[string][]string{
+		"sort=destination":                  {"old1", "news1", "shop1"},
+		"sort=destination&order=desc":       {"shop1", "news1", "old1"},
+		"enabled=false":                     {"shop1"},
+		"domain=example.com":                {"news1", "old1"},
+		"domain=EXAMPLE.org":                {"shop1"},
+		"tag=daily":                         {"news1"},
+		"folder_id=0&sort=destination":      {"old1", "news1"},
+		fmt.Sprintf("folder_id=%d", folder): {"shop1"},
+		"q=ARCHIVE":                         {"old1"},
+		"q=shop1":                           {"shop1"},
+		"created_from=" + now.AddDate(0, 0, -1).Format(time.DateOnly) + "&sort=destination": {"news1", "shop1"},
+		"created_to=" + old.Format(time.DateOnly):                                           {"old1"},
+		"deleted=true": {"gone1"},
+	}
+	for query, expected := range cases {
+		if codes := codesOf(listLinks(t, ts, token, query)); !slices.Equal(codes, expected) {
+			t.Errorf("%s: expected %v; got %v", query, expected, codes)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/koderkt/teenyurl/internal/server"
+)
+
+func TestAPIv1LinkRoutes(t *testing.T) {
+	app := fiber.New()
+	s := &server.FiberServer{App: app}
+	s.RegisterFiberRoutes()
+
+	routes := []struct{ method, path string }{
+		{"POST", "/api/v1/links"},
+		{"GET", "/api/v1/links"},
+		{"GET", "/api/v1/links/abc123"},
+		{"PATCH", "/api/v1/links/abc123"},
+		{"DELETE", "/api/v1/links/abc123"},
+		{"PUT", "/api/v1/links/abc123/status"},
+		{"GET", "/api/v1/links/abc123/analytics"},
+		{"GET", "/api/v1/links/abc123/qr"},
+		{"POST", "/api/v1/links/abc123/restore"},
+		{"POST", "/api/v1/links/bulk"},
+		{"GET", "/api/v1/tags"},
+		{"POST", "/api/v1/tags"},
+		{"PATCH", "/api/v1/tags/1"},
+		{"DELETE", "/api/v1/tags/1"},
+		{"GET", "/api/v1/folders"},
+		{"POST", "/api/v1/folders"},
+		{"PATCH", "/api/v1/folders/1"},
+		{"DELETE", "/api/v1/folders/1"},
+		{"GET", "/api/v1/analytics/tags"},
+	}
+	for _, r := range routes {
+		req, err := http.NewRequest(r.method, r.path, nil)
+		if err != nil {
+			t.Fatalf("error creating request. Err: %v", err)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("error making request to server. Err: %v", err)
+		}
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s %s: expected status 401; got %v", r.method, r.path, resp.Status)
+		}
+		if resp.Header.Get("Deprecation") != "" {
+			t.Errorf("%s %s: expected no Deprecation header", r.method, r.path)
+		}
+	}
+}
+
+func TestLegacyLinkRoutesDeprecated(t *testing.T) {
+	app := fiber.New()
+	s := &server.FiberServer{App: app}
+	s.RegisterFiberRoutes()
+
+	routes := []struct{ method, path, successor string }{
+		{"POST", "/links", "/api/v1/links"},
+		{"GET", "/links", "/api/v1/links"},
+		{"POST", "/abc123", "/api/v1/links/abc123"},
+		{"POST", "/abc123/false", "/api/v1/links/abc123/status"},
+		{"GET", "/analytics/abc123", "/api/v1/links/abc123/analytics"},
+		{"GET", "/links/abc123/qr", "/api/v1/links/abc123/qr"},
+	}
+	for _, r := range routes {
+		req, err := http.NewRequest(r.method, r.path, nil)
+		if err != nil {
+			t.Fatalf("error creating request. Err: %v", err)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("error making request to server. Err: %v", err)
+		}
+		if got := resp.Header.Get("Deprecation"); got != "true" {
+			t.Errorf("%s %s: expected Deprecation true; got %q", r.method, r.path, got)
+		}
+		expected := `<` + r.successor + `>; rel="successor-version"`
+		if got := resp.Header.Get("Link"); got != expected {
+			t.Errorf("%s %s: expected Link %q; got %q", r.method, r.path, expected, got)
+		}
+	}
+}
+
+func TestLinksOfOtherUsersNotFound(t *testing.T) {
+	ts := newTestServer(t, nil)
+	owner := signInVerified(t, ts, linkOwner)
+	other := signInVerified(t, ts, linkOther)
+	resp, link := createLink(t, ts, owner, map[string]any{"long_url": "https://example.com/", "title": "Mine"})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status Created; got %v", resp.Status)
+	}
+	path := "/api/v1/links/" + link.ShortCode
+
+	requests := []struct {
+		method string
+		body   any
+	}{
+		{"GET", nil},
+		{"PATCH", map[string]any{"title": "Theirs", "original_url": "https://example.org/"}},
+		{"DELETE", nil},
+	}
+	for _, r := range requests {
+		resp, body := ts.do(t, r.method, path, r.body, "Authorization", other)
+		if resp.StatusCode != http.StatusNotFound || message(t, body) != "link not found" {
+			t.Errorf("%s %s: expected link not found; got %v: %s", r.method, path, resp.Status, body)
+		}
+	}
+
+	stored := ts.store.link(link.ShortCode)
+	if stored.Title != "Mine" || stored.OriginalURL != "https://example.com/" || stored.DeletedAt != nil {
+		t.Errorf("expected the link untouched; got %+v", stored)
+	}
+	resp, _ = ts.do(t, "GET", path, nil, "Authorization", owner)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the owner to still see the link; got %v", resp.Status)
+	}
+}
@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/koderkt/teenyurl/internal/auth"
+	"github.com/koderkt/teenyurl/internal/config"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestLockoutDelayBacksOffExponentially(t *testing.T) {
+	lockout := auth.NewLockout(nil, config.Lockout{
+		Threshold: 3,
+		BaseDelay: 30 * time.Second,
+		MaxDelay:  5 * time.Minute,
+	})
+
+	cases := map[int]time.Duration{
+		2: 0,
+		3: 30 * time.Second,
+		4: time.Minute,
+		5: 2 * time.Minute,
+		6: 4 * time.Minute,
+		7: 5 * time.Minute,
+		9: 5 * time.Minute,
+	}
+	for failures, want := range cases {
+		if got := lockout.Delay(failures); got != want {
+			t.Errorf("after %d failures expected %v; got %v", failures, want, got)
+		}
+	}
+}
+
+func TestLockoutLocksAndResets(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	lockout := auth.NewLockout(client, config.Lockout{
+		Threshold: 2,
+		Window:    time.Hour,
+		BaseDelay: time.Minute,
+		MaxDelay:  time.Hour,
+	})
+	ctx := context.Background()
+
+	if d, _ := lockout.Fail(ctx, "Someone@Example.com"); d != 0 {
+		t.Fatalf("expected no lock after one failure; got %v", d)
+	}
+	if d, _ := lockout.Fail(ctx, "someone@example.com "); d != time.Minute {
+		t.Fatalf("expected a one minute lock after two failures; got %v", d)
+	}
+	locked, err := lockout.Locked(ctx, "SOMEONE@example.com")
+	if err != nil {
+		t.Fatalf("error checking lock. Err: %v", err)
+	}
+	if locked <= 0 {
+		t.Error("expected the normalized email to be locked")
+	}
+
+	mr.FastForward(2 * time.Minute)
+	if locked, _ := lockout.Locked(ctx, "someone@example.com"); locked != 0 {
+		t.Errorf("expected the lock to expire; got %v", locked)
+	}
+
+	if err := lockout.Reset(ctx, "someone@example.com"); err != nil {
+		t.Fatalf("error resetting lockout. Err: %v", err)
+	}
+	if d, _ := lockout.Fail(ctx, "someone@example.com"); d != 0 {
+		t.Errorf("expected failures to start over after reset; got %v", d)
+	}
+}
@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koderkt/teenyurl/internal/config"
+	"github.com/koderkt/teenyurl/internal/logging"
+	"github.com/koderkt/teenyurl/internal/types"
+)
+
+func TestLoggerFromContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(config.Log{Level: "warn", Format: "json"}, &buf)
+
+	ctx := logging.WithContext(context.Background(), logger.With("request_id", "req-1"))
+	logging.FromContext(ctx).Info("dropped below level")
+	logging.FromContext(ctx).Warn("kept", "short_code", "abc123")
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected exactly one JSON line; got %q", buf.String())
+	}
+	if line["request_id"] != "req-1" {
+		t.Errorf("expected request_id req-1; got %v", line["request_id"])
+	}
+	if line["msg"] != "kept" || line["short_code"] != "abc123" {
+		t.Errorf("unexpected log line %v", line)
+	}
+	if logging.FromContextOr(context.Background(), logger) != logger {
+		t.Error("expected the fallback logger without one in the context")
+	}
+}
+
+func TestRequestIDEchoedAndLogged(t *testing.T) {
+	ts := newTestServer(t, nil)
+
+	resp, _ := ts.do(t, "GET", "/livez", nil, "X-Request-ID", "req-42")
+	if got := resp.Header.Get("X-Request-ID"); got != "req-42" {
+		t.Errorf("expected the caller's request ID echoed; got %q", got)
+	}
+
+	resp, _ = ts.do(t, "GET", "/livez", nil)
+	generated := resp.Header.Get("X-Request-ID")
+	if generated == "" {
+		t.Fatal("expected a request ID to be generated")
+	}
+
+	// IDs that could pollute the logs are replaced
+	resp, _ = ts.do(t, "GET", "/livez", nil, "X-Request-ID", strings.Repeat("x", 129))
+	if got := resp.Header.Get("X-Request-ID"); got == "" || len(got) > 128 {
+		t.Errorf("expected an overlong request ID replaced; got %q", got)
+	}
+
+	ids := map[any]bool{}
+	for _, line := range ts.logs.lines(t, "request") {
+		ids[line["request_id"]] = true
+	}
+	if !ids["req-42"] || !ids[generated] {
+		t.Errorf("expected both request IDs in the access log; got %v", ids)
+	}
+}
+
+func TestRequestIDInBackgroundJobLogs(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.store.addLink(types.Link{ShortURL: "abc123", OriginalURL: "https://example.com/", IsEnabled: true})
+	ts.store.failOn("InsertAnalytics", errors.New("connection reset"))
+
+	resp, _ := ts.do(t, "GET", "/abc123", nil, "X-Request-ID", "req-click")
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected a redirect; got %v", resp.Status)
+	}
+
+	// the click is stored after the response, so wait for its log line
+	deadline := time.Now().Add(5 * time.Second)
+	var lines []map[string]any
+	for len(lines) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		lines = ts.logs.lines(t, "recording click")
+	}
+	if len(lines) != 1 || lines[0]["request_id"] != "req-click" || lines[0]["short_code"] != "abc123" {
+		t.Errorf("expected the failed click logged under the request ID; got %v", lines)
+	}
+}
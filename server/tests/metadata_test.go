@@ -0,0 +1,205 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koderkt/teenyurl/internal/config"
+	"github.com/koderkt/teenyurl/internal/metadata"
+	"github.com/koderkt/teenyurl/internal/netguard"
+	"github.com/koderkt/teenyurl/internal/types"
+)
+
+const metadataPage = `<!doctype html>
+<html><head>
+<title>  Plain
+  title </title>
+<meta name="description" content="Plain description">
+<meta property="og:title" content="Open &amp; Graph">
+<meta property="og:image" content="/img/preview.png">
+<link rel="shortcut icon" href="icons/fav.ico">
+</head><body><title>not this one</title></body></html>`
+
+func metadataConfig() config.Metadata {
+	cfg := config.Default().Metadata
+	cfg.AllowPrivateNetworks = true
+	return cfg
+}
+
+func TestMetadataParse(t *testing.T) {
+	base, _ := url.Parse("https://example.com/blog/post")
+	meta := metadata.Parse(strings.NewReader(metadataPage), base)
+
+	if meta.Title != "Open & Graph" {
+		t.Errorf("expected og:title to win; got %q", meta.Title)
+	}
+	if meta.Description != "Plain description" {
+		t.Errorf("expected description meta tag; got %q", meta.Description)
+	}
+	if meta.Image != "https://example.com/img/preview.png" {
+		t.Errorf("expected absolute image url; got %q", meta.Image)
+	}
+	if meta.Favicon != "https://example.com/blog/icons/fav.ico" {
+		t.Errorf("expected favicon relative to page; got %q", meta.Favicon)
+	}
+
+	page := `<head><title>Only <b>title</b></title><meta property="og:image" content="javascript:alert(1)"></head>`
+	meta = metadata.Parse(strings.NewReader(page), base)
+	if meta.Title != "Only <b>title</b>" {
+		t.Errorf("expected <title> text; got %q", meta.Title)
+	}
+	if meta.Image != "" {
+		t.Errorf("expected non http image to be dropped; got %q", meta.Image)
+	}
+	if meta.Favicon != "https://example.com/favicon.ico" {
+		t.Errorf("expected default favicon; got %q", meta.Favicon)
+	}
+}
+
+func TestMetadataFetchFollowsRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/start", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/pages/final", http.StatusFound)
+	})
+	mux.HandleFunc("/pages/final", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, metadataPage)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	meta, err := metadata.NewFetcher(metadataConfig()).Fetch(context.Background(), srv.URL+"/start")
+	if err != nil {
+		t.Fatalf("error fetching metadata. Err: %v", err)
+	}
+	if meta.Title != "Open & Graph" {
+		t.Errorf("expected title from the final page; got %q", meta.Title)
+	}
+	if meta.Favicon != srv.URL+"/pages/icons/fav.ico" {
+		t.Errorf("expected favicon relative to the final page; got %q", meta.Favicon)
+	}
+}
+
+func TestMetadataFetchReadsLimitedBytes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><head><!--"+strings.Repeat("x", 4096)+"--><title>late</title></head></html>")
+	}))
+	defer srv.Close()
+
+	cfg := metadataConfig()
+	cfg.MaxBytes = 1024
+	meta, err := metadata.NewFetcher(cfg).Fetch(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatalf("error fetching metadata. Err: %v", err)
+	}
+	if meta.Title != "" {
+		t.Errorf("expected title past the limit to be ignored; got %q", meta.Title)
+	}
+}
+
+func TestMetadataFetchTimesOut(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(500 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	cfg := metadataConfig()
+	cfg.Timeout = 50 * time.Millisecond
+	if _, err := metadata.NewFetcher(cfg).Fetch(context.Background(), srv.URL); err == nil {
+		t.Error("expected a slow page to time out")
+	}
+}
+
+func TestMetadataFetchBlocksPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("expected no request to reach a loopback address")
+	}))
+	defer srv.Close()
+
+	cfg := metadataConfig()
+	cfg.AllowPrivateNetworks = false
+	fetcher := metadata.NewFetcher(cfg)
+	_, err := fetcher.Fetch(context.Background(), srv.URL)
+	if !errors.Is(err, netguard.ErrBlockedAddress) {
+		t.Errorf("expected ErrBlockedAddress; got %v", err)
+	}
+
+	_, err = fetcher.Fetch(context.Background(), "file:///etc/passwd")
+	if !errors.Is(err, metadata.ErrUnsupportedScheme) {
+		t.Errorf("expected ErrUnsupportedScheme; got %v", err)
+	}
+}
+
+func TestNetguardIsPublic(t *testing.T) {
+	cases := map[string]bool{
+		"93.184.216.34":        true,
+		"2606:4700::1111":      true,
+		"127.0.0.1":            false,
+		"10.1.2.3":             false,
+		"172.16.0.1":           false,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false,
+		"100.64.0.1":           false,
+		"0.0.0.0":              false,
+		"::1":                  false,
+		"fd00::1":              false,
+		"fe80::1":              false,
+		"::ffff:127.0.0.1":     false,
+		"::ffff:93.184.216.34": true,
+		"64:ff9b::a00:1":       false,
+	}
+	for ip, expected := range cases {
+		if got := netguard.IsPublic(netip.MustParseAddr(ip)); got != expected {
+			t.Errorf("%s: expected public %v; got %v", ip, expected, got)
+		}
+	}
+}
+
+func TestMetadataWorkerSavesResults(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, metadataPage)
+	}))
+	defer srv.Close()
+
+	store := newFakeStore()
+	store.addLink(types.Link{ShortURL: "found1", OriginalURL: srv.URL})
+	store.addLink(types.Link{ShortURL: "missin", OriginalURL: srv.URL + "/missing"})
+	cfg := metadataConfig()
+	worker := metadata.NewWorker(store, metadata.NewFetcher(cfg), cfg, slog.Default())
+	if err := worker.Enqueue(context.Background(), 1, srv.URL); err != nil {
+		t.Fatalf("error queueing fetch. Err: %v", err)
+	}
+	if err := worker.Enqueue(context.Background(), 2, srv.URL+"/missing"); err != nil {
+		t.Fatalf("error queueing fetch. Err: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := worker.Close(ctx); err != nil {
+		t.Fatalf("error closing worker. Err: %v", err)
+	}
+	if meta := store.link("found1").LinkMetadata; meta.FetchedAt == nil || meta.Title != "Open & Graph" {
+		t.Errorf("expected metadata for link 1; got %+v", meta)
+	}
+	if store.link("missin").LinkMetadata.FetchedAt != nil {
+		t.Error("expected nothing saved for a page that failed")
+	}
+	if err := worker.Enqueue(context.Background(), 3, srv.URL); !errors.Is(err, metadata.ErrWorkerClosed) {
+		t.Errorf("expected ErrWorkerClosed after close; got %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package tests
+
+import (
+	"database/sql"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/koderkt/teenyurl/internal/metrics"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	m := metrics.New()
+	m.RegisterDBStats("teenyurl", func() sql.DBStats {
+		return sql.DBStats{OpenConnections: 3, InUse: 1, Idle: 2}
+	})
+
+	app := fiber.New()
+	app.Use(m.Middleware())
+	app.Get("/metrics", m.Handler())
+	app.Get("/:shortCode", func(c *fiber.Ctx) error {
+		m.Redirect(metrics.RedirectHit)
+		return c.SendStatus(fiber.StatusTemporaryRedirect)
+	})
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", "/abc123", nil)
+		if _, err := app.Test(req); err != nil {
+			t.Fatalf("error making request to server. Err: %v", err)
+		}
+	}
+	m.SignIn(metrics.SignInFailure)
+
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("error making request to server. Err: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("error reading response body. Err: %v", err)
+	}
+
+	for _, want := range []string{
+		`teenyurl_http_requests_total{method="GET",route="/:shortCode",status="307"} 2`,
+		`teenyurl_http_request_duration_seconds_count{method="GET",route="/:shortCode"} 2`,
+		`teenyurl_redirects_total{result="hit"} 2`,
+		`teenyurl_signins_total{result="failure"} 1`,
+		`teenyurl_db_open_connections{db_name="teenyurl"} 3`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("expected metrics output to contain %q", want)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/koderkt/teenyurl/internal/config"
+	"github.com/koderkt/teenyurl/internal/oidc"
+	"github.com/koderkt/teenyurl/internal/oidc/oidctest"
+	"github.com/koderkt/teenyurl/internal/types"
+)
+
+func newOIDCProvider(t *testing.T) (*oidctest.Provider, *oidc.Provider) {
+	fake := oidctest.New("teenyurl", "secret")
+	t.Cleanup(fake.Close)
+	provider := oidc.NewProvider(config.OIDCProvider{
+		Name:         "test",
+		Issuer:       fake.Issuer(),
+		ClientID:     "teenyurl",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8080/auth/oidc/test/callback",
+	})
+	return fake, provider
+}
+
+// authorize follows the provider's login redirect and returns the code
+// from the callback.
+func authorize(t *testing.T, provider *oidc.Provider, state, nonce, verifier string) string {
+	authURL, err := provider.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatalf("error building auth url. Err: %v", err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("error authorizing. Err: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected redirect from provider; got %v", resp.StatusCode)
+	}
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("error parsing callback. Err: %v", err)
+	}
+	if got := callback.Query().Get("state"); got != state {
+		t.Fatalf("expected state %q; got %q", state, got)
+	}
+	return callback.Query().Get("code")
+}
+
+func TestOIDCLogin(t *testing.T) {
+	fake, provider := newOIDCProvider(t)
+	fake.SetUser(oidctest.User{
+		Subject:           "user-1",
+		Email:             "someone@example.com",
+		EmailVerified:     true,
+		PreferredUsername: "someone",
+	})
+
+	verifier := oidc.NewVerifier()
+	code := authorize(t, provider, "state", "nonce", verifier)
+	identity, err := provider.Exchange(context.Background(), code, verifier, "nonce")
+	if err != nil {
+		t.Fatalf("error exchanging code. Err: %v", err)
+	}
+	if identity.Subject != "user-1" || identity.Email != "someone@example.com" || !identity.EmailVerified {
+		t.Errorf("expected identity of the signed in user; got %+v", identity)
+	}
+
+	if _, err := provider.Exchange(context.Background(), code, verifier, "nonce"); err == nil {
+		t.Errorf("expected code to be single use")
+	}
+}
+
+func TestOIDCRequiresPKCEVerifier(t *testing.T) {
+	_, provider := newOIDCProvider(t)
+
+	code := authorize(t, provider, "state", "nonce", oidc.NewVerifier())
+	if _, err := provider.Exchange(context.Background(), code, oidc.NewVerifier(), "nonce"); err == nil {
+		t.Errorf("expected exchange with the wrong verifier to fail")
+	}
+}
+
+func TestOIDCChecksNonce(t *testing.T) {
+	_, provider := newOIDCProvider(t)
+
+	verifier := oidc.NewVerifier()
+	code := authorize(t, provider, "state", "nonce", verifier)
+	_, err := provider.Exchange(context.Background(), code, verifier, "other-nonce")
+	if !errors.Is(err, oidc.ErrNonceMismatch) {
+		t.Errorf("expected ErrNonceMismatch; got %v", err)
+	}
+}
+
+// newOIDCServer starts a test server signing in with a fake provider
+// named test.
+func newOIDCServer(t *testing.T) (*testServer, *oidctest.Provider) {
+	fake := oidctest.New("teenyurl", "secret")
+	t.Cleanup(fake.Close)
+	ts := newTestServer(t, func(cfg *config.Config) {
+		cfg.OIDC.Providers = []config.OIDCProvider{{
+			Name:         "test",
+			Issuer:       fake.Issuer(),
+			ClientID:     "teenyurl",
+			ClientSecret: "secret",
+			RedirectURL:  "http://localhost:8080/auth/oidc/test/callback",
+		}}
+	})
+	return ts, fake
+}
+
+// oidcCallback starts a login and returns the query of the provider's
+// redirect back to the callback, with the state cookie that was set.
+func oidcCallback(t *testing.T, ts *testServer) (url.Values, string) {
+	resp, _ := ts.do(t, "GET", "/auth/oidc/test/login", nil)
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected redirect to the provider; got %v", resp.Status)
+	}
+	var state string
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "oidc_state" {
+			state = cookie.Value
+		}
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	authResp, err := client.Get(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("error authorizing. Err: %v", err)
+	}
+	authResp.Body.Close()
+	callback, err := url.Parse(authResp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("error parsing callback. Err: %v", err)
+	}
+	return callback.Query(), state
+}
+
+// oidcSignIn completes a login and returns the query the client is
+// redirected back with.
+func oidcSignIn(t *testing.T, ts *testServer) url.Values {
+	query, state := oidcCallback(t, ts)
+	return oidcFinish(t, ts, query, state)
+}
+
+func oidcFinish(t *testing.T, ts *testServer, query url.Values, state string) url.Values {
+	resp, body := ts.do(t, "GET", "/auth/oidc/test/callback?"+query.Encode(), nil, "Cookie", "oidc_state="+state)
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected redirect to the client; got %v: %s", resp.Status, body)
+	}
+	redirect, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("error parsing redirect. Err: %v", err)
+	}
+	return redirect.Query()
+}
+
+func TestOIDCCallbackChecksStateCookie(t *testing.T) {
+	ts, _ := newOIDCServer(t)
+
+	query, state := oidcCallback(t, ts)
+	if result := oidcFinish(t, ts, query, "other-state"); result.Get("error") != "invalid_state" {
+		t.Errorf("expected invalid_state for a cookie from another login; got %v", result)
+	}
+	if result := oidcFinish(t, ts, query, ""); result.Get("error") != "invalid_state" {
+		t.Errorf("expected invalid_state without the cookie; got %v", result)
+	}
+	if len(ts.store.users) != 0 {
+		t.Errorf("expected no account created; got %v", len(ts.store.users))
+	}
+
+	if result := oidcFinish(t, ts, query, state); result.Get("code") == "" {
+		t.Errorf("expected a code with the right cookie; got %v", result)
+	}
+}
+
+func TestOIDCSignInCreatesThenReusesUser(t *testing.T) {
+	ts, fake := newOIDCServer(t)
+	fake.SetUser(oidctest.User{Subject: "user-1", Email: "someone@example.com", EmailVerified: true, PreferredUsername: "someone"})
+
+	result := oidcSignIn(t, ts)
+	resp, body := ts.do(t, "POST", "/auth/oidc/exchange", map[string]any{"code": result.Get("code")})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status OK from the exchange; got %v: %s", resp.Status, body)
+	}
+	user, err := ts.store.GetUserByEmail(context.Background(), "someone@example.com")
+	if err != nil {
+		t.Fatalf("error finding the new account. Err: %v", err)
+	}
+	if user.UserName != "someone" || user.EmailVerifiedAt == nil || user.EncryptedPassword != "" {
+		t.Errorf("expected a verified account without a password; got %+v", user)
+	}
+
+	// a subject seen before keeps its account, even with another email
+	fake.SetUser(oidctest.User{Subject: "user-1", Email: "renamed@example.com", EmailVerified: false})
+	if result := oidcSignIn(t, ts); result.Get("code") == "" {
+		t.Fatalf("expected a code for a known subject; got %v", result)
+	}
+	if len(ts.store.users) != 1 {
+		t.Errorf("expected the same account; got %v accounts", len(ts.store.users))
+	}
+}
+
+func TestOIDCRefusesUnverifiedProviderEmail(t *testing.T) {
+	ts, fake := newOIDCServer(t)
+	fake.SetUser(oidctest.User{Subject: "user-1", Email: "someone@example.com", EmailVerified: false})
+
+	result := oidcSignIn(t, ts)
+	if result.Get("error") != "account_not_linked" || result.Get("code") != "" {
+		t.Errorf("expected account_not_linked; got %v", result)
+	}
+	if len(ts.store.users) != 0 {
+		t.Errorf("expected no account created; got %v", len(ts.store.users))
+	}
+}
+
+func TestOIDCRefusesUnverifiedAccount(t *testing.T) {
+	ts, fake := newOIDCServer(t)
+	ts.store.addUser(&types.User{ID: 5, UserName: "squatter", Email: "someone@example.com"})
+	fake.SetUser(oidctest.User{Subject: "user-1", Email: "someone@example.com", EmailVerified: true})
+
+	result := oidcSignIn(t, ts)
+	if result.Get("error") != "account_not_linked" || result.Get("code") != "" {
+		t.Errorf("expected account_not_linked; got %v", result)
+	}
+	if _, err := ts.store.GetUserByIdentity(context.Background(), "test", "user-1"); err == nil {
+		t.Error("expected the identity not linked to the unverified account")
+	}
+}
+
+func TestOIDCSignUpRollsBack(t *testing.T) {
+	ts, fake := newOIDCServer(t)
+	fake.SetUser(oidctest.User{Subject: "user-1", Email: "someone@example.com", EmailVerified: true})
+	ts.store.failOn("LinkIdentity", errors.New("connection reset"))
+
+	if result := oidcSignIn(t, ts); result.Get("error") != "server_error" {
+		t.Fatalf("expected server_error; got %v", result)
+	}
+	if len(ts.store.users) != 0 {
+		t.Errorf("expected no account left behind; got %v", len(ts.store.users))
+	}
+
+	ts.store.failOn("LinkIdentity", nil)
+	if result := oidcSignIn(t, ts); result.Get("code") == "" {
+		t.Errorf("expected the next sign in to succeed; got %v", result)
+	}
+}
@@ -0,0 +1,152 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/koderkt/teenyurl/internal/qr"
+)
+
+func qrOptions() qr.Options {
+	return qr.Options{
+		Size:       256,
+		Level:      "M",
+		Margin:     4,
+		Foreground: color.NRGBA{A: 0xff},
+		Background: color.NRGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff},
+	}
+}
+
+func TestQRPNG(t *testing.T) {
+	data, err := qr.PNG("https://teeny.example/abc123", qrOptions())
+	if err != nil {
+		t.Fatalf("error rendering png. Err: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("error decoding png. Err: %v", err)
+	}
+	if img.Bounds().Dx() != 256 || img.Bounds().Dy() != 256 {
+		t.Fatalf("expected 256x256; got %v", img.Bounds())
+	}
+
+	// the quiet zone is background, the finder pattern corner is dark
+	if r, _, _, _ := img.At(2, 2).RGBA(); r != 0xffff {
+		t.Errorf("expected quiet zone to be background")
+	}
+	var dark image.Point
+	for i := 0; i < 128; i++ {
+		if r, _, _, _ := img.At(i, i).RGBA(); r == 0 {
+			dark = image.Pt(i, i)
+			break
+		}
+	}
+	if dark == (image.Point{}) || dark.X < 4 {
+		t.Errorf("expected the finder pattern after the quiet zone; got %v", dark)
+	}
+}
+
+func TestQRTooSmall(t *testing.T) {
+	opts := qrOptions()
+	opts.Size = 20
+	if _, err := qr.PNG("https://teeny.example/abc123", opts); !errors.Is(err, qr.ErrTooSmall) {
+		t.Errorf("expected ErrTooSmall; got %v", err)
+	}
+}
+
+func TestQRSVG(t *testing.T) {
+	opts := qrOptions()
+	opts.Foreground = color.NRGBA{R: 0x11, G: 0x22, B: 0x33, A: 0xff}
+	opts.Background = color.NRGBA{}
+	data, err := qr.SVG("https://teeny.example/abc123", opts)
+	if err != nil {
+		t.Fatalf("error rendering svg. Err: %v", err)
+	}
+
+	var svg struct {
+		XMLName xml.Name `xml:"svg"`
+		Width   string   `xml:"width,attr"`
+		Path    struct {
+			Fill string `xml:"fill,attr"`
+			D    string `xml:"d,attr"`
+		} `xml:"path"`
+	}
+	if err := xml.Unmarshal(data, &svg); err != nil {
+		t.Fatalf("error parsing svg. Err: %v", err)
+	}
+	if svg.Width != "256" {
+		t.Errorf("expected width 256; got %q", svg.Width)
+	}
+	if svg.Path.Fill != "#112233" || !strings.HasPrefix(svg.Path.D, "M4 4h7") {
+		t.Errorf("expected colored path starting at the finder pattern; got fill %q, d %.20q", svg.Path.Fill, svg.Path.D)
+	}
+	if !strings.Contains(string(data), `fill-opacity="0.000"`) {
+		t.Errorf("expected transparent background")
+	}
+}
+
+func TestQRLogo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logo.png")
+	logoImg := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	for i := range logoImg.Pix {
+		logoImg.Pix[i] = 0xff
+	}
+	for i := 1; i < len(logoImg.Pix); i += 4 {
+		logoImg.Pix[i] = 0 // magenta
+	}
+	var b bytes.Buffer
+	png.Encode(&b, logoImg)
+	if err := os.WriteFile(path, b.Bytes(), 0o644); err != nil {
+		t.Fatalf("error writing logo. Err: %v", err)
+	}
+
+	logo, err := qr.LoadLogo(path)
+	if err != nil {
+		t.Fatalf("error loading logo. Err: %v", err)
+	}
+	opts := qrOptions()
+	opts.Logo = logo
+
+	data, err := qr.PNG("https://teeny.example/abc123", opts)
+	if err != nil {
+		t.Fatalf("error rendering png. Err: %v", err)
+	}
+	img, _ := png.Decode(bytes.NewReader(data))
+	r, g, bl, _ := img.At(128, 128).RGBA()
+	if r != 0xffff || g != 0 || bl != 0xffff {
+		t.Errorf("expected logo in the center; got %v %v %v", r, g, bl)
+	}
+
+	svg, err := qr.SVG("https://teeny.example/abc123", opts)
+	if err != nil {
+		t.Fatalf("error rendering svg. Err: %v", err)
+	}
+	if !strings.Contains(string(svg), `href="data:image/png;base64,`) {
+		t.Errorf("expected embedded logo in svg")
+	}
+}
+
+func TestQRParseColor(t *testing.T) {
+	cases := map[string]color.NRGBA{
+		"#000":      {A: 0xff},
+		"ff8800":    {R: 0xff, G: 0x88, A: 0xff},
+		"#11223344": {R: 0x11, G: 0x22, B: 0x33, A: 0x44},
+	}
+	for in, want := range cases {
+		got, err := qr.ParseColor(in)
+		if err != nil || got != want {
+			t.Errorf("%q: expected %v; got %v (%v)", in, want, got, err)
+		}
+	}
+	if _, err := qr.ParseColor("blue"); err == nil {
+		t.Errorf("expected error for named color")
+	}
+}
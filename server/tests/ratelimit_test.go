@@ -0,0 +1,71 @@
+package tests
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/koderkt/teenyurl/internal/config"
+	"github.com/koderkt/teenyurl/internal/ratelimit"
+	"github.com/redis/go-redis/v9"
+)
+
+func exerciseLimiter(t *testing.T, limiter ratelimit.Limiter) {
+	t.Helper()
+	limit := config.Limit{Requests: 3, Window: time.Minute}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		res, err := limiter.Allow(ctx, "auth:ip:10.0.0.1", limit)
+		if err != nil {
+			t.Fatalf("error checking limit. Err: %v", err)
+		}
+		if !res.Allowed {
+			t.Fatalf("expected request %d to be allowed", i+1)
+		}
+		if res.Remaining != 2-i {
+			t.Errorf("expected %d remaining; got %v", 2-i, res.Remaining)
+		}
+	}
+
+	res, err := limiter.Allow(ctx, "auth:ip:10.0.0.1", limit)
+	if err != nil {
+		t.Fatalf("error checking limit. Err: %v", err)
+	}
+	if res.Allowed {
+		t.Error("expected the fourth request to be rejected")
+	}
+	if res.Reset <= 0 || res.Reset > time.Minute {
+		t.Errorf("expected reset within the window; got %v", res.Reset)
+	}
+
+	// other keys have their own window
+	res, _ = limiter.Allow(ctx, "auth:ip:10.0.0.2", limit)
+	if !res.Allowed {
+		t.Error("expected a different IP to be allowed")
+	}
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	exerciseLimiter(t, ratelimit.NewMemory())
+}
+
+func TestRedisLimiter(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	exerciseLimiter(t, ratelimit.NewRedis(client))
+}
+
+func TestFallbackWhenRedisIsDown(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	mr.Close()
+
+	exerciseLimiter(t, &ratelimit.Fallback{
+		Primary:   ratelimit.NewRedis(client),
+		Secondary: ratelimit.NewMemory(),
+		Logger:    slog.Default(),
+	})
+}
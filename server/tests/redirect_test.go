@@ -0,0 +1,161 @@
+package tests
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koderkt/teenyurl/internal/config"
+	"github.com/koderkt/teenyurl/internal/types"
+)
+
+func TestPreviewSuffix(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.store.addLink(types.Link{ShortURL: "abc123", OriginalURL: "https://example.com/page", IsEnabled: true})
+
+	resp, _ := ts.do(t, "GET", "/abc123", nil)
+	if resp.StatusCode != http.StatusFound || resp.Header.Get("Location") != "https://example.com/page" {
+		t.Errorf("expected a redirect; got %v to %q", resp.Status, resp.Header.Get("Location"))
+	}
+
+	resp, body := ts.do(t, "GET", "/abc123+", nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Location") != "" {
+		t.Fatalf("expected the preview page; got %v to %q", resp.Status, resp.Header.Get("Location"))
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
+		t.Errorf("expected an HTML page; got %q", resp.Header.Get("Content-Type"))
+	}
+	if !strings.Contains(string(body), `href="https://example.com/page"`) {
+		t.Errorf("expected a link to the destination; got %s", body)
+	}
+
+	resp, _ = ts.do(t, "GET", "/missing+", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected status Not Found for a missing link; got %v", resp.Status)
+	}
+}
+
+func TestPreviewShownInsteadOfRedirect(t *testing.T) {
+	ts := newTestServer(t, func(cfg *config.Config) {
+		cfg.URLPolicy.AllowDomains = []string{"example.com"}
+		cfg.URLPolicy.AllowMode = "warn"
+	})
+	ts.store.addLink(types.Link{ShortURL: "asked1", OriginalURL: "https://example.com/", IsEnabled: true, Preview: true})
+	ts.store.addLink(types.Link{ShortURL: "elsewh", OriginalURL: "https://elsewhere.example/", IsEnabled: true})
+
+	resp, body := ts.do(t, "GET", "/asked1", nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "Continue to example.com") {
+		t.Errorf("expected the preview for a link asking for it; got %v: %s", resp.Status, body)
+	}
+
+	resp, body = ts.do(t, "GET", "/elsewh", nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "elsewhere.example is not one of the sites this service trusts") {
+		t.Errorf("expected the untrusted warning; got %v: %s", resp.Status, body)
+	}
+	if !strings.Contains(string(body), `class="continue"`) {
+		t.Errorf("expected a way through for an untrusted domain; got %s", body)
+	}
+}
+
+func TestFlaggedLinkBlocked(t *testing.T) {
+	ts := newTestServer(t, nil)
+	now := time.Now()
+	ts.store.addLink(types.Link{ShortURL: "flag12", OriginalURL: "https://evil.example/", FlaggedAt: &now, FlagReason: "phishing"})
+
+	for _, path := range []string{"/flag12", "/flag12+"} {
+		resp, body := ts.do(t, "GET", path, nil)
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s: expected status Forbidden; got %v", path, resp.Status)
+		}
+		if !strings.Contains(string(body), "The destination is listed as phishing") {
+			t.Errorf("%s: expected the threat on the page; got %s", path, body)
+		}
+		if strings.Contains(string(body), `class="continue"`) || strings.Contains(string(body), `href="https://evil.example/"`) {
+			t.Errorf("%s: expected no link to continue; got %s", path, body)
+		}
+	}
+}
+
+func TestPreviewEscapesLinkDetails(t *testing.T) {
+	ts := newTestServer(t, nil)
+	ts.store.addLink(types.Link{
+		ShortURL:    "xss123",
+		OriginalURL: `https://example.com/?q="><script>alert(1)</script>`,
+		Title:       "<img src=x onerror=alert(1)>",
+		IsEnabled:   true,
+	})
+
+	resp, body := ts.do(t, "GET", "/xss123+", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status OK; got %v", resp.Status)
+	}
+	page := string(body)
+	if strings.Contains(page, "<script>") || strings.Contains(page, "<img") {
+		t.Errorf("expected the title and destination escaped; got %s", page)
+	}
+	if !strings.Contains(page, "&lt;img src=x onerror=alert(1)&gt;") {
+		t.Errorf("expected the escaped title; got %s", page)
+	}
+}
+
+func TestRedirectStatusPerLink(t *testing.T) {
+	ts := newTestServer(t, func(cfg *config.Config) {
+		cfg.Links.PermanentMaxAge = time.Hour
+	})
+	cases := []struct {
+		code     string
+		status   int
+		expected int
+		cache    string
+	}{
+		{"dflt01", 0, http.StatusFound, "private, no-store"},
+		{"perm01", http.StatusMovedPermanently, http.StatusMovedPermanently, "public, max-age=3600"},
+		{"temp01", http.StatusFound, http.StatusFound, "private, no-store"},
+		{"temp07", http.StatusTemporaryRedirect, http.StatusTemporaryRedirect, "private, no-store"},
+		{"perm08", http.StatusPermanentRedirect, http.StatusPermanentRedirect, "public, max-age=3600"},
+	}
+	for _, tc := range cases {
+		ts.store.addLink(types.Link{ShortURL: tc.code, OriginalURL: "https://example.com/", IsEnabled: true, RedirectStatus: tc.status})
+		resp, _ := ts.do(t, "GET", "/"+tc.code, nil)
+		if resp.StatusCode != tc.expected {
+			t.Errorf("%s: expected status %d; got %v", tc.code, tc.expected, resp.Status)
+		}
+		if got := resp.Header.Get("Cache-Control"); got != tc.cache {
+			t.Errorf("%s: expected Cache-Control %q; got %q", tc.code, tc.cache, got)
+		}
+	}
+}
+
+func TestRedirectStatusValidated(t *testing.T) {
+	ts := newTestServer(t, nil)
+	token := signInVerified(t, ts, linkOwner)
+
+	resp, body := ts.do(t, "POST", "/api/v1/links", map[string]any{
+		"long_url":        "https://example.com/",
+		"redirect_status": 303,
+	}, "Authorization", token)
+	if resp.StatusCode != http.StatusBadRequest || message(t, body) != "redirect_status must be 301, 302, 307 or 308" {
+		t.Errorf("expected an invalid status refused on create; got %v: %s", resp.Status, body)
+	}
+
+	resp, link := createLink(t, ts, token, map[string]any{"long_url": "https://example.com/", "redirect_status": 308})
+	if resp.StatusCode != http.StatusCreated || link.RedirectStatus != 308 {
+		t.Fatalf("expected a link with status 308; got %v: %+v", resp.Status, link)
+	}
+	resp, body = ts.do(t, "PATCH", "/api/v1/links/"+link.ShortCode, map[string]any{"redirect_status": 200}, "Authorization", token)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected an invalid status refused on update; got %v: %s", resp.Status, body)
+	}
+	if stored := ts.store.link(link.ShortCode); stored.RedirectStatus != 308 {
+		t.Errorf("expected the status kept; got %v", stored.RedirectStatus)
+	}
+
+	// 0 goes back to the server default
+	resp, body = ts.do(t, "PATCH", "/api/v1/links/"+link.ShortCode, map[string]any{"redirect_status": 0}, "Authorization", token)
+	var updated types.LinkResponse
+	decode(t, body, &updated)
+	if resp.StatusCode != http.StatusOK || updated.RedirectStatus != ts.cfg.Links.RedirectStatus {
+		t.Errorf("expected the server default; got %v: %s", resp.Status, body)
+	}
+}
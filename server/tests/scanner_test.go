@@ -0,0 +1,238 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/koderkt/teenyurl/internal/config"
+	"github.com/koderkt/teenyurl/internal/scanner"
+	"github.com/koderkt/teenyurl/internal/types"
+)
+
+func TestBlocklistExpressions(t *testing.T) {
+	expressions, err := scanner.Expressions("http://A.b.Example.com./1/./2.html?x=1#frag")
+	if err != nil {
+		t.Fatalf("error building expressions. Err: %v", err)
+	}
+	expected := []string{
+		"a.b.example.com/1/2.html?x=1",
+		"a.b.example.com/1/2.html",
+		"a.b.example.com/",
+		"a.b.example.com/1/",
+		"b.example.com/1/2.html?x=1",
+		"b.example.com/1/2.html",
+		"b.example.com/",
+		"b.example.com/1/",
+		"example.com/1/2.html?x=1",
+		"example.com/1/2.html",
+		"example.com/",
+		"example.com/1/",
+	}
+	if !slices.Equal(expressions, expected) {
+		t.Errorf("expected %v; got %v", expected, expressions)
+	}
+
+	expressions, _ = scanner.Expressions("http://1.2.3.4/")
+	if !slices.Equal(expressions, []string{"1.2.3.4/"}) {
+		t.Errorf("expected only the address for an ip host; got %v", expressions)
+	}
+}
+
+func writeBlocklist(t *testing.T, name string, lines ...string) {
+	t.Helper()
+	content := "# test list\n\n"
+	for _, line := range lines {
+		content += line + "\n"
+	}
+	if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+		t.Fatalf("error writing blocklist. Err: %v", err)
+	}
+}
+
+func TestBlocklistScan(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "blocklist.txt")
+	writeBlocklist(t, name,
+		"phishing "+scanner.Hash("evil.example/")[:8],
+		"malware "+scanner.Hash("example.com/shared/login.html"),
+	)
+	list, err := scanner.NewBlocklist(name)
+	if err != nil {
+		t.Fatalf("error loading blocklist. Err: %v", err)
+	}
+
+	cases := map[string]string{
+		"https://evil.example":                         "phishing",
+		"https://www.EVIL.example/account/verify?id=1": "phishing",
+		"https://example.com/shared/login.html":        "malware",
+		"https://example.com/shared/login.html?a=b":    "malware",
+		"https://example.com/shared/other.html":        "",
+		"https://notevil.example/":                     "",
+	}
+	for raw, threat := range cases {
+		verdict, err := list.Scan(context.Background(), raw)
+		if err != nil {
+			t.Fatalf("error scanning %s. Err: %v", raw, err)
+		}
+		if verdict.Threat != threat {
+			t.Errorf("%s: expected threat %q; got %q", raw, threat, verdict.Threat)
+		}
+	}
+
+	writeBlocklist(t, name, "unwanted "+scanner.Hash("notevil.example/")[:10])
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(name, later, later)
+	if err := list.Reload(); err != nil {
+		t.Fatalf("error reloading blocklist. Err: %v", err)
+	}
+	if verdict, _ := list.Scan(context.Background(), "https://notevil.example/"); verdict.Threat != "unwanted" {
+		t.Errorf("expected reloaded entry to match; got %+v", verdict)
+	}
+	if verdict, _ := list.Scan(context.Background(), "https://evil.example/"); verdict.Flagged() {
+		t.Errorf("expected removed entry to no longer match; got %+v", verdict)
+	}
+
+	writeBlocklist(t, name, "phishing abc")
+	later = later.Add(time.Minute)
+	os.Chtimes(name, later, later)
+	if err := list.Reload(); err == nil {
+		t.Error("expected an error for a short hash prefix")
+	}
+	if verdict, _ := list.Scan(context.Background(), "https://notevil.example/"); !verdict.Flagged() {
+		t.Error("expected the previous list to be kept after a failed reload")
+	}
+}
+
+func TestRecheckerFlagsListedLinks(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "blocklist.txt")
+	writeBlocklist(t, name, "phishing "+scanner.Hash("evil.example/")[:8])
+	list, err := scanner.NewBlocklist(name)
+	if err != nil {
+		t.Fatalf("error loading blocklist. Err: %v", err)
+	}
+
+	store := newFakeStore()
+	var codes []string
+	for i := 1; i <= 7; i++ {
+		host := "good.example"
+		if i%3 == 0 {
+			host = "evil.example"
+		}
+		codes = append(codes, fmt.Sprintf("code%d", i))
+		store.addLink(types.Link{
+			ShortURL:    codes[i-1],
+			OriginalURL: fmt.Sprintf("https://%s/%d", host, i),
+			IsEnabled:   true,
+		})
+	}
+	pending := func() int {
+		n := 0
+		for _, code := range codes {
+			if store.link(code).ScannedAt == nil {
+				n++
+			}
+		}
+		return n
+	}
+	cfg := config.Default().Scanner
+	cfg.BatchSize = 2
+	rechecker := scanner.NewRechecker(store, list, cfg, slog.Default())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := rechecker.Close(ctx); err != nil {
+		t.Fatalf("error closing rechecker. Err: %v", err)
+	}
+
+	for _, code := range codes {
+		link := store.link(code)
+		listed := link.Id%3 == 0
+		if link.ScannedAt == nil {
+			t.Errorf("%s: expected link to be scanned", link.ShortURL)
+		}
+		if listed != (link.FlaggedAt != nil) || listed == link.IsEnabled {
+			t.Errorf("%s: expected flagged %v; got flagged_at %v, enabled %v", link.ShortURL, listed, link.FlaggedAt, link.IsEnabled)
+		}
+		if listed && link.FlagReason != "phishing" {
+			t.Errorf("%s: expected reason phishing; got %q", link.ShortURL, link.FlagReason)
+		}
+	}
+}
+
+// flakyScanner fails for destinations on down.example and counts scans.
+type flakyScanner struct {
+	mu    sync.Mutex
+	scans map[string]int
+}
+
+func (f *flakyScanner) Scan(ctx context.Context, rawURL string) (scanner.Verdict, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.scans[rawURL]++
+	if strings.Contains(rawURL, "down.example") {
+		return scanner.Verdict{}, errors.New("lookup timed out")
+	}
+	return scanner.Verdict{}, nil
+}
+
+func TestRecheckerLeavesFailedScansPending(t *testing.T) {
+	store := newFakeStore()
+	flaky := &flakyScanner{scans: map[string]int{}}
+	var codes []string
+	for i := 1; i <= 5; i++ {
+		host := "good.example"
+		if i%2 == 0 {
+			host = "down.example"
+		}
+		codes = append(codes, fmt.Sprintf("code%d", i))
+		store.addLink(types.Link{
+			ShortURL:    codes[i-1],
+			OriginalURL: fmt.Sprintf("https://%s/%d", host, i),
+			IsEnabled:   true,
+		})
+	}
+	scanned := func() int {
+		flaky.mu.Lock()
+		defer flaky.mu.Unlock()
+		return len(flaky.scans)
+	}
+	cfg := config.Default().Scanner
+	cfg.BatchSize = 2
+	rechecker := scanner.NewRechecker(store, flaky, cfg, slog.Default())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for scanned() < len(codes) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := rechecker.Close(ctx); err != nil {
+		t.Fatalf("error closing rechecker. Err: %v", err)
+	}
+
+	for _, code := range codes {
+		link := store.link(code)
+		failed := strings.Contains(link.OriginalURL, "down.example")
+		if failed != (link.ScannedAt == nil) {
+			t.Errorf("%s: expected scanned %v; got scanned_at %v", code, !failed, link.ScannedAt)
+		}
+		if n := flaky.scans[link.OriginalURL]; n != 1 {
+			t.Errorf("%s: expected one scan in the pass; got %v", code, n)
+		}
+		if link.FlaggedAt != nil || !link.IsEnabled {
+			t.Errorf("%s: expected the link left enabled", code)
+		}
+	}
+}
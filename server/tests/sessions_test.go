@@ -0,0 +1,232 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/koderkt/teenyurl/internal/auth"
+	"github.com/koderkt/teenyurl/internal/config"
+	"github.com/koderkt/teenyurl/internal/types"
+	"github.com/redis/go-redis/v9"
+)
+
+func newSessions(t *testing.T) (*miniredis.Miniredis, *auth.Sessions) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	return mr, auth.NewSessions(client, time.Hour)
+}
+
+func createSession(t *testing.T, sessions *auth.Sessions, userId int, created time.Time) string {
+	id, err := sessions.Create(context.Background(), &types.Session{
+		UserSession: types.UserSession{Id: userId},
+		CreatedAt:   created,
+		LastSeenAt:  created,
+		IP:          "10.0.0.1",
+		UserAgent:   "test",
+	})
+	if err != nil {
+		t.Fatalf("error creating session. Err: %v", err)
+	}
+	return id
+}
+
+func TestSessionsListNewestFirst(t *testing.T) {
+	mr, sessions := newSessions(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	older := createSession(t, sessions, 1, now.Add(-time.Minute))
+	newer := createSession(t, sessions, 1, now)
+	createSession(t, sessions, 2, now)
+
+	list, err := sessions.List(ctx, 1)
+	if err != nil {
+		t.Fatalf("error listing sessions. Err: %v", err)
+	}
+	if len(list) != 2 || list[0].ID != newer || list[1].ID != older {
+		t.Fatalf("expected user 1's two sessions newest first; got %+v", list)
+	}
+
+	// an expired session drops out of the index
+	mr.Del("session:" + older)
+	list, err = sessions.List(ctx, 1)
+	if err != nil {
+		t.Fatalf("error listing sessions. Err: %v", err)
+	}
+	if len(list) != 1 || list[0].ID != newer {
+		t.Errorf("expected only the live session; got %+v", list)
+	}
+	if members, _ := mr.ZMembers("user_sessions:1"); len(members) != 1 {
+		t.Errorf("expected stale index entry to be removed; got %v", members)
+	}
+}
+
+func TestSessionsRevokeAllKeepsCurrent(t *testing.T) {
+	_, sessions := newSessions(t)
+	ctx := context.Background()
+
+	current := createSession(t, sessions, 1, time.Now())
+	createSession(t, sessions, 1, time.Now())
+	createSession(t, sessions, 1, time.Now())
+
+	revoked, err := sessions.RevokeAll(ctx, 1, current)
+	if err != nil {
+		t.Fatalf("error revoking sessions. Err: %v", err)
+	}
+	if revoked != 2 {
+		t.Errorf("expected 2 sessions revoked; got %d", revoked)
+	}
+	if _, err := sessions.Get(ctx, current); err != nil {
+		t.Errorf("expected current session to survive; got %v", err)
+	}
+	if list, _ := sessions.List(ctx, 1); len(list) != 1 {
+		t.Errorf("expected one session left; got %d", len(list))
+	}
+}
+
+func TestSessionsTouch(t *testing.T) {
+	_, sessions := newSessions(t)
+	ctx := context.Background()
+	created := time.Now().Add(-time.Hour)
+	id := createSession(t, sessions, 1, created)
+
+	session, err := sessions.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("error getting session. Err: %v", err)
+	}
+	seen := time.Now()
+	if err := sessions.Touch(ctx, id, session, "10.0.0.2", seen); err != nil {
+		t.Fatalf("error touching session. Err: %v", err)
+	}
+	session, _ = sessions.Get(ctx, id)
+	if session.LastSeenAt.Unix() != seen.Unix() {
+		t.Errorf("expected last seen %v; got %v", seen, session.LastSeenAt)
+	}
+	if session.IP != "10.0.0.2" || session.CreatedAt.Unix() != created.Unix() {
+		t.Errorf("expected new ip and unchanged creation time; got %+v", session)
+	}
+
+	// a revoked session isn't recreated by a late touch
+	sessions.Delete(ctx, 1, id)
+	session.LastSeenAt = time.Time{}
+	if err := sessions.Touch(ctx, id, session, "10.0.0.3", time.Now()); err != nil {
+		t.Fatalf("error touching session. Err: %v", err)
+	}
+	if _, err := sessions.Get(ctx, id); err == nil {
+		t.Errorf("expected revoked session to stay gone")
+	}
+}
+
+const accountSecret = "account-secret-account-secret-account"
+
+func newJWTServer(t *testing.T) *testServer {
+	return newTestServer(t, func(cfg *config.Config) {
+		cfg.Session.Mode = "jwt"
+		cfg.Session.JWT = jwtConfig("k1", "k1:"+newSecret)
+		cfg.Session.JWT.RefreshTokenTTL = time.Hour
+		cfg.Account.TokenSecret = accountSecret
+	})
+}
+
+type jwtSignIn struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func jwtSignInAs(t *testing.T, ts *testServer, user types.UserSession) jwtSignIn {
+	resp, body := exchangeOIDCLogin(t, ts, user)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status OK; got %v: %s", resp.Status, body)
+	}
+	var tokens jwtSignIn
+	decode(t, body, &tokens)
+	return tokens
+}
+
+func refreshStatus(t *testing.T, ts *testServer, token string) int {
+	resp, _ := ts.do(t, "POST", "/token/refresh", map[string]string{"refresh_token": token})
+	return resp.StatusCode
+}
+
+func TestJWTSessionsListedAndRevoked(t *testing.T) {
+	ts := newJWTServer(t)
+	user := types.UserSession{Id: 3, UserName: "someone", Email: "someone@example.com"}
+	first := jwtSignInAs(t, ts, user)
+	second := jwtSignInAs(t, ts, user)
+
+	resp, body := ts.do(t, "GET", "/account/sessions", nil, "Authorization", "Bearer "+first.AccessToken)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status OK; got %v: %s", resp.Status, body)
+	}
+	var active []types.ActiveSession
+	decode(t, body, &active)
+	if len(active) != 2 {
+		t.Fatalf("expected a session per sign in; got %s", body)
+	}
+	current := 0
+	for _, session := range active {
+		if session.Current {
+			current++
+		}
+	}
+	if current != 1 {
+		t.Errorf("expected exactly one current session; got %s", body)
+	}
+
+	resp, body = ts.do(t, "DELETE", "/account/sessions", nil, "Authorization", "Bearer "+first.AccessToken)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status OK; got %v: %s", resp.Status, body)
+	}
+	if status := refreshStatus(t, ts, second.RefreshToken); status != http.StatusUnauthorized {
+		t.Errorf("expected the other sign in's refresh token to be revoked; got %v", status)
+	}
+	if status := refreshStatus(t, ts, first.RefreshToken); status != http.StatusOK {
+		t.Errorf("expected the current refresh token to keep working; got %v", status)
+	}
+}
+
+func TestJWTRevokeOneSession(t *testing.T) {
+	ts := newJWTServer(t)
+	user := types.UserSession{Id: 3, UserName: "someone", Email: "someone@example.com"}
+	first := jwtSignInAs(t, ts, user)
+	second := jwtSignInAs(t, ts, user)
+
+	_, body := ts.do(t, "GET", "/account/sessions", nil, "Authorization", "Bearer "+first.AccessToken)
+	var active []types.ActiveSession
+	decode(t, body, &active)
+	var other string
+	for _, session := range active {
+		if !session.Current {
+			other = session.Id
+		}
+	}
+	resp, body := ts.do(t, "DELETE", "/account/sessions/"+other, nil, "Authorization", "Bearer "+first.AccessToken)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected status No Content; got %v: %s", resp.Status, body)
+	}
+	if status := refreshStatus(t, ts, second.RefreshToken); status != http.StatusUnauthorized {
+		t.Errorf("expected the revoked refresh token to be refused; got %v", status)
+	}
+}
+
+func TestPasswordResetRevokesJWTSessions(t *testing.T) {
+	ts := newJWTServer(t)
+	ts.store.addUser(&types.User{ID: 3, UserName: "someone", Email: "someone@example.com", EncryptedPassword: "old"})
+	tokens := jwtSignInAs(t, ts, types.UserSession{Id: 3, UserName: "someone", Email: "someone@example.com"})
+
+	accountTokens, _, err := auth.NewAccountTokens(ts.cfg.Account)
+	if err != nil {
+		t.Fatalf("error creating account tokens. Err: %v", err)
+	}
+	reset := accountTokens.Sign(auth.PurposeResetPassword, 3, auth.PasswordStamp("old"))
+	resp, body := ts.do(t, "POST", "/password/reset", map[string]string{"token": reset, "password": "New-password1"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status OK; got %v: %s", resp.Status, body)
+	}
+	if status := refreshStatus(t, ts, tokens.RefreshToken); status != http.StatusUnauthorized {
+		t.Errorf("expected refresh tokens to be revoked by the reset; got %v", status)
+	}
+}
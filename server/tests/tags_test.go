@@ -0,0 +1,268 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koderkt/teenyurl/internal/types"
+)
+
+var (
+	linkOwner = types.UserSession{Id: 11, UserName: "grace", Email: "grace@example.com"}
+	linkOther = types.UserSession{Id: 12, UserName: "alan", Email: "alan@example.com"}
+)
+
+// signInVerified adds user with a verified email and signs them in.
+func signInVerified(t *testing.T, ts *testServer, user types.UserSession) string {
+	now := time.Now()
+	ts.store.addUser(&types.User{ID: user.Id, UserName: user.UserName, Email: user.Email, EmailVerifiedAt: &now})
+	return "Bearer " + ts.signIn(t, user)
+}
+
+func createLink(t *testing.T, ts *testServer, token string, body map[string]any) (*http.Response, types.LinkResponse) {
+	resp, data := ts.do(t, "POST", "/api/v1/links", body, "Authorization", token)
+	var link types.LinkResponse
+	if resp.StatusCode == http.StatusCreated {
+		decode(t, data, &link)
+	}
+	return resp, link
+}
+
+func message(t *testing.T, body []byte) string {
+	var msg struct {
+		Message string `json:"message"`
+	}
+	decode(t, body, &msg)
+	return msg.Message
+}
+
+func TestLinkTagNamesCleaned(t *testing.T) {
+	ts := newTestServer(t, nil)
+	token := signInVerified(t, ts, linkOwner)
+
+	resp, link := createLink(t, ts, token, map[string]any{
+		"long_url": "https://example.com/",
+		"tags":     []string{" Go ", "go", "web", "WEB"},
+	})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status Created; got %v", resp.Status)
+	}
+	if !slices.Equal(link.Tags, []string{"Go", "web"}) {
+		t.Errorf("expected tags trimmed and deduplicated; got %v", link.Tags)
+	}
+
+	tooMany := []string{}
+	for i := 0; i < 21; i++ {
+		tooMany = append(tooMany, "tag"+strings.Repeat("x", i))
+	}
+	cases := []struct {
+		tags     []string
+		expected string
+	}{
+		{[]string{"ok", "  "}, "tag names must be 1 to 50 characters"},
+		{[]string{strings.Repeat("x", 51)}, "tag names must be 1 to 50 characters"},
+		{tooMany, "a link can have at most 20 tags"},
+	}
+	for _, tc := range cases {
+		resp, body := ts.do(t, "PATCH", "/api/v1/links/"+link.ShortCode, map[string]any{"tags": tc.tags}, "Authorization", token)
+		if resp.StatusCode != http.StatusBadRequest || message(t, body) != tc.expected {
+			t.Errorf("%v: expected %q; got %v: %s", tc.tags, tc.expected, resp.Status, body)
+		}
+	}
+	// repeats don't count towards the limit
+	repeated := []string{}
+	for i := 0; i < 30; i++ {
+		repeated = append(repeated, "same")
+	}
+	resp, body := ts.do(t, "PATCH", "/api/v1/links/"+link.ShortCode, map[string]any{"tags": repeated}, "Authorization", token)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected status OK for repeated tags; got %v: %s", resp.Status, body)
+	}
+}
+
+func TestLinkFolderMustBeOwned(t *testing.T) {
+	ts := newTestServer(t, nil)
+	token := signInVerified(t, ts, linkOwner)
+	own := ts.store.addFolder(linkOwner.Id, "mine")
+	foreign := ts.store.addFolder(linkOther.Id, "theirs")
+
+	resp, body := ts.do(t, "POST", "/api/v1/links", map[string]any{
+		"long_url":  "https://example.com/",
+		"folder_id": foreign,
+	}, "Authorization", token)
+	if resp.StatusCode != http.StatusBadRequest || message(t, body) != "folder not found" {
+		t.Errorf("expected folder not found on create; got %v: %s", resp.Status, body)
+	}
+
+	resp, link := createLink(t, ts, token, map[string]any{"long_url": "https://example.com/", "folder_id": own})
+	if resp.StatusCode != http.StatusCreated || link.FolderId == nil || *link.FolderId != own {
+		t.Fatalf("expected the link in folder %d; got %v: %+v", own, resp.Status, link)
+	}
+
+	resp, body = ts.do(t, "PATCH", "/api/v1/links/"+link.ShortCode, map[string]any{"folder_id": foreign}, "Authorization", token)
+	if resp.StatusCode != http.StatusBadRequest || message(t, body) != "folder not found" {
+		t.Errorf("expected folder not found on update; got %v: %s", resp.Status, body)
+	}
+	if stored := ts.store.link(link.ShortCode); stored.FolderId == nil || *stored.FolderId != own {
+		t.Errorf("expected the link to stay in its folder; got %v", stored.FolderId)
+	}
+
+	resp, body = ts.do(t, "PATCH", "/api/v1/links/"+link.ShortCode, map[string]any{"folder_id": 0}, "Authorization", token)
+	if resp.StatusCode != http.StatusOK || ts.store.link(link.ShortCode).FolderId != nil {
+		t.Errorf("expected folder_id 0 to take the link out of its folder; got %v: %s", resp.Status, body)
+	}
+}
+
+func TestBulkUpdateNeedsOwnLinks(t *testing.T) {
+	ts := newTestServer(t, nil)
+	token := signInVerified(t, ts, linkOwner)
+	now := time.Now()
+	own := ts.store.addLink(types.Link{ShortURL: "own1", OriginalURL: "https://example.com/", UserId: linkOwner.Id, IsEnabled: true})
+	ts.store.addLink(types.Link{ShortURL: "gone1", OriginalURL: "https://example.com/", UserId: linkOwner.Id, DeletedAt: &now})
+	ts.store.addLink(types.Link{ShortURL: "theirs1", OriginalURL: "https://example.com/", UserId: linkOther.Id, IsEnabled: true})
+
+	resp, body := ts.do(t, "POST", "/api/v1/links/bulk", map[string]any{
+		"codes":    []string{"own1", "theirs1", "missing1", "gone1"},
+		"add_tags": []string{"news"},
+	}, "Authorization", token)
+	var notFound struct {
+		Codes []string `json:"codes"`
+	}
+	decode(t, body, &notFound)
+	if resp.StatusCode != http.StatusNotFound || !slices.Equal(notFound.Codes, []string{"theirs1", "missing1", "gone1"}) {
+		t.Errorf("expected the foreign, missing and deleted codes in a 404; got %v: %s", resp.Status, body)
+	}
+	if tags, _ := ts.store.GetLinkTags(context.Background(), own); len(tags) != 0 {
+		t.Errorf("expected nothing changed; got tags %v", tags)
+	}
+
+	resp, body = ts.do(t, "POST", "/api/v1/links/bulk", map[string]any{
+		"codes":    []string{"own1"},
+		"add_tags": []string{"news"},
+	}, "Authorization", token)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status OK; got %v: %s", resp.Status, body)
+	}
+	if tags, _ := ts.store.GetLinkTags(context.Background(), own); !slices.Equal(tags, []string{"news"}) {
+		t.Errorf("expected the link tagged; got %v", tags)
+	}
+
+	resp, body = ts.do(t, "POST", "/api/v1/links/bulk", map[string]any{"codes": []string{}}, "Authorization", token)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status Bad Request without codes; got %v: %s", resp.Status, body)
+	}
+}
+
+func TestTagAnalytics(t *testing.T) {
+	ts := newTestServer(t, nil)
+	token := signInVerified(t, ts, linkOwner)
+	now := time.Now()
+	first := ts.store.addLink(types.Link{ShortURL: "first1", OriginalURL: "https://example.com/1", UserId: linkOwner.Id})
+	second := ts.store.addLink(types.Link{ShortURL: "second1", OriginalURL: "https://example.com/2", UserId: linkOwner.Id})
+	theirs := ts.store.addLink(types.Link{ShortURL: "theirs1", OriginalURL: "https://example.com/3", UserId: linkOther.Id})
+	ts.store.SetLinkTags(context.Background(), linkOwner.Id, first, []string{"news", "sport"})
+	ts.store.SetLinkTags(context.Background(), linkOwner.Id, second, []string{"news"})
+	ts.store.SetLinkTags(context.Background(), linkOther.Id, theirs, []string{"news"})
+	ts.store.addClick("first1", now)
+	ts.store.addClick("second1", now)
+	ts.store.addClick("second1", now.AddDate(0, 0, -10))
+	ts.store.addClick("theirs1", now)
+
+	resp, body := ts.do(t, "GET", "/api/v1/analytics/tags", nil, "Authorization", token)
+	var stats []types.TagClicks
+	decode(t, body, &stats)
+	expected := []types.TagClicks{{Tag: "news", Links: 2, Clicks: 3}, {Tag: "sport", Links: 1, Clicks: 1}}
+	if resp.StatusCode != http.StatusOK || !slices.Equal(stats, expected) {
+		t.Errorf("expected %+v; got %v: %s", expected, resp.Status, body)
+	}
+
+	from := now.AddDate(0, 0, -1).Format(time.DateOnly)
+	_, body = ts.do(t, "GET", "/api/v1/analytics/tags?from="+from, nil, "Authorization", token)
+	decode(t, body, &stats)
+	expected = []types.TagClicks{{Tag: "news", Links: 2, Clicks: 2}, {Tag: "sport", Links: 1, Clicks: 1}}
+	if !slices.Equal(stats, expected) {
+		t.Errorf("expected only recent clicks %+v; got %s", expected, body)
+	}
+
+	resp, _ = ts.do(t, "GET", "/api/v1/analytics/tags?to=yesterday", nil, "Authorization", token)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected status Bad Request for a bad date; got %v", resp.Status)
+	}
+}
+
+func TestCreateLinkRollsBackOnTagFailure(t *testing.T) {
+	ts := newTestServer(t, nil)
+	token := signInVerified(t, ts, linkOwner)
+	ts.store.failOn("SetLinkTags", errors.New("connection reset"))
+
+	resp, _ := createLink(t, ts, token, map[string]any{"long_url": "https://example.com/", "tags": []string{"news"}})
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected status Internal Server Error; got %v", resp.Status)
+	}
+	if n := len(ts.store.links); n != 0 {
+		t.Errorf("expected no link left behind; got %v", n)
+	}
+}
+
+func TestUpdateLinkRollsBack(t *testing.T) {
+	ts := newTestServer(t, nil)
+	token := signInVerified(t, ts, linkOwner)
+	folder := ts.store.addFolder(linkOwner.Id, "mine")
+	resp, link := createLink(t, ts, token, map[string]any{"long_url": "https://example.com/", "title": "Before", "tags": []string{"old"}})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("expected status Created; got %v", resp.Status)
+	}
+	ts.store.failOn("SetLinksFolder", errors.New("connection reset"))
+
+	resp, _ = ts.do(t, "PATCH", "/api/v1/links/"+link.ShortCode, map[string]any{
+		"title":        "After",
+		"original_url": "https://example.org/",
+		"tags":         []string{"new"},
+		"folder_id":    folder,
+	}, "Authorization", token)
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected status Internal Server Error; got %v", resp.Status)
+	}
+	stored := ts.store.link(link.ShortCode)
+	if stored.Title != "Before" || stored.OriginalURL != "https://example.com/" || stored.FolderId != nil {
+		t.Errorf("expected the link unchanged; got %+v", stored)
+	}
+	if tags, _ := ts.store.GetLinkTags(context.Background(), link.Id); !slices.Equal(tags, []string{"old"}) {
+		t.Errorf("expected the old tags kept; got %v", tags)
+	}
+}
+
+func TestBulkUpdateRollsBack(t *testing.T) {
+	ts := newTestServer(t, nil)
+	token := signInVerified(t, ts, linkOwner)
+	folder := ts.store.addFolder(linkOwner.Id, "mine")
+	first := ts.store.addLink(types.Link{ShortURL: "first1", OriginalURL: "https://example.com/1", UserId: linkOwner.Id, IsEnabled: true})
+	ts.store.addLink(types.Link{ShortURL: "second1", OriginalURL: "https://example.com/2", UserId: linkOwner.Id, IsEnabled: true})
+	ts.store.SetLinkTags(context.Background(), linkOwner.Id, first, []string{"old"})
+	ts.store.failOn("SetLinksFolder", errors.New("connection reset"))
+
+	resp, _ := ts.do(t, "POST", "/api/v1/links/bulk", map[string]any{
+		"codes":       []string{"first1", "second1"},
+		"add_tags":    []string{"new"},
+		"remove_tags": []string{"old"},
+		"folder_id":   folder,
+	}, "Authorization", token)
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected status Internal Server Error; got %v", resp.Status)
+	}
+	for _, code := range []string{"first1", "second1"} {
+		stored := ts.store.link(code)
+		if stored.FolderId != nil {
+			t.Errorf("%s: expected the link left out of the folder; got %v", code, *stored.FolderId)
+		}
+		tags, _ := ts.store.GetLinkTags(context.Background(), stored.Id)
+		if expected := map[string][]string{"first1": {"old"}, "second1": {}}[code]; !slices.Equal(tags, expected) {
+			t.Errorf("%s: expected tags %v kept; got %v", code, expected, tags)
+		}
+	}
+}
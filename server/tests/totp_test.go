@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koderkt/teenyurl/internal/auth"
+)
+
+// RFC 6238 appendix B secret for SHA1, base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeMatchesRFC6238(t *testing.T) {
+	cases := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+	for unix, want := range cases {
+		got, err := auth.TOTPCode(rfcSecret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatalf("error computing code. Err: %v", err)
+		}
+		if got != want {
+			t.Errorf("at %d expected %s; got %s", unix, want, got)
+		}
+	}
+}
+
+func TestValidateTOTPAllowsOneStepOfDrift(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, _ := auth.TOTPCode(rfcSecret, now)
+
+	step, ok := auth.ValidateTOTP(rfcSecret, code, now.Add(30*time.Second))
+	if !ok || step != now.Unix()/30 {
+		t.Errorf("expected code from the previous step to validate as step %d; got %d, %v", now.Unix()/30, step, ok)
+	}
+	if _, ok := auth.ValidateTOTP(rfcSecret, code, now.Add(2*time.Minute)); ok {
+		t.Errorf("expected stale code to be rejected")
+	}
+	if _, ok := auth.ValidateTOTP(rfcSecret, "000000", now); ok {
+		t.Errorf("expected wrong code to be rejected")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("error generating secret. Err: %v", err)
+	}
+	uri, err := url.Parse(auth.TOTPURI("teenyurl", "someone@example.com", secret))
+	if err != nil {
+		t.Fatalf("error parsing uri. Err: %v", err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" {
+		t.Errorf("expected otpauth://totp uri; got %v", uri)
+	}
+	if uri.Path != "/teenyurl:someone@example.com" {
+		t.Errorf("expected issuer:account label; got %q", uri.Path)
+	}
+	if uri.Query().Get("secret") != secret || uri.Query().Get("issuer") != "teenyurl" {
+		t.Errorf("expected secret and issuer parameters; got %v", uri.RawQuery)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := auth.GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("error generating recovery codes. Err: %v", err)
+	}
+	if len(codes) != 10 || len(hashes) != 10 {
+		t.Fatalf("expected 10 codes; got %d", len(codes))
+	}
+
+	seen := map[string]bool{}
+	for i, code := range codes {
+		if seen[code] {
+			t.Errorf("expected unique codes; %q repeated", code)
+		}
+		seen[code] = true
+		if auth.IsTOTPCode(code) {
+			t.Errorf("expected %q not to look like a totp code", code)
+		}
+		typed := strings.ToUpper(strings.ReplaceAll(code, "-", " "))
+		if auth.HashRecoveryCode(typed) != hashes[i] {
+			t.Errorf("expected %q to hash like %q", typed, code)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/koderkt/teenyurl/internal/database"
+	"github.com/koderkt/teenyurl/internal/types"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestDatabaseSpansJoinCallerTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+
+	db := database.WithTracing(newFakeStore())
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "GET /:shortCode")
+	if err := db.InsertAnalytics(ctx, &types.Clicks{ShortCode: "abc123"}); err != nil {
+		t.Fatalf("error inserting click. Err: %v", err)
+	}
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans; got %v", len(spans))
+	}
+	child := spans[0]
+	if child.Name() != "database.InsertAnalytics" {
+		t.Errorf("expected span database.InsertAnalytics; got %v", child.Name())
+	}
+	if child.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("expected database span to be a child of the request span")
+	}
+}
@@ -0,0 +1,183 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/koderkt/teenyurl/internal/auth"
+	"github.com/koderkt/teenyurl/internal/config"
+	"github.com/koderkt/teenyurl/internal/types"
+)
+
+var twoFactorUser = types.UserSession{Id: 7, UserName: "ada", Email: "ada@example.com"}
+
+type challengeResponse struct {
+	Success   bool   `json:"success"`
+	TwoFactor string `json:"two_factor"`
+	Challenge string `json:"challenge"`
+}
+
+// exchangeOIDCLogin stores a finished OIDC login for user, as the callback
+// does, and trades its code at /auth/oidc/exchange.
+func exchangeOIDCLogin(t *testing.T, ts *testServer, user types.UserSession) (*http.Response, []byte) {
+	data, err := json.Marshal(user)
+	if err != nil {
+		t.Fatalf("error encoding session. Err: %v", err)
+	}
+	if err := ts.redis.Set("oidc_login:one-time", string(data)); err != nil {
+		t.Fatalf("error storing oidc login. Err: %v", err)
+	}
+	return ts.do(t, "POST", "/auth/oidc/exchange", map[string]string{"code": "one-time"})
+}
+
+// startTwoFactor signs an enrolled user in through OIDC and returns the
+// challenge and the TOTP secret.
+func startTwoFactor(t *testing.T, ts *testServer) (string, string) {
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("error generating secret. Err: %v", err)
+	}
+	ts.store.enableTOTP(twoFactorUser.Id, secret)
+
+	resp, body := exchangeOIDCLogin(t, ts, twoFactorUser)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status OK; got %v: %s", resp.Status, body)
+	}
+	var challenge challengeResponse
+	decode(t, body, &challenge)
+	if challenge.TwoFactor != "verify" || challenge.Challenge == "" {
+		t.Fatalf("expected a verify challenge; got %s", body)
+	}
+	if resp.Header.Get("Authorization") != "" {
+		t.Fatalf("expected no session before the second step")
+	}
+	return challenge.Challenge, secret
+}
+
+func currentCode(t *testing.T, secret string) string {
+	code, err := auth.TOTPCode(secret, time.Now())
+	if err != nil {
+		t.Fatalf("error generating code. Err: %v", err)
+	}
+	return code
+}
+
+func TestOIDCSignInAsksForSecondFactor(t *testing.T) {
+	ts := newTestServer(t, nil)
+	challenge, secret := startTwoFactor(t, ts)
+
+	resp, body := ts.do(t, "POST", "/signin/2fa", map[string]string{
+		"challenge": challenge,
+		"code":      currentCode(t, secret),
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status OK; got %v: %s", resp.Status, body)
+	}
+	if resp.Header.Get("Authorization") == "" {
+		t.Errorf("expected a session once the code is accepted")
+	}
+}
+
+func TestOIDCSignInWithoutSecondFactor(t *testing.T) {
+	ts := newTestServer(t, nil)
+	resp, body := exchangeOIDCLogin(t, ts, twoFactorUser)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status OK; got %v: %s", resp.Status, body)
+	}
+	if resp.Header.Get("Authorization") == "" {
+		t.Errorf("expected a session; got %s", body)
+	}
+	if len(ts.store.attempts) != 1 || !ts.store.attempts[0].Success {
+		t.Errorf("expected a successful sign in to be recorded; got %+v", ts.store.attempts)
+	}
+
+	// the code is single use
+	resp, _ = ts.do(t, "POST", "/auth/oidc/exchange", map[string]string{"code": "one-time"})
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status Unauthorized for a used code; got %v", resp.Status)
+	}
+}
+
+func TestOIDCSignInRequiresEnrollment(t *testing.T) {
+	ts := newTestServer(t, func(cfg *config.Config) {
+		cfg.TwoFactor.Required = true
+	})
+	resp, body := exchangeOIDCLogin(t, ts, twoFactorUser)
+	var challenge challengeResponse
+	decode(t, body, &challenge)
+	if resp.StatusCode != http.StatusOK || challenge.TwoFactor != "setup" {
+		t.Errorf("expected a setup challenge; got %v: %s", resp.Status, body)
+	}
+	if resp.Header.Get("Authorization") != "" {
+		t.Errorf("expected no session before enrolling")
+	}
+}
+
+func TestTwoFactorChallengeAttemptLimit(t *testing.T) {
+	ts := newTestServer(t, func(cfg *config.Config) {
+		// keep the email lockout out of the way
+		cfg.Lockout.Threshold = 100
+	})
+	challenge, secret := startTwoFactor(t, ts)
+
+	for i := 0; i < 5; i++ {
+		resp, body := ts.do(t, "POST", "/signin/2fa", map[string]string{
+			"challenge": challenge,
+			"code":      "000000",
+		})
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: expected status Unauthorized; got %v: %s", i+1, resp.Status, body)
+		}
+	}
+
+	resp, body := ts.do(t, "POST", "/signin/2fa", map[string]string{
+		"challenge": challenge,
+		"code":      currentCode(t, secret),
+	})
+	var msg struct {
+		Message string `json:"message"`
+	}
+	decode(t, body, &msg)
+	if resp.StatusCode != http.StatusUnauthorized || msg.Message != "invalid or expired challenge, sign in again" {
+		t.Errorf("expected the challenge to be used up; got %v: %s", resp.Status, body)
+	}
+	if resp.Header.Get("Authorization") != "" {
+		t.Errorf("expected no session after too many attempts")
+	}
+}
+
+func TestTwoFactorRejectsReusedCode(t *testing.T) {
+	ts := newTestServer(t, nil)
+	challenge, secret := startTwoFactor(t, ts)
+	code := currentCode(t, secret)
+
+	resp, body := ts.do(t, "POST", "/signin/2fa", map[string]string{"challenge": challenge, "code": code})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status OK; got %v: %s", resp.Status, body)
+	}
+
+	// the challenge is gone once passed
+	resp, _ = ts.do(t, "POST", "/signin/2fa", map[string]string{"challenge": challenge, "code": code})
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status Unauthorized for a passed challenge; got %v", resp.Status)
+	}
+
+	// and the step can't be replayed in a new one
+	_, body = exchangeOIDCLogin(t, ts, twoFactorUser)
+	var next challengeResponse
+	decode(t, body, &next)
+	resp, body = ts.do(t, "POST", "/signin/2fa", map[string]string{"challenge": next.Challenge, "code": code})
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status Unauthorized for a reused code; got %v: %s", resp.Status, body)
+	}
+	totp, err := ts.store.GetTOTP(context.Background(), twoFactorUser.Id)
+	if err != nil {
+		t.Fatalf("error fetching totp. Err: %v", err)
+	}
+	if totp.LastUsedStep == 0 {
+		t.Errorf("expected the used step to be recorded")
+	}
+}